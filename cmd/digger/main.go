@@ -34,10 +34,12 @@ func main() {
 
 	cli.Exec(
 		cli.CommandSet{
-			"file":    subcmd.FileCmd(ctx),
-			"kafka":   subcmd.KafkaCmd(ctx),
-			"s3":      subcmd.S3Cmd(ctx),
-			"version": subcmd.VersionCmd(ctx),
+			"file":       subcmd.FileCmd(ctx),
+			"kafka":      subcmd.KafkaCmd(ctx),
+			"kafka-info": subcmd.KafkaInfoCmd(ctx),
+			"s3":         subcmd.S3Cmd(ctx),
+			"s3-ls":      subcmd.S3LsCmd(ctx),
+			"version":    subcmd.VersionCmd(ctx),
 		},
 	)
 }
@@ -1,45 +1,241 @@
 package subcmd
 
 import (
+	"fmt"
+	"os"
 	"plugin"
 	"strings"
+	"time"
 
 	dig "github.com/segmentio/data-digger/pkg/digger"
+	"github.com/segmentio/data-digger/pkg/json"
+	sjson "github.com/segmentio/encoding/json"
 	log "github.com/sirupsen/logrus"
 )
 
 type commonConfig struct {
-	Debug        bool   `flag:"--debug"             help:"turn on debug logging" default:"false"`
-	Filter       string `flag:"-f,--filter"         help:"filter regexp to apply before generating stats" default:"-"`
-	K            int    `flag:"-k,--num-categories" help:"number of top values to show" default:"25"`
-	Numeric      bool   `flag:"--numeric"           help:"treat values as numbers instead of strings" default:"false"`
-	PathsStr     string `flag:"--paths"             help:"comma-separated list of paths to generate stats for" default:"-"`
-	Plugins      string `flag:"--plugins"           help:"comma-separated list of golang plugins to load at start" default:"-"`
-	PrintMissing bool   `flag:"--print-missing"     help:"print out messages that missing all paths" default:"false"`
-	Raw          bool   `flag:"--raw"               help:"show raw messages that pass filters" default:"false"`
-	RawExtended  bool   `flag:"--raw-extended"      help:"show extended info about messages that pass filters" default:"false"`
-	SortByName   bool   `flag:"--sort-by-name"      help:"sort top k values by their category/key names" default:"false"`
-}
-
-func makeProcessors(config commonConfig, protoTypes []string) ([]dig.Processor, error) {
+	AfterContext    int           `flag:"-A,--after-context"  help:"number of messages to show after each raw message in the same partition or file" default:"0"`
+	BeforeContext   int           `flag:"-B,--before-context" help:"number of messages to show before each raw message in the same partition or file" default:"0"`
+	Context         int           `flag:"-C,--context"        help:"number of messages to show before and after each raw message; overridden by -A and -B" default:"0"`
+	Debug           bool          `flag:"--debug"             help:"turn on debug logging" default:"false"`
+	DedupeDrop      bool          `flag:"--dedupe-drop"       help:"drop messages with duplicate --dedupe-key values before generating stats" default:"false"`
+	DedupeKey       string        `flag:"--dedupe-key"        help:"path of a unique message id to find duplicates by, e.g. messageId" default:"-"`
+	DedupeMaxMemory int           `flag:"--dedupe-max-memory" help:"memory budget in MB for exact duplicate detection; a bloom filter is used after that" default:"256"`
+	ErrorReport     string        `flag:"--error-report"      help:"file to write the sources that were skipped due to errors to, as json" default:"-"`
+	Filter          string        `flag:"-f,--filter"         help:"filter regexp to apply before generating stats" default:"-"`
+	K               int           `flag:"-k,--num-categories" help:"number of top values to show" default:"25"`
+	Largest         int           `flag:"--largest"           help:"number of largest messages to list with --sizes" default:"10"`
+	MaxDuration     time.Duration `flag:"--max-duration"      help:"stop after this amount of time, e.g. 5m; 0 for no limit" default:"0"`
+	MaxMatches      int64         `flag:"--max-matches"       help:"stop after this many messages pass the filters, like grep -m; 0 for no limit" default:"0"`
+	MaxMessages     int64         `flag:"--max-messages"      help:"stop after processing this many messages; 0 for no limit" default:"0"`
+	NumProcessors   int           `flag:"--num-processors"    help:"number of workers that process messages in parallel; raw output keeps the message order" default:"1"`
+	Numeric         bool          `flag:"--numeric"           help:"treat values as numbers instead of strings" default:"false"`
+	PathFilters     []string      `flag:"--path-filter"       help:"path=regexp filter to apply before generating stats; can be repeated" default:"-"`
+	PathsStr        string        `flag:"--paths"             help:"comma-separated list of paths to generate stats for" default:"-"`
+	Plugins         string        `flag:"--plugins"           help:"comma-separated list of golang plugins to load at start" default:"-"`
+	PrintMissing    bool          `flag:"--print-missing"     help:"print out messages that missing all paths" default:"false"`
+	ProtoTypes      string        `flag:"--proto-types"       help:"comma-separated list of registered proto types" default:"-"`
+	Raw             bool          `flag:"--raw"               help:"show raw messages that pass filters" default:"false"`
+	RawExtended     bool          `flag:"--raw-extended"      help:"show extended info about messages that pass filters" default:"false"`
+	SampleBy        string        `flag:"--sample-by"         help:"path to sample by; all messages with a sampled value at the path are kept" default:"-"`
+	SampleRate      float64       `flag:"--sample-rate"       help:"fraction of messages (or of --sample-by values) to process, e.g. 0.01" default:"1"`
+	SelectStr       string        `flag:"--select"            help:"comma-separated list of paths to project raw messages onto" default:"-"`
+	Sizes           bool          `flag:"--sizes"             help:"show message size histograms and percentiles, overall and for the top k values" default:"false"`
+	SortByName      bool          `flag:"--sort-by-name"      help:"sort top k values by their category/key names" default:"false"`
+	Weight          string        `flag:"--weight"            help:"what to rank top k values by; one of count, bytes" default:"count"`
+}
+
+// kafkaMessageConfig stores the processor options for the keys and headers of Kafka messages.
+type kafkaMessageConfig struct {
+	HeaderEncoding string   `flag:"--header-encoding" help:"encoding of header values in raw extended output; one of string, json, base64" default:"string"`
+	HeaderFilters  []string `flag:"--header-filter"   help:"key=regexp header filter to apply before decoding messages; can be repeated" default:"-"`
+	Key            string   `flag:"--key"             help:"only read messages with this key from the partition that it hashes to" default:"-"`
+}
+
+func makeProcessors(config commonConfig, kafkaMessages kafkaMessageConfig) ([]dig.Processor, error) {
 	liveStats, err := dig.NewLiveStats(
 		dig.LiveStatsConfig{
-			K:            config.K,
-			Filter:       config.Filter,
-			Numeric:      config.Numeric,
-			PrintMissing: config.PrintMissing,
-			ProtoTypes:   protoTypes,
-			PathsStr:     config.PathsStr,
-			Raw:          config.Raw,
-			RawExtended:  config.RawExtended,
-			SortByName:   config.SortByName,
+			K:              config.K,
+			Filter:         config.Filter,
+			HeaderEncoding: kafkaMessages.HeaderEncoding,
+			HeaderFilters:  kafkaMessages.HeaderFilters,
+			Largest:        config.Largest,
+			ContextAfter:   contextSize(config.AfterContext, config.Context),
+			ContextBefore:  contextSize(config.BeforeContext, config.Context),
+			Numeric:        config.Numeric,
+			NumWorkers:     config.NumProcessors,
+			PathFilters:    config.PathFilters,
+			PrintMissing:   config.PrintMissing,
+			ProtoTypes:     strings.Split(config.ProtoTypes, ","),
+			PathsStr:       config.PathsStr,
+			Raw:            config.Raw,
+			RawExtended:    config.RawExtended,
+			SampleBy:       config.SampleBy,
+			SampleRate:     config.SampleRate,
+			SelectStr:      config.SelectStr,
+			Sizes:          config.Sizes,
+			SortByName:     config.SortByName,
+			Weight:         config.Weight,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.DedupeKey == "" {
+		return []dig.Processor{liveStats}, nil
+	}
+
+	// The deduper goes first so that it can drop duplicates before they're counted
+	deduper, err := dig.NewDeduper(
+		dig.DedupeConfig{
+			Drop:       config.DedupeDrop,
+			K:          config.K,
+			KeyPath:    config.DedupeKey,
+			MaxMemory:  config.DedupeMaxMemory * 1024 * 1024,
+			NumWorkers: config.NumProcessors,
+			ProtoTypes: strings.Split(config.ProtoTypes, ","),
 		},
 	)
 	if err != nil {
 		return nil, err
 	}
 
-	return []dig.Processor{liveStats}, nil
+	return []dig.Processor{deduper, liveStats}, nil
+}
+
+// contextSize returns the number of context messages to show on one side of each raw message,
+// given the -A or -B value and the -C value.
+func contextSize(size int, both int) int {
+	if size > 0 {
+		return size
+	}
+	return both
+}
+
+// makeLimits converts the limit-related flag values into a dig.Limits struct.
+func makeLimits(config commonConfig) dig.Limits {
+	return dig.Limits{
+		MaxDuration: config.MaxDuration,
+		MaxMatches:  config.MaxMatches,
+		MaxMessages: config.MaxMessages,
+	}
+}
+
+// reportFailures logs the sources that were skipped due to errors and, if path is set, writes
+// them to it as JSON.
+func reportFailures(failures *dig.FailureReport, path string) {
+	sourceFailures := failures.Failures()
+
+	if len(sourceFailures) > 0 {
+		log.Warnf(
+			"%d source(s) skipped due to errors:\n%s",
+			len(sourceFailures),
+			failures.PrettyTable(),
+		)
+	}
+
+	if path != "" {
+		contents, err := sjson.MarshalIndent(sourceFailures, "", "  ")
+		if err != nil {
+			log.Fatalf("Error encoding error report: %+v", err)
+		}
+		if err := os.WriteFile(path, contents, 0644); err != nil {
+			log.Fatalf("Error writing error report: %+v", err)
+		}
+	}
+}
+
+// makeCheckpoint loads the checkpoint at the argument path; it returns nil if the path is
+// empty.
+func makeCheckpoint(path string) (*dig.Checkpoint, error) {
+	if path == "" {
+		return nil, nil
+	}
+	return dig.LoadCheckpoint(path)
+}
+
+// parquetColumns returns the top-level columns that need to be read from parquet inputs for the
+// argument config. If all columns are needed (e.g., because the filter is applied to the full
+// message or the message sizes are measured), then nil is returned.
+func parquetColumns(config commonConfig) []string {
+	if config.Filter != "" ||
+		config.PrintMissing ||
+		config.Sizes ||
+		config.Weight == dig.WeightBytes ||
+		((config.Raw || config.RawExtended) && config.SelectStr == "") {
+		return nil
+	}
+
+	paths := []string{}
+
+	if config.PathsStr != "" {
+		for _, pathGroupStr := range strings.Split(config.PathsStr, ";") {
+			paths = append(paths, strings.Split(pathGroupStr, ",")...)
+		}
+	}
+	for _, filterStr := range config.PathFilters {
+		paths = append(paths, strings.SplitN(filterStr, "=", 2)[0])
+	}
+	if config.SelectStr != "" {
+		paths = append(paths, strings.Split(config.SelectStr, ",")...)
+	}
+	if config.SampleBy != "" {
+		paths = append(paths, config.SampleBy)
+	}
+	if config.DedupeKey != "" {
+		paths = append(paths, config.DedupeKey)
+	}
+
+	columns := []string{}
+
+	for _, path := range paths {
+		if json.IsMetaPath(path) {
+			// Metadata doesn't come from any columns
+			continue
+		}
+
+		root, ok := json.PathRoot(path)
+		if !ok {
+			return nil
+		}
+		columns = append(columns, root)
+	}
+
+	if len(columns) == 0 {
+		return nil
+	}
+	return columns
+}
+
+// makeCSVOptions converts the CSV-related flag values into a dig.CSVOptions struct.
+func makeCSVOptions(
+	columns string,
+	delimiter string,
+	inferTypes bool,
+) (dig.CSVOptions, error) {
+	options := dig.CSVOptions{
+		InferTypes: inferTypes,
+	}
+
+	if columns != "" {
+		options.Columns = strings.Split(columns, ",")
+	}
+
+	if delimiter == `\t` {
+		// Allow tabs to be passed without shell escaping
+		delimiter = "\t"
+	}
+
+	if delimiter != "" {
+		runes := []rune(delimiter)
+		if len(runes) != 1 {
+			return options, fmt.Errorf("Delimiter must be a single character: %s", delimiter)
+		}
+		options.Delimiter = runes[0]
+	}
+
+	return options, nil
 }
 
 func loadPlugins(pathsStr string) error {
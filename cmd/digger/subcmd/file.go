@@ -12,8 +12,16 @@ import (
 type fileConfig struct {
 	commonConfig
 
-	FilePaths string `flag:"--file-paths" help:"comma-separated list of file paths"`
-	Recursive bool   `flag:"--recursive"  help:"scan subdirectories recursively" default:"false"`
+	Checkpoint        string `flag:"--checkpoint"         help:"file to save progress to and resume from; stdin is never checkpointed" default:"-"`
+	Columns           string `flag:"--columns"            help:"comma-separated list of csv/tsv column names; if unset, the header row is used" default:"-"`
+	Delimiter         string `flag:"--delimiter"          help:"csv/tsv field delimiter; defaults to a comma for csv and a tab for tsv" default:"-"`
+	FilePaths         string `flag:"--file-paths"         help:"comma-separated list of file paths; use - for stdin"`
+	Format            string `flag:"--format"             help:"input format; one of lines, json-stream, protodelim, parquet, csv, tsv" default:"lines"`
+	InferTypes        bool   `flag:"--infer-types"        help:"convert numeric and boolean csv/tsv fields to json numbers and booleans" default:"false"`
+	MaxMessageSize    int    `flag:"--max-message-size"   help:"max size of a single message in bytes; larger ones are skipped" default:"524288"`
+	OnError           string `flag:"--on-error"           help:"what to do when a file can't be read; one of fail, skip, retry, retry:N; files are skipped after the retries" default:"fail"`
+	Recursive         bool   `flag:"--recursive"          help:"scan subdirectories recursively" default:"false"`
+	TruncateOversized bool   `flag:"--truncate-oversized" help:"truncate messages over the max size instead of skipping them" default:"false"`
 }
 
 // FileCmd defines a CLI function for digging through local files.
@@ -30,17 +38,53 @@ func FileCmd(ctx context.Context) cli.Function {
 				log.Fatalf("Could not load plugins: %+v", err)
 			}
 
-			processors, err := makeProcessors(config.commonConfig, []string{})
+			filePaths := strings.Split(config.FilePaths, ",")
+			if err := dig.ValidateFilePaths(filePaths, config.Format); err != nil {
+				log.Fatalf("Invalid file paths: %+v", err)
+			}
+
+			processors, err := makeProcessors(config.commonConfig, kafkaMessageConfig{})
 			if err != nil {
 				log.Fatalf("Error creating processors: %+v", err)
 			}
 
+			csvOptions, err := makeCSVOptions(
+				config.Columns,
+				config.Delimiter,
+				config.InferTypes,
+			)
+			if err != nil {
+				log.Fatalf("Invalid csv options: %+v", err)
+			}
+
+			checkpoint, err := makeCheckpoint(config.Checkpoint)
+			if err != nil {
+				log.Fatalf("Error loading checkpoint: %+v", err)
+			}
+
+			onError, err := dig.ParseErrorPolicy(config.OnError)
+			if err != nil {
+				log.Fatalf("Invalid error policy: %+v", err)
+			}
+			failures := &dig.FailureReport{}
+
 			digger := &dig.Digger{
 				SourceConsumer: &dig.FileConsumer{
-					Paths:     strings.Split(config.FilePaths, ","),
-					Recursive: config.Recursive,
+					Paths:             filePaths,
+					Recursive:         config.Recursive,
+					Format:            config.Format,
+					MaxMessageSize:    config.MaxMessageSize,
+					TruncateOversized: config.TruncateOversized,
+					ParquetColumns:    parquetColumns(config.commonConfig),
+					CSV:               csvOptions,
+					Checkpoint:        checkpoint,
+					OnError:           onError,
+					Failures:          failures,
 				},
 				Processors: processors,
+				Checkpoint: checkpoint,
+				NumWorkers: config.NumProcessors,
+				Limits:     makeLimits(config.commonConfig),
 			}
 
 			if !config.Raw {
@@ -52,6 +96,7 @@ func FileCmd(ctx context.Context) cli.Function {
 			if err := digger.Run(ctx); err != nil && ctx.Err() == nil {
 				log.Fatalf("Error running digger: %v", err)
 			}
+			log.Infof("Digger stopped: %s", digger.StopReason())
 
 			for _, processor := range processors {
 				processor.Stop()
@@ -60,6 +105,8 @@ func FileCmd(ctx context.Context) cli.Function {
 			for _, processor := range processors {
 				log.Infof("Processor summary:\n%s", processor.Summary())
 			}
+
+			reportFailures(failures, config.ErrorReport)
 		},
 	)
 }
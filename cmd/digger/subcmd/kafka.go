@@ -2,7 +2,9 @@ package subcmd
 
 import (
 	"context"
-	"strings"
+	"errors"
+	"fmt"
+	"sort"
 	"time"
 
 	"github.com/segmentio/cli"
@@ -14,14 +16,17 @@ import (
 
 type kafkaConfig struct {
 	commonConfig
+	kafkaMessageConfig
 
-	Address    string `flag:"-a,--address"    help:"kafka address"`
-	Offset     int64  `flag:"-o,--offset"     help:"kafka offset" default:"-1"`
-	Partitions string `flag:"-p,--partitions" help:"comma-separated list of partitions" default:"-"`
-	ProtoTypes string `flag:"--proto-types"   help:"comma-separated list of registered proto types" default:"-"`
-	SinceStr   string `flag:"--since"         help:"time to start at; can be either RFC3339 timestamp or duration relative to now" default:"-"`
-	Topic      string `flag:"-t,--topic"      help:"kafka topic"`
-	UntilStr   string `flag:"--until"         help:"time to end at; can be either RFC3339 timestamp or duration relative to now" default:"-"`
+	Address     string `flag:"-a,--address"    help:"kafka address"`
+	Offset      int64  `flag:"-o,--offset"     help:"kafka offset" default:"-1"`
+	OffsetsStr  string `flag:"--offsets"       help:"comma-separated list of [topic:]partition:offset entries, e.g. 3:55012,4:first,*:-1000" default:"-"`
+	OnError     string `flag:"--on-error"      help:"what to do when a partition can't be read; one of fail, skip, retry, retry:N" default:"retry"`
+	Partitioner string `flag:"--partitioner"   help:"partitioner used to find the partition for --key; one of murmur2, crc32, fnv" default:"murmur2"`
+	Partitions  string `flag:"-p,--partitions" help:"comma-separated list of partitions" default:"-"`
+	SinceStr    string `flag:"--since"         help:"time to start at; can be either RFC3339 timestamp or duration relative to now" default:"-"`
+	Topic       string `flag:"-t,--topic"      help:"comma-separated list of kafka topics or topic regexps"`
+	UntilStr    string `flag:"--until"         help:"time to end at; can be either RFC3339 timestamp or duration relative to now" default:"-"`
 }
 
 // KafkaCmd defines a CLI function for digging through Kafka messages.
@@ -55,10 +60,17 @@ func KafkaCmd(ctx context.Context) cli.Function {
 				log.Fatalf("Until must be after since")
 			}
 
+			offsets, err := dig.ParseOffsets(config.OffsetsStr)
+			if err != nil {
+				log.Fatalf("Error parsing offsets flag: %+v", err)
+			}
+
 			partitions, total, err := readKafkaPartitions(
 				config.Address,
 				config.Topic,
 				config.Partitions,
+				config.Key,
+				config.Partitioner,
 			)
 			if err != nil {
 				log.Fatalf(
@@ -70,32 +82,41 @@ func KafkaCmd(ctx context.Context) cli.Function {
 
 			if !config.Raw {
 				log.Infof(
-					"Reading from %d partitions (out of %d total)",
+					"Reading from %d partitions in %d topic(s) (out of %d total)",
 					len(partitions),
+					countTopics(partitions),
 					total,
 				)
 			}
 
-			processors, err := makeProcessors(
-				config.commonConfig,
-				strings.Split(config.ProtoTypes, ","),
-			)
+			processors, err := makeProcessors(config.commonConfig, config.kafkaMessageConfig)
 			if err != nil {
 				log.Fatalf("Error creating processors: %+v", err)
 			}
 
+			onError, err := dig.ParseErrorPolicy(config.OnError)
+			if err != nil {
+				log.Fatalf("Invalid error policy: %+v", err)
+			}
+			failures := &dig.FailureReport{}
+
 			digger := &dig.Digger{
 				SourceConsumer: &dig.KafkaConsumer{
 					Address:    config.Address,
-					Topic:      config.Topic,
+					Key:        []byte(config.Key),
 					Offset:     config.Offset,
+					Offsets:    offsets,
 					Since:      since,
 					Until:      until,
 					Partitions: partitions,
 					MinBytes:   10e3,
 					MaxBytes:   10e6,
+					OnError:    onError,
+					Failures:   failures,
 				},
 				Processors: processors,
+				NumWorkers: config.NumProcessors,
+				Limits:     makeLimits(config.commonConfig),
 			}
 
 			if !config.Raw {
@@ -105,6 +126,7 @@ func KafkaCmd(ctx context.Context) cli.Function {
 			if err := digger.Run(ctx); err != nil && ctx.Err() == nil {
 				log.Fatalf("Error running digger: %v", err)
 			}
+			log.Infof("Digger stopped: %s", digger.StopReason())
 
 			for _, processor := range processors {
 				processor.Stop()
@@ -113,27 +135,62 @@ func KafkaCmd(ctx context.Context) cli.Function {
 			for _, processor := range processors {
 				log.Infof("Processor summary:\n%s", processor.Summary())
 			}
+
+			reportFailures(failures, config.ErrorReport)
 		},
 	)
 }
 
+// readKafkaPartitions returns the partitions of all topics that match the argument topic
+// names or regexps, filtered by the argument partition ranges, along with the total number of
+// partitions in the matching topics. If key is set, then only the partition that the key is
+// assigned to by the argument partitioner is returned for each topic.
 func readKafkaPartitions(
-	address, topic, partitions string,
+	address, topics, partitions, key, partitioner string,
 ) ([]kafka.Partition, int, error) {
-	conn, err := kafka.Dial("tcp", address)
+	topicMatcher, err := util.ParseTopics(topics)
 	if err != nil {
 		return nil, 0, err
 	}
 
-	log.Debugf("Fetching partitions for %s from %s", topic, address)
+	log.Debugf("Fetching partitions for %s from %s", topics, address)
 
-	availablePartitions, err := conn.ReadPartitions(topic)
+	// Listing all of the topics can be expensive on big clusters, so that's only done if it's
+	// needed to match regexps
+	allPartitions, err := readAllKafkaPartitions(address, topicMatcher.Literals())
 	if err != nil {
 		return nil, 0, err
 	}
 
+	availablePartitions := []kafka.Partition{}
+	for _, partition := range allPartitions {
+		if topicMatcher.Match(partition.Topic) {
+			availablePartitions = append(availablePartitions, partition)
+		}
+	}
+
+	if len(availablePartitions) == 0 {
+		return nil, 0, fmt.Errorf("No topics match %s", topics)
+	}
+
+	sort.Slice(availablePartitions, func(a, b int) bool {
+		if availablePartitions[a].Topic != availablePartitions[b].Topic {
+			return availablePartitions[a].Topic < availablePartitions[b].Topic
+		}
+		return availablePartitions[a].ID < availablePartitions[b].ID
+	})
+
+	requestedPartitions := availablePartitions
+
+	if key != "" {
+		requestedPartitions, err = keyPartitions(availablePartitions, key, partitioner)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
 	if partitions == "" {
-		return availablePartitions, len(availablePartitions), nil
+		return requestedPartitions, len(availablePartitions), nil
 	}
 
 	partitionIDs, err := util.ParseRangeStr(partitions)
@@ -141,13 +198,78 @@ func readKafkaPartitions(
 		return nil, 0, err
 	}
 
-	requestedPartitions := []kafka.Partition{}
+	filteredPartitions := []kafka.Partition{}
 
-	for _, partition := range availablePartitions {
+	for _, partition := range requestedPartitions {
 		if _, ok := partitionIDs[partition.ID]; ok {
+			filteredPartitions = append(filteredPartitions, partition)
+		}
+	}
+
+	return filteredPartitions, len(availablePartitions), nil
+}
+
+// readAllKafkaPartitions returns the partitions of the argument topics or, if there aren't any,
+// of all topics in the cluster. Topics that don't exist are left out; unlike Conn.ReadPartitions,
+// the metadata request doesn't ask the brokers to create them.
+func readAllKafkaPartitions(address string, topics []string) ([]kafka.Partition, error) {
+	client := &kafka.Client{Addr: kafka.TCP(address)}
+
+	metadata, err := client.Metadata(
+		context.Background(),
+		&kafka.MetadataRequest{Topics: topics},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	partitions := []kafka.Partition{}
+	for _, topic := range metadata.Topics {
+		if errors.Is(topic.Error, kafka.UnknownTopicOrPartition) {
+			continue
+		} else if topic.Error != nil {
+			return nil, fmt.Errorf("Error reading partitions of %s: %+v", topic.Name, topic.Error)
+		}
+		partitions = append(partitions, topic.Partitions...)
+	}
+	return partitions, nil
+}
+
+// keyPartitions returns the partition that the argument key is assigned to in each topic.
+func keyPartitions(
+	partitions []kafka.Partition,
+	key string,
+	partitioner string,
+) ([]kafka.Partition, error) {
+	partitionIDs := map[string][]int{}
+	for _, partition := range partitions {
+		partitionIDs[partition.Topic] = append(partitionIDs[partition.Topic], partition.ID)
+	}
+
+	keyPartitionIDs := map[string]int{}
+	for topic, ids := range partitionIDs {
+		partitionID, err := dig.KeyPartition([]byte(key), partitioner, ids)
+		if err != nil {
+			return nil, err
+		}
+		log.Debugf("Key %s is in partition %d of %s", key, partitionID, topic)
+		keyPartitionIDs[topic] = partitionID
+	}
+
+	requestedPartitions := []kafka.Partition{}
+	for _, partition := range partitions {
+		if keyPartitionIDs[partition.Topic] == partition.ID {
 			requestedPartitions = append(requestedPartitions, partition)
 		}
 	}
 
-	return requestedPartitions, len(availablePartitions), nil
+	return requestedPartitions, nil
+}
+
+func countTopics(partitions []kafka.Partition) int {
+	topics := map[string]struct{}{}
+	for _, partition := range partitions {
+		topics[partition.Topic] = struct{}{}
+	}
+	return len(topics)
 }
@@ -0,0 +1,91 @@
+package subcmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/segmentio/cli"
+	dig "github.com/segmentio/data-digger/pkg/digger"
+	"github.com/segmentio/data-digger/pkg/util"
+	sjson "github.com/segmentio/encoding/json"
+	log "github.com/sirupsen/logrus"
+)
+
+type kafkaInfoConfig struct {
+	Address    string `flag:"-a,--address"    help:"kafka address"`
+	Debug      bool   `flag:"--debug"         help:"turn on debug logging" default:"false"`
+	JSON       bool   `flag:"--json"          help:"output results as json" default:"false"`
+	Partitions string `flag:"-p,--partitions" help:"comma-separated list of partitions" default:"-"`
+	SinceStr   string `flag:"--since"         help:"show the offsets that this time maps to; can be either RFC3339 timestamp or duration relative to now" default:"-"`
+	Topic      string `flag:"-t,--topic"      help:"comma-separated list of kafka topics or topic regexps; if unset, all topics are listed" default:"-"`
+}
+
+// KafkaInfoCmd defines a CLI function for inspecting Kafka topics and partitions.
+func KafkaInfoCmd(ctx context.Context) cli.Function {
+	return cli.Command(
+		func(config kafkaInfoConfig) {
+			if config.Debug {
+				log.SetLevel(log.DebugLevel)
+			} else {
+				log.SetLevel(log.InfoLevel)
+			}
+
+			since, err := util.ParseTimeOrDuration(config.SinceStr, time.Now().UTC())
+			if err != nil {
+				log.Fatalf("Error parsing since flag: %+v", err)
+			}
+
+			if config.Topic == "" {
+				partitions, err := readAllKafkaPartitions(config.Address, nil)
+				if err != nil {
+					log.Fatalf("Failed to read partitions for %s: %v", config.Address, err)
+				}
+
+				topicInfos := dig.GetKafkaTopicInfo(partitions)
+				if config.JSON {
+					printJSON(topicInfos)
+				} else {
+					fmt.Print(dig.KafkaTopicsTable(topicInfos))
+				}
+				return
+			}
+
+			partitions, _, err := readKafkaPartitions(
+				config.Address,
+				config.Topic,
+				config.Partitions,
+				"",
+				"",
+			)
+			if err != nil {
+				log.Fatalf("Failed to read partitions for %s: %v", config.Address, err)
+			}
+
+			partitionInfos, err := dig.GetKafkaPartitionInfo(
+				ctx,
+				config.Address,
+				partitions,
+				since,
+			)
+			if err != nil {
+				log.Fatalf("Failed to get partition info: %v", err)
+			}
+
+			if config.JSON {
+				printJSON(partitionInfos)
+			} else {
+				fmt.Print(dig.KafkaPartitionsTable(partitionInfos, !since.IsZero()))
+			}
+		},
+	)
+}
+
+func printJSON(value interface{}) {
+	encoder := sjson.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(value); err != nil {
+		log.Fatalf("Error encoding json: %+v", err)
+	}
+}
@@ -2,21 +2,44 @@ package subcmd
 
 import (
 	"context"
+	"fmt"
+	"regexp"
 	"strings"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/segmentio/cli"
 	dig "github.com/segmentio/data-digger/pkg/digger"
+	"github.com/segmentio/data-digger/pkg/util"
 	log "github.com/sirupsen/logrus"
 )
 
 type s3Config struct {
 	commonConfig
+	s3ClientConfig
 
-	Bucket     string `flag:"-b,--bucket"   help:"s3 bucket"`
-	NumWorkers int    `flag:"--num-workers" help:"number of objects to read in parallel" default:"4"`
-	Prefixes   string `flag:"-p,--prefixes" help:"comma-separated list of prefixes"`
+	Bucket            string `flag:"-b,--bucket"          help:"s3 bucket"`
+	Checkpoint        string `flag:"--checkpoint"         help:"file to save progress to and resume from" default:"-"`
+	Columns           string `flag:"--columns"            help:"comma-separated list of csv/tsv column names; if unset, the header row is used" default:"-"`
+	Delimiter         string `flag:"--delimiter"          help:"csv/tsv field delimiter; defaults to a comma for csv and a tab for tsv" default:"-"`
+	Exclude           string `flag:"--exclude"            help:"regexp for object keys to skip" default:"-"`
+	Format            string `flag:"--format"             help:"input format; one of lines, json-stream, protodelim, parquet, csv, tsv" default:"lines"`
+	Include           string `flag:"--include"            help:"regexp for object keys to read; if unset, all keys are read" default:"-"`
+	InferTypes        bool   `flag:"--infer-types"        help:"convert numeric and boolean csv/tsv fields to json numbers and booleans" default:"false"`
+	InventoryManifest string `flag:"--inventory-manifest" help:"s3:// url of an s3 inventory manifest.json to get the objects from instead of listing the prefixes" default:"-"`
+	KeyLayout         string `flag:"--key-layout"         help:"time layout of the keys under each prefix, e.g. dt={YYYY-MM-DD}/hour={HH}" default:"-"`
+	MaxMessageSize    int    `flag:"--max-message-size"   help:"max size of a single message in bytes; larger ones are skipped" default:"524288"`
+	MaxSize           int64  `flag:"--max-size"           help:"only read objects that are at most this many bytes; 0 for no limit" default:"0"`
+	MinSize           int64  `flag:"--min-size"           help:"only read objects that are at least this many bytes" default:"0"`
+	NumWorkers        int    `flag:"--num-workers"        help:"number of objects to read in parallel" default:"4"`
+	OnError           string `flag:"--on-error"           help:"what to do when an object can't be read; one of fail, skip, retry, retry:N; objects are skipped after the retries" default:"fail"`
+	Prefixes          string `flag:"-p,--prefixes"        help:"comma-separated list of prefixes"`
+	SinceStr          string `flag:"--since"              help:"only read objects at or after this time; can be either RFC3339 timestamp or duration relative to now" default:"-"`
+	TruncateOversized bool   `flag:"--truncate-oversized" help:"truncate messages over the max size instead of skipping them" default:"false"`
+	UntilStr          string `flag:"--until"              help:"only read objects at or before this time; can be either RFC3339 timestamp or duration relative to now" default:"-"`
 }
 
 // S3Cmd defines a CLI function for digging through S3 objects.
@@ -33,22 +56,78 @@ func S3Cmd(ctx context.Context) cli.Function {
 				log.Fatalf("Could not load plugins: %+v", err)
 			}
 
-			processors, err := makeProcessors(config.commonConfig, []string{})
+			processors, err := makeProcessors(config.commonConfig, kafkaMessageConfig{})
 			if err != nil {
 				log.Fatalf("Error creating processors: %+v", err)
 			}
 
-			sess := session.Must(session.NewSession())
-			s3Client := s3.New(sess)
+			csvOptions, err := makeCSVOptions(
+				config.Columns,
+				config.Delimiter,
+				config.InferTypes,
+			)
+			if err != nil {
+				log.Fatalf("Invalid csv options: %+v", err)
+			}
+
+			include, exclude, err := makeKeyRegexps(config.Include, config.Exclude)
+			if err != nil {
+				log.Fatalf("Invalid key regexps: %+v", err)
+			}
+
+			timeFilter, err := makeS3TimeFilter(
+				config.SinceStr,
+				config.UntilStr,
+				config.KeyLayout,
+			)
+			if err != nil {
+				log.Fatalf("Invalid time filter: %+v", err)
+			}
+
+			s3Client, err := makeS3Client(config.s3ClientConfig)
+			if err != nil {
+				log.Fatalf("Error creating s3 client: %+v", err)
+			}
+
+			checkpoint, err := makeCheckpoint(config.Checkpoint)
+			if err != nil {
+				log.Fatalf("Error loading checkpoint: %+v", err)
+			}
+
+			onError, err := dig.ParseErrorPolicy(config.OnError)
+			if err != nil {
+				log.Fatalf("Invalid error policy: %+v", err)
+			}
+			failures := &dig.FailureReport{}
 
 			digger := &dig.Digger{
 				SourceConsumer: &dig.S3Consumer{
-					S3Client:   s3Client,
-					Bucket:     config.Bucket,
-					NumWorkers: config.NumWorkers,
-					Prefixes:   strings.Split(config.Prefixes, ","),
+					S3Client:          s3Client,
+					Bucket:            config.Bucket,
+					NumWorkers:        config.NumWorkers,
+					RequesterPays:     config.RequesterPays,
+					Prefixes:          strings.Split(config.Prefixes, ","),
+					Include:           include,
+					Exclude:           exclude,
+					Since:             timeFilter.since,
+					Until:             timeFilter.until,
+					KeyLayout:         timeFilter.layout,
+					MinSize:           config.MinSize,
+					MaxSize:           config.MaxSize,
+					InventoryManifest: config.InventoryManifest,
+					Format:            config.Format,
+					MaxMessageSize:    config.MaxMessageSize,
+					TruncateOversized: config.TruncateOversized,
+					ParquetColumns:    parquetColumns(config.commonConfig),
+					CSV:               csvOptions,
+					Checkpoint:        checkpoint,
+					OnError:           onError,
+					Failures:          failures,
 				},
 				Processors: processors,
+				Checkpoint: checkpoint,
+				NumWorkers: config.NumProcessors,
+				Limits:     makeLimits(config.commonConfig),
 			}
 
 			if !config.Raw {
@@ -60,6 +139,7 @@ func S3Cmd(ctx context.Context) cli.Function {
 			if err := digger.Run(ctx); err != nil && ctx.Err() == nil {
 				log.Fatalf("Error running digger: %v", err)
 			}
+			log.Infof("Digger stopped: %s", digger.StopReason())
 
 			for _, processor := range processors {
 				processor.Stop()
@@ -68,6 +148,113 @@ func S3Cmd(ctx context.Context) cli.Function {
 			for _, processor := range processors {
 				log.Infof("Processor summary:\n%s", processor.Summary())
 			}
+
+			reportFailures(failures, config.ErrorReport)
+		},
+	)
+}
+
+// s3ClientConfig stores the options for connecting to S3 that are shared by the s3 subcommands.
+type s3ClientConfig struct {
+	Endpoint       string `flag:"--endpoint"         help:"custom s3 endpoint url, e.g. for minio or localstack" default:"-"`
+	ForcePathStyle bool   `flag:"--force-path-style" help:"use path-style instead of virtual-hosted-style bucket addressing" default:"false"`
+	Profile        string `flag:"--profile"          help:"aws profile to use from the shared config and credentials files" default:"-"`
+	Region         string `flag:"--region"           help:"aws region of the bucket; if unset, the region from the environment or profile is used" default:"-"`
+	RequesterPays  bool   `flag:"--requester-pays"   help:"read from a bucket where the requester pays for requests and data transfer" default:"false"`
+	RoleARN        string `flag:"--role-arn"         help:"arn of an iam role to assume before accessing s3" default:"-"`
+}
+
+// makeS3Client creates an S3 client from the argument config.
+func makeS3Client(config s3ClientConfig) (*s3.S3, error) {
+	sess, err := session.NewSessionWithOptions(
+		session.Options{
+			Profile:           config.Profile,
+			SharedConfigState: session.SharedConfigEnable,
 		},
 	)
+	if err != nil {
+		return nil, err
+	}
+
+	awsConfig := &aws.Config{}
+
+	if config.Endpoint != "" {
+		awsConfig.Endpoint = aws.String(config.Endpoint)
+	}
+	if config.Region != "" {
+		awsConfig.Region = aws.String(config.Region)
+	}
+	if config.ForcePathStyle {
+		awsConfig.S3ForcePathStyle = aws.Bool(true)
+	}
+	if config.RoleARN != "" {
+		log.Debugf("Assuming role %s", config.RoleARN)
+		awsConfig.Credentials = stscreds.NewCredentials(sess, config.RoleARN)
+	}
+
+	return s3.New(sess, awsConfig), nil
+}
+
+// s3TimeFilter stores the parsed time filtering options for the s3 subcommands.
+type s3TimeFilter struct {
+	since  time.Time
+	until  time.Time
+	layout *util.KeyLayout
+}
+
+// makeS3TimeFilter parses the argument since, until, and key layout strings, any of which can be
+// empty.
+func makeS3TimeFilter(sinceStr, untilStr, layoutStr string) (s3TimeFilter, error) {
+	timeFilter := s3TimeFilter{}
+
+	now := time.Now().UTC()
+	var err error
+
+	timeFilter.since, err = util.ParseTimeOrDuration(sinceStr, now)
+	if err != nil {
+		return timeFilter, err
+	}
+	timeFilter.until, err = util.ParseTimeOrDuration(untilStr, now)
+	if err != nil {
+		return timeFilter, err
+	}
+
+	if !timeFilter.since.IsZero() && !timeFilter.until.IsZero() &&
+		timeFilter.since.After(timeFilter.until) {
+		return timeFilter, fmt.Errorf("Until must be after since")
+	}
+
+	if layoutStr != "" {
+		timeFilter.layout, err = util.ParseKeyLayout(layoutStr)
+		if err != nil {
+			return timeFilter, err
+		}
+	}
+
+	return timeFilter, nil
+}
+
+// makeKeyRegexps compiles the argument include and exclude regexps, either of which can be
+// empty.
+func makeKeyRegexps(
+	includeStr string,
+	excludeStr string,
+) (*regexp.Regexp, *regexp.Regexp, error) {
+	var include, exclude *regexp.Regexp
+	var err error
+
+	if includeStr != "" {
+		include, err = regexp.Compile(includeStr)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if excludeStr != "" {
+		exclude, err = regexp.Compile(excludeStr)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return include, exclude, nil
 }
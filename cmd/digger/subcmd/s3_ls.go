@@ -0,0 +1,98 @@
+package subcmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/segmentio/cli"
+	dig "github.com/segmentio/data-digger/pkg/digger"
+	log "github.com/sirupsen/logrus"
+)
+
+type s3LsConfig struct {
+	s3ClientConfig
+
+	Bucket            string  `flag:"-b,--bucket"         help:"s3 bucket"`
+	Debug             bool    `flag:"--debug"             help:"turn on debug logging" default:"false"`
+	Depth             int     `flag:"--depth"             help:"number of path components below each prefix to roll up objects by" default:"1"`
+	Exclude           string  `flag:"--exclude"           help:"regexp for object keys to skip" default:"-"`
+	Include           string  `flag:"--include"           help:"regexp for object keys to read; if unset, all keys are read" default:"-"`
+	InventoryManifest string  `flag:"--inventory-manifest" help:"s3:// url of an s3 inventory manifest.json to get the objects from instead of listing the prefixes" default:"-"`
+	JSON              bool    `flag:"--json"              help:"output results as json" default:"false"`
+	KeyLayout         string  `flag:"--key-layout"        help:"time layout of the keys under each prefix, e.g. dt={YYYY-MM-DD}/hour={HH}" default:"-"`
+	MaxSize           int64   `flag:"--max-size"          help:"only list objects that are at most this many bytes; 0 for no limit" default:"0"`
+	MinSize           int64   `flag:"--min-size"          help:"only list objects that are at least this many bytes" default:"0"`
+	NumProcessors     int     `flag:"--num-processors"    help:"number of processors that the s3 dig will use, for the scan time estimate" default:"1"`
+	NumWorkers        int     `flag:"--num-workers"       help:"number of objects to read in parallel when sampling throughput" default:"4"`
+	Prefixes          string  `flag:"-p,--prefixes"       help:"comma-separated list of prefixes"`
+	ProcessRate       float64 `flag:"--process-rate"      help:"MB/sec of decompressed input that each processor handles, for the scan time estimate" default:"50"`
+	SampleBytes       int64   `flag:"--sample-bytes"      help:"number of bytes to read from the newest objects to measure read throughput and compression; 0 to disable" default:"0"`
+	SinceStr          string  `flag:"--since"             help:"only list objects at or after this time; can be either RFC3339 timestamp or duration relative to now" default:"-"`
+	UntilStr          string  `flag:"--until"             help:"only list objects at or before this time; can be either RFC3339 timestamp or duration relative to now" default:"-"`
+}
+
+// S3LsCmd defines a CLI function for summarizing the objects that the s3 subcommand would read.
+func S3LsCmd(ctx context.Context) cli.Function {
+	return cli.Command(
+		func(config s3LsConfig) {
+			if config.Debug {
+				log.SetLevel(log.DebugLevel)
+			} else {
+				log.SetLevel(log.InfoLevel)
+			}
+
+			include, exclude, err := makeKeyRegexps(config.Include, config.Exclude)
+			if err != nil {
+				log.Fatalf("Invalid key regexps: %+v", err)
+			}
+
+			timeFilter, err := makeS3TimeFilter(
+				config.SinceStr,
+				config.UntilStr,
+				config.KeyLayout,
+			)
+			if err != nil {
+				log.Fatalf("Invalid time filter: %+v", err)
+			}
+
+			s3Client, err := makeS3Client(config.s3ClientConfig)
+			if err != nil {
+				log.Fatalf("Error creating s3 client: %+v", err)
+			}
+
+			consumer := &dig.S3Consumer{
+				S3Client:          s3Client,
+				Bucket:            config.Bucket,
+				NumWorkers:        config.NumWorkers,
+				RequesterPays:     config.RequesterPays,
+				Prefixes:          strings.Split(config.Prefixes, ","),
+				Include:           include,
+				Exclude:           exclude,
+				Since:             timeFilter.since,
+				Until:             timeFilter.until,
+				KeyLayout:         timeFilter.layout,
+				MinSize:           config.MinSize,
+				MaxSize:           config.MaxSize,
+				InventoryManifest: config.InventoryManifest,
+			}
+
+			processors := max(config.NumProcessors, 1)
+			summary, err := consumer.List(
+				ctx,
+				config.Depth,
+				config.SampleBytes,
+				config.ProcessRate*1024*1024*float64(processors),
+			)
+			if err != nil {
+				log.Fatalf("Error listing objects: %+v", err)
+			}
+
+			if config.JSON {
+				printJSON(summary)
+			} else {
+				fmt.Print(summary.PrettyTable())
+			}
+		},
+	)
+}
@@ -8,6 +8,7 @@ require (
 	github.com/gogo/protobuf v1.3.2
 	github.com/gosuri/uilive v0.0.4
 	github.com/olekukonko/tablewriter v0.0.4
+	github.com/parquet-go/parquet-go v0.25.1
 	github.com/segmentio/cli v0.8.1
 	github.com/segmentio/encoding v0.4.1
 	github.com/segmentio/kafka-go v0.4.48
@@ -18,8 +19,10 @@ require (
 )
 
 require (
+	github.com/andybalholm/brotli v1.1.0 // indirect
 	github.com/davecgh/go-spew v1.1.2-0.20180830191138-d8f796af33cc // indirect
 	github.com/fatih/color v1.18.0 // indirect
+	github.com/google/uuid v1.6.0 // indirect
 	github.com/jmespath/go-jmespath v0.4.0 // indirect
 	github.com/klauspost/compress v1.18.0 // indirect
 	github.com/mattn/go-colorable v0.1.14 // indirect
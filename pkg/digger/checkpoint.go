@@ -0,0 +1,349 @@
+package digger
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/segmentio/data-digger/pkg/message"
+	sjson "github.com/segmentio/encoding/json"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// How often the digger saves its checkpoint while running
+	checkpointInterval = 10 * time.Second
+
+	checkpointVersion = 1
+)
+
+// CheckpointProcessor is a Processor whose state can be saved in a checkpoint and restored
+// from it when a dig is resumed.
+type CheckpointProcessor interface {
+	Processor
+
+	// CheckpointState returns a JSON-serializable snapshot of the processor's state.
+	CheckpointState() (interface{}, error)
+
+	// RestoreCheckpoint replaces the processor's state with one from a checkpoint.
+	RestoreCheckpoint(state sjson.RawMessage) error
+}
+
+// CheckpointConsumer is a Consumer whose inputs can be recorded in a checkpoint so that a dig
+// isn't resumed against different ones.
+type CheckpointConsumer interface {
+	Consumer
+
+	// CheckpointSources returns a JSON-serializable description of the consumer's inputs, e.g.
+	// the bucket and prefixes or the file paths.
+	CheckpointSources() interface{}
+}
+
+// Checkpoint records the progress of a file or S3 dig so that it can be resumed after an
+// interruption. It tracks which files or objects have been completely processed, how many records
+// have been processed in the ones that are still in progress, and the states of the processors.
+//
+// Progress is only recorded for messages that have been passed through all of the processors, so
+// the saved processor states are always consistent with the saved progress. When messages are
+// processed in parallel, the progress in each source is a low-water mark: it only advances past a
+// record once all of the ones before it have been processed too.
+type Checkpoint struct {
+	sync.Mutex
+
+	path            string
+	completed       map[string]bool
+	processed       map[string]int64
+	totals          map[string]int64
+	sources         sjson.RawMessage
+	processorStates []sjson.RawMessage
+
+	// dispatched is the number of records in each source that have been handed to the parallel
+	// processing workers, and finished has the indices of the ones that were processed before
+	// some of the records ahead of them
+	dispatched map[string]int64
+	finished   map[string]map[int64]bool
+}
+
+// checkpointFile is the format of the checkpoint file on disk.
+type checkpointFile struct {
+	Version    int                `json:"version"`
+	Completed  []string           `json:"completed"`
+	InProgress map[string]int64   `json:"inProgress"`
+	Sources    sjson.RawMessage   `json:"sources,omitempty"`
+	Processors []sjson.RawMessage `json:"processors"`
+}
+
+// LoadCheckpoint loads the checkpoint at the argument path. If the file doesn't exist, then an
+// empty checkpoint is returned that will be saved to this path.
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+	checkpoint := &Checkpoint{
+		path:       path,
+		completed:  map[string]bool{},
+		processed:  map[string]int64{},
+		totals:     map[string]int64{},
+		dispatched: map[string]int64{},
+		finished:   map[string]map[int64]bool{},
+	}
+
+	contents, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return checkpoint, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	file := checkpointFile{}
+	if err := sjson.Unmarshal(contents, &file); err != nil {
+		return nil, fmt.Errorf("Error parsing checkpoint %s: %+v", path, err)
+	}
+	if file.Version != checkpointVersion {
+		return nil, fmt.Errorf("Unsupported checkpoint version: %d", file.Version)
+	}
+
+	for _, source := range file.Completed {
+		checkpoint.completed[source] = true
+	}
+	for source, records := range file.InProgress {
+		checkpoint.processed[source] = records
+	}
+	checkpoint.sources = file.Sources
+	checkpoint.processorStates = file.Processors
+
+	log.Infof(
+		"Resuming from checkpoint %s (%d completed, %d in progress)",
+		path,
+		len(file.Completed),
+		len(file.InProgress),
+	)
+
+	return checkpoint, nil
+}
+
+// RestoreSources checks that the inputs of the argument consumer match the ones that the
+// checkpoint was created with, and records them so that they're saved with it. Consumers that
+// don't implement CheckpointConsumer aren't checked.
+func (c *Checkpoint) RestoreSources(consumer Consumer) error {
+	checkpointConsumer, ok := consumer.(CheckpointConsumer)
+	if !ok {
+		return nil
+	}
+
+	sources, err := sjson.Marshal(checkpointConsumer.CheckpointSources())
+	if err != nil {
+		return err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if c.sources != nil && string(c.sources) != string(sources) {
+		return fmt.Errorf(
+			"Checkpoint was created with different sources (%s), got %s",
+			c.sources,
+			sources,
+		)
+	}
+	c.sources = sources
+
+	return nil
+}
+
+// Restore restores the states of the argument processors from this checkpoint. It's a no-op if
+// the checkpoint is new.
+func (c *Checkpoint) Restore(processors []Processor) error {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.processorStates == nil {
+		return nil
+	}
+	if len(c.processorStates) != len(processors) {
+		return fmt.Errorf(
+			"Checkpoint has %d processor states, expected %d",
+			len(c.processorStates),
+			len(processors),
+		)
+	}
+
+	for p, processor := range processors {
+		checkpointProcessor, ok := processor.(CheckpointProcessor)
+		if !ok || string(c.processorStates[p]) == "null" {
+			continue
+		}
+		if err := checkpointProcessor.RestoreCheckpoint(c.processorStates[p]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Save writes the current progress and the states of the argument processors to the checkpoint
+// file. The file is replaced atomically so that an interruption during the save doesn't corrupt
+// it.
+func (c *Checkpoint) Save(processors []Processor) error {
+	c.Lock()
+	defer c.Unlock()
+
+	for source, total := range c.totals {
+		if c.processed[source] >= total {
+			c.completed[source] = true
+			delete(c.processed, source)
+			delete(c.totals, source)
+			delete(c.dispatched, source)
+		}
+	}
+
+	file := checkpointFile{
+		Version:    checkpointVersion,
+		Completed:  []string{},
+		InProgress: c.processed,
+		Sources:    c.sources,
+		Processors: []sjson.RawMessage{},
+	}
+	for source := range c.completed {
+		file.Completed = append(file.Completed, source)
+	}
+	sort.Strings(file.Completed)
+
+	for _, processor := range processors {
+		var state interface{}
+
+		if checkpointProcessor, ok := processor.(CheckpointProcessor); ok {
+			var err error
+			state, err = checkpointProcessor.CheckpointState()
+			if err != nil {
+				return err
+			}
+		}
+
+		stateBytes, err := sjson.Marshal(state)
+		if err != nil {
+			return err
+		}
+		file.Processors = append(file.Processors, stateBytes)
+	}
+
+	contents, err := sjson.Marshal(file)
+	if err != nil {
+		return err
+	}
+
+	tempFile, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile.Name())
+
+	if _, err := tempFile.Write(contents); err != nil {
+		tempFile.Close()
+		return err
+	}
+	if err := tempFile.Close(); err != nil {
+		return err
+	}
+
+	log.Debugf(
+		"Saved checkpoint (%d completed, %d in progress)",
+		len(file.Completed),
+		len(file.InProgress),
+	)
+	return os.Rename(tempFile.Name(), c.path)
+}
+
+// progress returns whether the argument source has already been completely processed and, if
+// not, the number of records at the start of it that have been. It's safe to call on a nil
+// checkpoint.
+func (c *Checkpoint) progress(source string) (bool, int64) {
+	if c == nil {
+		return false, 0
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	return c.completed[source], c.processed[source]
+}
+
+// update records that the argument message has been processed. It's safe to call on a nil
+// checkpoint.
+func (c *Checkpoint) update(msg message.Message) {
+	if c == nil {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.processed[msg.Source]++
+}
+
+// dispatch returns the index of the argument message in its source, counting from the start of
+// the source. Messages from each source must be dispatched in the order that they were read. It's
+// safe to call on a nil checkpoint.
+func (c *Checkpoint) dispatch(msg message.Message) int64 {
+	if c == nil {
+		return 0
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	index, ok := c.dispatched[msg.Source]
+	if !ok {
+		// Start after the records that were processed before the dig was resumed
+		index = c.processed[msg.Source]
+	}
+	c.dispatched[msg.Source] = index + 1
+	return index
+}
+
+// updateAt records that the argument message, which was dispatched with the argument index,
+// has been processed. The progress for the source only advances when there are no unprocessed
+// records before it. It's safe to call on a nil checkpoint.
+func (c *Checkpoint) updateAt(msg message.Message, index int64) {
+	if c == nil {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	finished := c.finished[msg.Source]
+
+	if index != c.processed[msg.Source] {
+		if finished == nil {
+			finished = map[int64]bool{}
+			c.finished[msg.Source] = finished
+		}
+		finished[index] = true
+		return
+	}
+
+	c.processed[msg.Source]++
+	for finished[c.processed[msg.Source]] {
+		delete(finished, c.processed[msg.Source])
+		c.processed[msg.Source]++
+	}
+	if finished != nil && len(finished) == 0 {
+		delete(c.finished, msg.Source)
+	}
+}
+
+// finish records that the consumer has read all of the records in the argument source. The
+// source is considered complete once all of them have been processed. It's safe to call on a nil
+// checkpoint.
+func (c *Checkpoint) finish(source string, total int64) {
+	if c == nil {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.totals[source] = total
+}
@@ -0,0 +1,231 @@
+package digger
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/segmentio/data-digger/pkg/message"
+	"github.com/segmentio/data-digger/pkg/stats"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckpointResume(t *testing.T) {
+	ctx := context.Background()
+	tempDir := t.TempDir()
+
+	inputDir := filepath.Join(tempDir, "inputs")
+	path1 := filepath.Join(inputDir, "file1.txt")
+	path2 := filepath.Join(inputDir, "file2.txt")
+	checkpointPath := filepath.Join(tempDir, "checkpoint.json")
+
+	require.NoError(t, os.Mkdir(inputDir, 0755))
+	require.NoError(
+		t,
+		os.WriteFile(path1, []byte(`{"id":"a"}`+"\n"+`{"id":"b"}`+"\n"+`{"id":"a"}`), 0644),
+	)
+
+	runDigger := func(paths []string) *LiveStats {
+		checkpoint, err := LoadCheckpoint(checkpointPath)
+		require.NoError(t, err)
+
+		liveStats, err := NewLiveStats(LiveStatsConfig{K: 10, PathsStr: "id"})
+		require.NoError(t, err)
+		defer liveStats.Stop()
+
+		digger := &Digger{
+			SourceConsumer: &FileConsumer{
+				Paths:      paths,
+				Checkpoint: checkpoint,
+			},
+			Processors: []Processor{liveStats},
+			Checkpoint: checkpoint,
+		}
+		require.NoError(t, digger.Run(ctx))
+
+		return liveStats
+	}
+
+	runDigger([]string{inputDir})
+
+	checkpoint, err := LoadCheckpoint(checkpointPath)
+	require.NoError(t, err)
+	completed, _ := checkpoint.progress(message.FileSource(path1))
+	assert.True(t, completed)
+
+	require.NoError(
+		t,
+		os.WriteFile(path2, []byte(`{"id":"a"}`+"\n"+`{"id":"c"}`), 0644),
+	)
+
+	// The first file should be skipped, but its stats should be restored
+	liveStats := runDigger([]string{inputDir})
+	assert.Equal(t, int64(5), liveStats.messageCounter().Summary().TotalMessages)
+	assert.Equal(
+		t,
+		[]stats.Bucket{
+			{Key: "a", Count: 3, Min: 1, Max: 1, Sum: 3},
+			{Key: "b", Count: 1, Min: 1, Max: 1, Sum: 1},
+			{Key: "c", Count: 1, Min: 1, Max: 1, Sum: 1},
+		},
+		bucketsWithoutIndex(liveStats.topKCounter().Buckets(10, false)),
+	)
+
+	// Options that change the stats can't be resumed
+	checkpoint, err = LoadCheckpoint(checkpointPath)
+	require.NoError(t, err)
+	otherStats, err := NewLiveStats(LiveStatsConfig{K: 10, PathsStr: "other"})
+	require.NoError(t, err)
+	defer otherStats.Stop()
+	assert.Error(t, checkpoint.Restore([]Processor{otherStats}))
+
+	// Neither can different sources
+	checkpoint, err = LoadCheckpoint(checkpointPath)
+	require.NoError(t, err)
+	assert.NoError(t, checkpoint.RestoreSources(&FileConsumer{Paths: []string{inputDir}}))
+	err = checkpoint.RestoreSources(&FileConsumer{Paths: []string{path1, path2}})
+	assert.ErrorContains(t, err, "Checkpoint was created with different sources")
+	err = checkpoint.RestoreSources(
+		&FileConsumer{Paths: []string{inputDir}, Recursive: true},
+	)
+	assert.Error(t, err)
+
+	liveStats, err = NewLiveStats(LiveStatsConfig{K: 10, PathsStr: "id"})
+	require.NoError(t, err)
+	defer liveStats.Stop()
+	digger := &Digger{
+		SourceConsumer: &FileConsumer{Paths: []string{path2}, Checkpoint: checkpoint},
+		Processors:     []Processor{liveStats},
+		Checkpoint:     checkpoint,
+	}
+	assert.Error(t, digger.Run(ctx))
+}
+
+func TestS3CheckpointSources(t *testing.T) {
+	consumer := &S3Consumer{
+		Bucket:   "bucket",
+		Prefixes: []string{"logs/"},
+		Include:  regexp.MustCompile(`\.gz$`),
+		Since:    time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	checkpoint, err := LoadCheckpoint(filepath.Join(t.TempDir(), "checkpoint.json"))
+	require.NoError(t, err)
+	require.NoError(t, checkpoint.RestoreSources(consumer))
+	require.NoError(t, checkpoint.Save(nil))
+
+	checkpoint, err = LoadCheckpoint(checkpoint.path)
+	require.NoError(t, err)
+
+	other := *consumer
+	other.Bucket = "other-bucket"
+	assert.Error(t, checkpoint.RestoreSources(&other))
+
+	other = *consumer
+	other.Prefixes = []string{"logs/", "more-logs/"}
+	assert.Error(t, checkpoint.RestoreSources(&other))
+
+	other = *consumer
+	other.Include = nil
+	assert.Error(t, checkpoint.RestoreSources(&other))
+
+	other = *consumer
+	other.Since = consumer.Since.Add(time.Hour)
+	assert.Error(t, checkpoint.RestoreSources(&other))
+
+	// Options that don't change the inputs can differ
+	other = *consumer
+	other.NumWorkers = 8
+	assert.NoError(t, checkpoint.RestoreSources(&other))
+}
+
+func TestCheckpointInProgress(t *testing.T) {
+	ctx := context.Background()
+	tempDir := t.TempDir()
+
+	path := filepath.Join(tempDir, "file.txt")
+	checkpointPath := filepath.Join(tempDir, "checkpoint.json")
+
+	require.NoError(t, os.WriteFile(path, []byte("line0\nline1\nline2\nline3"), 0644))
+	require.NoError(
+		t,
+		os.WriteFile(
+			checkpointPath,
+			[]byte(
+				`{"version":1,"completed":[],"inProgress":{"`+
+					message.FileSource(path)+`":2},"processors":[]}`,
+			),
+			0644,
+		),
+	)
+
+	checkpoint, err := LoadCheckpoint(checkpointPath)
+	require.NoError(t, err)
+
+	messageChan := make(chan message.Message, 10)
+	consumer := FileConsumer{
+		Paths:      []string{path},
+		Checkpoint: checkpoint,
+	}
+	require.NoError(t, consumer.Run(ctx, messageChan))
+
+	require.Equal(t, 2, len(messageChan))
+	msg := <-messageChan
+	assert.Equal(t, int64(2), msg.Offset)
+	assert.Equal(t, []byte("line2"), msg.Value)
+
+	// The file is only complete once all of its records have been processed
+	checkpoint.update(msg)
+	require.NoError(t, checkpoint.Save(nil))
+	completed, processed := checkpoint.progress(message.FileSource(path))
+	assert.False(t, completed)
+	assert.Equal(t, int64(3), processed)
+
+	checkpoint.update(<-messageChan)
+	require.NoError(t, checkpoint.Save(nil))
+	completed, _ = checkpoint.progress(message.FileSource(path))
+	assert.True(t, completed)
+}
+
+func TestCheckpointOutOfOrder(t *testing.T) {
+	checkpoint, err := LoadCheckpoint(filepath.Join(t.TempDir(), "checkpoint.json"))
+	require.NoError(t, err)
+	checkpoint.processed["file://a.txt"] = 10
+
+	msgA := message.Message{Source: "file://a.txt"}
+	msgB := message.Message{Source: "file://b.txt"}
+
+	// Dispatched messages are numbered after the ones that were already processed
+	assert.Equal(t, int64(10), checkpoint.dispatch(msgA))
+	assert.Equal(t, int64(11), checkpoint.dispatch(msgA))
+	assert.Equal(t, int64(12), checkpoint.dispatch(msgA))
+	assert.Equal(t, int64(0), checkpoint.dispatch(msgB))
+
+	// The progress doesn't move past records that haven't been processed
+	checkpoint.updateAt(msgA, 12)
+	checkpoint.updateAt(msgB, 0)
+	_, processed := checkpoint.progress("file://a.txt")
+	assert.Equal(t, int64(10), processed)
+	_, processed = checkpoint.progress("file://b.txt")
+	assert.Equal(t, int64(1), processed)
+
+	checkpoint.updateAt(msgA, 10)
+	_, processed = checkpoint.progress("file://a.txt")
+	assert.Equal(t, int64(11), processed)
+
+	checkpoint.updateAt(msgA, 11)
+	_, processed = checkpoint.progress("file://a.txt")
+	assert.Equal(t, int64(13), processed)
+	assert.Empty(t, checkpoint.finished)
+}
+
+func bucketsWithoutIndex(buckets []stats.Bucket) []stats.Bucket {
+	for i := range buckets {
+		buckets[i].Index = 0
+	}
+	return buckets
+}
@@ -0,0 +1,140 @@
+package digger
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+
+	sjson "github.com/segmentio/encoding/json"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// FormatCSV is an input format for comma-separated values. Each record is converted to a
+	// JSON object with keys taken from the header row (or CSVOptions.Columns).
+	FormatCSV = "csv"
+
+	// FormatTSV is like FormatCSV, but for tab-separated values.
+	FormatTSV = "tsv"
+)
+
+var jsonNumberRegexp = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+
+// CSVOptions stores the options used for reading CSV and TSV inputs.
+type CSVOptions struct {
+	// Columns are the names of the fields in each record. If empty, the names are taken from
+	// the first record in each file or object.
+	Columns []string
+
+	// Delimiter is the field delimiter; if zero, a comma is used for CSV and a tab for TSV.
+	Delimiter rune
+
+	// InferTypes converts numeric and boolean fields to the corresponding JSON types instead of
+	// treating everything as a string.
+	InferTypes bool
+}
+
+// csvReader is a recordReader implementation that converts CSV records to JSON objects.
+type csvReader struct {
+	reader   *csv.Reader
+	columns  []string
+	options  CSVOptions
+	maxSize  int
+	contents []byte
+	start    int64
+}
+
+func newCSVReader(
+	input io.Reader,
+	format string,
+	options CSVOptions,
+	maxSize int,
+) *csvReader {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxMessageSize
+	}
+
+	reader := csv.NewReader(input)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+
+	if format == FormatTSV {
+		reader.Comma = '\t'
+		// TSV files typically don't quote fields, so don't be strict about quotes
+		reader.LazyQuotes = true
+	}
+	if options.Delimiter != 0 {
+		reader.Comma = options.Delimiter
+	}
+
+	return &csvReader{
+		reader:  reader,
+		columns: options.Columns,
+		options: options,
+		maxSize: maxSize,
+	}
+}
+
+func (c *csvReader) next() ([]byte, bool, error) {
+	if len(c.columns) == 0 {
+		header, err := c.reader.Read()
+		if err != nil {
+			return nil, false, err
+		}
+
+		c.columns = make([]string, len(header))
+		copy(c.columns, header)
+	}
+
+	c.start = c.reader.InputOffset()
+
+	record, err := c.reader.Read()
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			// Return an empty message so that it's counted as invalid
+			log.Debugf("Error parsing CSV record: %+v", err)
+			return []byte{}, false, nil
+		}
+
+		return nil, false, err
+	}
+
+	c.contents = append(c.contents[:0], '{')
+
+	for i, field := range record {
+		if i > 0 {
+			c.contents = append(c.contents, ',')
+		}
+
+		var column string
+		if i < len(c.columns) {
+			column = c.columns[i]
+		} else {
+			column = fmt.Sprintf("_%d", i)
+		}
+
+		c.contents = sjson.AppendEscape(c.contents, column, 0)
+		c.contents = append(c.contents, ':')
+
+		if c.options.InferTypes &&
+			(jsonNumberRegexp.MatchString(field) || field == "true" || field == "false") {
+			c.contents = append(c.contents, field...)
+		} else {
+			c.contents = sjson.AppendEscape(c.contents, field, 0)
+		}
+	}
+
+	c.contents = append(c.contents, '}')
+
+	if len(c.contents) > c.maxSize {
+		return c.contents[0:c.maxSize], true, nil
+	}
+	return c.contents, false, nil
+}
+
+func (c *csvReader) lastPosition() int64 {
+	return c.start
+}
@@ -0,0 +1,82 @@
+package digger
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCSVReader(t *testing.T) {
+	type testCase struct {
+		description    string
+		format         string
+		options        CSVOptions
+		input          string
+		expectedValues []string
+	}
+
+	testCases := []testCase{
+		{
+			description: "header row",
+			format:      FormatCSV,
+			input:       "name,count,\"quoted, name\"\nvalue1,10,\"multi\nline\"\nvalue2,20,x,extra\n",
+			expectedValues: []string{
+				`{"name":"value1","count":"10","quoted, name":"multi\nline"}`,
+				`{"name":"value2","count":"20","quoted, name":"x","_3":"extra"}`,
+			},
+		},
+		{
+			description: "explicit columns with types",
+			format:      FormatCSV,
+			options: CSVOptions{
+				Columns:    []string{"name", "count", "enabled"},
+				InferTypes: true,
+			},
+			input: "value1,10,true\nvalue2,-2.5e3,no\nvalue3,0123,false\n",
+			expectedValues: []string{
+				`{"name":"value1","count":10,"enabled":true}`,
+				`{"name":"value2","count":-2.5e3,"enabled":"no"}`,
+				`{"name":"value3","count":"0123","enabled":false}`,
+			},
+		},
+		{
+			description: "tsv",
+			format:      FormatTSV,
+			input:       "name\tvalue\nvalue1\tsome \"quoted\" text\n",
+			expectedValues: []string{
+				`{"name":"value1","value":"some \"quoted\" text"}`,
+			},
+		},
+		{
+			description: "custom delimiter with bad record",
+			format:      FormatCSV,
+			options: CSVOptions{
+				Delimiter: '|',
+			},
+			input: "name|value\nvalue1|a\"b\nvalue2|c\n",
+			expectedValues: []string{
+				``,
+				`{"name":"value2","value":"c"}`,
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		records, err := newRecordReader(
+			testCase.format,
+			strings.NewReader(testCase.input),
+			0,
+			testCase.options,
+		)
+		require.NoError(t, err)
+
+		assert.Equal(
+			t,
+			testCase.expectedValues,
+			readAllRecords(t, records),
+			testCase.description,
+		)
+	}
+}
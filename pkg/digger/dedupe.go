@@ -0,0 +1,571 @@
+package digger
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/segmentio/data-digger/pkg/json"
+	"github.com/segmentio/data-digger/pkg/message"
+	"github.com/segmentio/data-digger/pkg/proto"
+	"github.com/segmentio/data-digger/pkg/stats"
+	sjson "github.com/segmentio/encoding/json"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Approximate memory used by each key in the exact set, in addition to the key itself
+	dedupeEntryOverhead = 96
+
+	// Target false positive rate once the keys are tracked in a Bloom filter
+	dedupeFPRate = 0.001
+
+	// Number of duplicates whose locations are listed in the summary
+	maxDuplicateLocations = 20
+
+	// Seed for the Bloom filter hashes; it's fixed so that the false positives are the same
+	// when a dig is repeated
+	dedupeBloomSeed = 0
+
+	// Maximum number of keys that are saved exactly in checkpoints; larger sets are saved as Bloom
+	// filters so that the checkpoints stay small
+	dedupeMaxCheckpointKeys = 100000
+)
+
+// DedupeConfig stores the inputs for a Deduper processor.
+type DedupeConfig struct {
+	Drop       bool
+	K          int
+	KeyPath    string
+	MaxMemory  int
+	ProtoTypes []string
+
+	// NumWorkers is the number of workers that call ProcessWorker; each one gets its own
+	// decoder. It doesn't affect the results, so it's left out of checkpoints.
+	NumWorkers int `json:"-"`
+}
+
+// Deduper is a processor that finds messages with duplicate keys, e.g. events that were
+// delivered more than once. Keys are tracked exactly until they use MaxMemory bytes; after that,
+// they're moved to a scalable Bloom filter, so some unique messages may be reported as
+// duplicates, and the first locations of the duplicated keys are no longer known.
+//
+// If Drop is set, then Process returns ErrSkipMessage for duplicates so that they're not passed
+// to the processors after this one.
+//
+// With multiple processing workers, the keys are extracted concurrently and only the updates to
+// the seen keys and the counts are serialized. Which copy of a key counts as the first one then
+// depends on the order that the workers get to them, so it's not deterministic.
+type Deduper struct {
+	config    DedupeConfig
+	decoders  []*proto.Decoder
+	extractor *json.PathExtractor
+	usesMeta  bool
+
+	// The mutex protects the rest of the fields
+	sync.Mutex
+
+	// seen maps the keys to where they were first seen until the memory limit is reached;
+	// after that, it's nil and the keys are in bloomFilter
+	seen        map[string]dedupeLocation
+	seenBytes   int
+	bloomFilter *stats.ScalableBloomFilter
+	exactKeys   int
+
+	topKCounter         *stats.TopKCounter
+	numKeyed            int64
+	numMissing          int64
+	numDuplicates       int64
+	partitionDuplicates map[dedupePartition]int64
+	duplicates          []dedupeDuplicate
+}
+
+// dedupePartition identifies a Kafka partition or a file or object.
+type dedupePartition struct {
+	source    string
+	partition int
+}
+
+// dedupeLocation is the position of a message in its source.
+type dedupeLocation struct {
+	Source    string `json:"source"`
+	Partition int    `json:"partition"`
+	Offset    int64  `json:"offset"`
+}
+
+// dedupeDuplicate is a message with a duplicate key. The first location is unknown if the key was
+// found in the Bloom filter.
+type dedupeDuplicate struct {
+	Key      string          `json:"key"`
+	Location dedupeLocation  `json:"location"`
+	First    *dedupeLocation `json:"first,omitempty"`
+}
+
+// dedupeState is the state of a Deduper in a checkpoint. Either Seen or BloomFilter is set,
+// depending on whether the keys still fit in the exact set and there are few enough of them to
+// save exactly.
+type dedupeState struct {
+	Config              DedupeConfig                    `json:"config"`
+	Seen                *dedupeSeenState                `json:"seen,omitempty"`
+	BloomFilter         *stats.ScalableBloomFilterState `json:"bloomFilter,omitempty"`
+	ExactKeys           int                             `json:"exactKeys"`
+	TopK                stats.TopKCounterState          `json:"topK"`
+	NumKeyed            int64                           `json:"numKeyed"`
+	NumMissing          int64                           `json:"numMissing"`
+	NumDuplicates       int64                           `json:"numDuplicates"`
+	PartitionDuplicates []dedupePartitionCount          `json:"partitionDuplicates"`
+	Duplicates          []dedupeDuplicate               `json:"duplicates"`
+}
+
+// dedupeSeenState is the exact set of keys in a checkpoint. The first locations of the keys are
+// stored in slices that parallel the keys, with each source stored only once since there are
+// usually far fewer sources than keys.
+type dedupeSeenState struct {
+	Sources       []string `json:"sources"`
+	Keys          []string `json:"keys"`
+	SourceIndices []int    `json:"sourceIndices"`
+	Partitions    []int    `json:"partitions"`
+	Offsets       []int64  `json:"offsets"`
+}
+
+// dedupePartitionCount is the number of duplicates in a partition, file, or object in a
+// checkpoint.
+type dedupePartitionCount struct {
+	Source     string `json:"source"`
+	Partition  int    `json:"partition"`
+	Duplicates int64  `json:"duplicates"`
+}
+
+var (
+	_ CheckpointProcessor = (*Deduper)(nil)
+	_ ParallelProcessor   = (*Deduper)(nil)
+)
+
+// NewDeduper creates a new Deduper instance.
+func NewDeduper(config DedupeConfig) (*Deduper, error) {
+	if config.KeyPath == "" {
+		return nil, errors.New("Dedupe key path must be set")
+	}
+
+	// Decoders reuse their proto instances, so each worker needs its own
+	decoders := []*proto.Decoder{}
+	for i := 0; i < max(config.NumWorkers, 1); i++ {
+		decoder, err := proto.NewDecoder(config.ProtoTypes)
+		if err != nil {
+			return nil, err
+		}
+		decoders = append(decoders, decoder)
+	}
+
+	return &Deduper{
+		config:              config,
+		decoders:            decoders,
+		extractor:           json.NewPathExtractor([][]string{{config.KeyPath}}),
+		usesMeta:            json.IsMetaPath(config.KeyPath),
+		seen:                map[string]dedupeLocation{},
+		topKCounter:         stats.NewTopKCounter(config.K),
+		partitionDuplicates: map[dedupePartition]int64{},
+	}, nil
+}
+
+// Process checks whether the key of a single message has been seen before.
+func (d *Deduper) Process(ctx context.Context, msg message.Message) error {
+	return d.process(d.decoders[0], msg)
+}
+
+// ProcessWorker checks whether the key of a single message has been seen before, using the
+// argument worker's decoder.
+func (d *Deduper) ProcessWorker(
+	ctx context.Context,
+	worker int,
+	msg message.Message,
+	output io.Writer,
+) error {
+	return d.process(d.decoders[worker%len(d.decoders)], msg)
+}
+
+func (d *Deduper) process(decoder *proto.Decoder, msg message.Message) error {
+	// The key is extracted before locking so that the workers only contend for the seen keys
+	key, ok := d.key(decoder, msg)
+
+	d.Lock()
+	defer d.Unlock()
+
+	if !ok {
+		d.numMissing++
+		return nil
+	}
+	d.numKeyed++
+
+	location := dedupeLocation{
+		Source:    msg.Source,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+	}
+
+	duplicate, first := d.add(key, location)
+	if !duplicate {
+		return nil
+	}
+
+	d.numDuplicates++
+	d.topKCounter.Add(key, 1.0)
+	d.partitionDuplicates[dedupePartition{source: msg.Source, partition: msg.Partition}]++
+
+	if len(d.duplicates) < maxDuplicateLocations {
+		d.duplicates = append(
+			d.duplicates,
+			dedupeDuplicate{Key: key, Location: location, First: first},
+		)
+	}
+
+	if d.config.Drop {
+		return ErrSkipMessage
+	}
+	return nil
+}
+
+// key returns the dedupe key for the argument message, if it has one. Messages that aren't valid
+// JSON don't have keys. If the key is an array, then its elements are joined with commas.
+func (d *Deduper) key(decoder *proto.Decoder, msg message.Message) (string, bool) {
+	if msg.Value == nil {
+		return "", false
+	}
+
+	decodedMsg, validated, err := decoder.ToJSONUnvalidated(msg.Value)
+	if err != nil {
+		return "", false
+	}
+
+	var meta []byte
+	if d.usesMeta {
+		meta, err = metaJSON(msg)
+		if err != nil {
+			return "", false
+		}
+	}
+
+	// The extractor's values are copies, so they can be kept after the message buffer is reused
+	values, found := d.extractor.Values(decodedMsg, meta)
+	if len(values) == 0 {
+		return "", false
+	}
+
+	// Keys that the extractor found in the message are only reported as found if it's valid, but
+	// keys in the metadata don't check it
+	if !found && !validated && !sjson.Valid(decodedMsg) {
+		return "", false
+	}
+
+	return strings.Join(values, ","), true
+}
+
+// add records a key and returns whether it was already seen. If it was and its first location
+// is known, then that's returned too.
+func (d *Deduper) add(key string, location dedupeLocation) (bool, *dedupeLocation) {
+	if d.seen == nil {
+		return d.bloomFilter.Add(key), nil
+	}
+
+	if first, ok := d.seen[key]; ok {
+		return true, &first
+	}
+
+	d.seen[key] = location
+	d.seenBytes += len(key) + dedupeEntryOverhead
+
+	if d.seenBytes > d.config.MaxMemory {
+		log.Infof(
+			"Dedupe keys reached the memory limit after %d keys; switching to a bloom filter",
+			len(d.seen),
+		)
+
+		d.exactKeys = len(d.seen)
+		d.bloomFilter = d.seenBloomFilter()
+		d.seen = nil
+	}
+
+	return false, nil
+}
+
+// seenBloomFilter returns a Bloom filter with the keys in the exact set.
+func (d *Deduper) seenBloomFilter() *stats.ScalableBloomFilter {
+	bloomFilter := stats.NewScalableBloomFilter(2*len(d.seen), dedupeFPRate, dedupeBloomSeed)
+	for key := range d.seen {
+		bloomFilter.Add(key)
+	}
+	return bloomFilter
+}
+
+// CheckpointState returns a snapshot of the keys and duplicates that have been seen so far. If
+// there are more than dedupeMaxCheckpointKeys keys in the exact set, then they're saved as a Bloom
+// filter, so a dig that's resumed from the checkpoint continues with approximate detection.
+func (d *Deduper) CheckpointState() (interface{}, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	state := dedupeState{
+		Config:              d.config,
+		ExactKeys:           d.exactKeys,
+		TopK:                d.topKCounter.State(),
+		NumKeyed:            d.numKeyed,
+		NumMissing:          d.numMissing,
+		NumDuplicates:       d.numDuplicates,
+		PartitionDuplicates: []dedupePartitionCount{},
+		Duplicates:          append([]dedupeDuplicate{}, d.duplicates...),
+	}
+
+	switch {
+	case d.seen != nil && len(d.seen) > dedupeMaxCheckpointKeys:
+		bloomState := d.seenBloomFilter().State()
+		state.BloomFilter = &bloomState
+		state.ExactKeys = len(d.seen)
+	case d.seen != nil:
+		seen := &dedupeSeenState{}
+		sourceIndices := map[string]int{}
+
+		for key, location := range d.seen {
+			sourceIndex, ok := sourceIndices[location.Source]
+			if !ok {
+				sourceIndex = len(seen.Sources)
+				sourceIndices[location.Source] = sourceIndex
+				seen.Sources = append(seen.Sources, location.Source)
+			}
+
+			seen.Keys = append(seen.Keys, key)
+			seen.SourceIndices = append(seen.SourceIndices, sourceIndex)
+			seen.Partitions = append(seen.Partitions, location.Partition)
+			seen.Offsets = append(seen.Offsets, location.Offset)
+		}
+		state.Seen = seen
+	default:
+		bloomState := d.bloomFilter.State()
+		state.BloomFilter = &bloomState
+	}
+
+	for partition, count := range d.partitionDuplicates {
+		state.PartitionDuplicates = append(
+			state.PartitionDuplicates,
+			dedupePartitionCount{
+				Source:     partition.source,
+				Partition:  partition.partition,
+				Duplicates: count,
+			},
+		)
+	}
+
+	return state, nil
+}
+
+// RestoreCheckpoint restores the state of this Deduper from a checkpoint. It returns an error if
+// the checkpoint was created with a different config.
+func (d *Deduper) RestoreCheckpoint(stateBytes sjson.RawMessage) error {
+	state := dedupeState{}
+	if err := sjson.Unmarshal(stateBytes, &state); err != nil {
+		return err
+	}
+
+	configBytes, err := sjson.Marshal(d.config)
+	if err != nil {
+		return err
+	}
+	stateConfigBytes, err := sjson.Marshal(state.Config)
+	if err != nil {
+		return err
+	}
+	if string(configBytes) != string(stateConfigBytes) {
+		return errors.New("Checkpoint was created with different dedupe options")
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	d.seen = nil
+	d.seenBytes = 0
+	d.bloomFilter = nil
+
+	switch {
+	case state.Seen != nil:
+		seen := state.Seen
+		if len(seen.SourceIndices) != len(seen.Keys) ||
+			len(seen.Partitions) != len(seen.Keys) ||
+			len(seen.Offsets) != len(seen.Keys) {
+			return errors.New("Checkpoint has an invalid set of dedupe keys")
+		}
+
+		d.seen = make(map[string]dedupeLocation, len(seen.Keys))
+		for k, key := range seen.Keys {
+			sourceIndex := seen.SourceIndices[k]
+			if sourceIndex < 0 || sourceIndex >= len(seen.Sources) {
+				return errors.New("Checkpoint has an invalid set of dedupe keys")
+			}
+
+			d.seen[key] = dedupeLocation{
+				Source:    seen.Sources[sourceIndex],
+				Partition: seen.Partitions[k],
+				Offset:    seen.Offsets[k],
+			}
+			d.seenBytes += len(key) + dedupeEntryOverhead
+		}
+	case state.BloomFilter != nil:
+		d.bloomFilter, err = stats.RestoreScalableBloomFilter(*state.BloomFilter)
+		if err != nil {
+			return err
+		}
+	default:
+		d.seen = map[string]dedupeLocation{}
+	}
+
+	d.exactKeys = state.ExactKeys
+	d.topKCounter.Restore(state.TopK)
+	d.numKeyed = state.NumKeyed
+	d.numMissing = state.NumMissing
+	d.numDuplicates = state.NumDuplicates
+	d.partitionDuplicates = map[dedupePartition]int64{}
+	for _, partitionCount := range state.PartitionDuplicates {
+		partition := dedupePartition{
+			source:    partitionCount.Source,
+			partition: partitionCount.Partition,
+		}
+		d.partitionDuplicates[partition] = partitionCount.Duplicates
+	}
+	d.duplicates = state.Duplicates
+
+	return nil
+}
+
+// Stop stops this Deduper instance.
+func (d *Deduper) Stop() error {
+	return nil
+}
+
+// Summary returns a summary of the duplicates found by this Deduper instance.
+func (d *Deduper) Summary() string {
+	d.Lock()
+	defer d.Unlock()
+
+	duplicatePercent := 0.0
+	if d.numKeyed > 0 {
+		duplicatePercent = float64(d.numDuplicates) / float64(d.numKeyed) * 100.0
+	}
+
+	var uniqueKeys int
+	var detection string
+
+	if d.seen != nil {
+		uniqueKeys = len(d.seen)
+		detection = "exact"
+	} else {
+		uniqueKeys = d.bloomFilter.Count()
+		detection = fmt.Sprintf(
+			"exact for the first %d keys, then approximate "+
+				"(bloom filter with ~%0.1f%% false positives)",
+			d.exactKeys,
+			dedupeFPRate*100.0,
+		)
+	}
+
+	lines := []string{
+		fmt.Sprintf("Duplicates by %s:", d.config.KeyPath),
+		fmt.Sprintf("  %d messages with keys", d.numKeyed),
+		fmt.Sprintf("  %d messages without keys", d.numMissing),
+		fmt.Sprintf("  %d unique keys", uniqueKeys),
+		fmt.Sprintf(
+			"  %d duplicates (%0.2f%% of messages with keys)",
+			d.numDuplicates,
+			duplicatePercent,
+		),
+		fmt.Sprintf("  Detection: %s", detection),
+	}
+	if d.config.Drop {
+		lines = append(lines, "  Duplicates were dropped before the other processors")
+	}
+
+	if d.numDuplicates > 0 {
+		lines = append(
+			lines,
+			"Top duplicated keys (count of extra copies):",
+			d.topKCounter.PrettyTable(1, false, false, 1.0, ""),
+			"Duplicates by partition or file:",
+			d.partitionsTable(),
+			fmt.Sprintf("First %d duplicates:", len(d.duplicates)),
+			d.duplicatesTable(),
+		)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// partitionsTable returns a pretty table of the partitions, files, or objects with the most
+// duplicates.
+func (d *Deduper) partitionsTable() string {
+	partitions := make([]dedupePartition, 0, len(d.partitionDuplicates))
+	for partition := range d.partitionDuplicates {
+		partitions = append(partitions, partition)
+	}
+	sort.Slice(partitions, func(a, b int) bool {
+		countA := d.partitionDuplicates[partitions[a]]
+		countB := d.partitionDuplicates[partitions[b]]
+		return countA > countB ||
+			(countA == countB && (partitions[a].source < partitions[b].source ||
+				(partitions[a].source == partitions[b].source &&
+					partitions[a].partition < partitions[b].partition)))
+	})
+	if len(partitions) > d.config.K {
+		partitions = partitions[:d.config.K]
+	}
+
+	buf := &bytes.Buffer{}
+	table := newInfoTable(buf, []string{"Source", "Partition", "Duplicates"})
+
+	for _, partition := range partitions {
+		table.Append(
+			[]string{
+				partition.source,
+				fmt.Sprintf("%d", partition.partition),
+				fmt.Sprintf("%d", d.partitionDuplicates[partition]),
+			},
+		)
+	}
+
+	table.Render()
+	return string(bytes.TrimRight(buf.Bytes(), "\n"))
+}
+
+// duplicatesTable returns a pretty table of the locations of the first duplicates.
+func (d *Deduper) duplicatesTable() string {
+	buf := &bytes.Buffer{}
+	table := newInfoTable(buf, []string{"Key", "Source", "Partition", "Offset", "First Seen"})
+
+	for _, duplicate := range d.duplicates {
+		firstSeen := ""
+		if duplicate.First != nil {
+			firstSeen = fmt.Sprintf(
+				"partition %d, offset %d",
+				duplicate.First.Partition,
+				duplicate.First.Offset,
+			)
+			if duplicate.First.Source != duplicate.Location.Source {
+				firstSeen = fmt.Sprintf("%s %s", duplicate.First.Source, firstSeen)
+			}
+		}
+
+		table.Append(
+			[]string{
+				duplicate.Key,
+				duplicate.Location.Source,
+				fmt.Sprintf("%d", duplicate.Location.Partition),
+				fmt.Sprintf("%d", duplicate.Location.Offset),
+				firstSeen,
+			},
+		)
+	}
+
+	table.Render()
+	return string(bytes.TrimRight(buf.Bytes(), "\n"))
+}
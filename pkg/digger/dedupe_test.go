@@ -0,0 +1,311 @@
+package digger
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/segmentio/data-digger/pkg/message"
+	sjson "github.com/segmentio/encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeduper(t *testing.T) {
+	ctx := context.Background()
+
+	deduper, err := NewDeduper(
+		DedupeConfig{
+			K:         10,
+			KeyPath:   "messageId",
+			MaxMemory: 1024 * 1024,
+		},
+	)
+	require.NoError(t, err)
+
+	messages := []message.Message{
+		{Source: "kafka://localhost/topic", Partition: 0, Offset: 10, Value: []byte(`{"messageId":"a"}`)},
+		{Source: "kafka://localhost/topic", Partition: 1, Offset: 20, Value: []byte(`{"messageId":"b"}`)},
+		{Source: "kafka://localhost/topic", Partition: 1, Offset: 21, Value: []byte(`{"messageId":"a"}`)},
+		{Source: "kafka://localhost/topic", Partition: 0, Offset: 11, Value: []byte(`{"messageId":"a"}`)},
+		{Source: "kafka://localhost/topic", Partition: 0, Offset: 12, Value: []byte(`{"other":"c"}`)},
+		{Source: "kafka://localhost/topic", Partition: 0, Offset: 13, Value: []byte(`not json`)},
+		{Source: "kafka://localhost/topic", Partition: 0, Offset: 14, Value: []byte(`{"messageId":"a","x":tru}`)},
+	}
+
+	for _, msg := range messages {
+		require.NoError(t, deduper.Process(ctx, msg))
+	}
+
+	assert.Equal(t, int64(4), deduper.numKeyed)
+	assert.Equal(t, int64(3), deduper.numMissing)
+	assert.Equal(t, int64(2), deduper.numDuplicates)
+	assert.Equal(
+		t,
+		[]dedupeDuplicate{
+			{
+				Key: "a",
+				Location: dedupeLocation{
+					Source:    "kafka://localhost/topic",
+					Partition: 1,
+					Offset:    21,
+				},
+				First: &dedupeLocation{
+					Source:    "kafka://localhost/topic",
+					Partition: 0,
+					Offset:    10,
+				},
+			},
+			{
+				Key: "a",
+				Location: dedupeLocation{
+					Source:    "kafka://localhost/topic",
+					Partition: 0,
+					Offset:    11,
+				},
+				First: &dedupeLocation{
+					Source:    "kafka://localhost/topic",
+					Partition: 0,
+					Offset:    10,
+				},
+			},
+		},
+		deduper.duplicates,
+	)
+
+	summary := deduper.Summary()
+	assert.Contains(t, summary, "2 duplicates (50.00% of messages with keys)")
+	assert.Contains(t, summary, "2 unique keys")
+	assert.Contains(t, summary, "Detection: exact")
+	assert.Contains(t, summary, "partition 0, offset 10")
+
+	_, err = NewDeduper(DedupeConfig{K: 10})
+	assert.Error(t, err)
+}
+
+func TestDeduperBloomFilter(t *testing.T) {
+	ctx := context.Background()
+
+	// Only a few keys fit in the exact set
+	deduper, err := NewDeduper(
+		DedupeConfig{
+			K:         10,
+			KeyPath:   "id",
+			MaxMemory: 1000,
+		},
+	)
+	require.NoError(t, err)
+
+	for round := 0; round < 2; round++ {
+		for i := 0; i < 1000; i++ {
+			msg := message.Message{Offset: int64(i), Value: []byte(fmt.Sprintf(`{"id":%d}`, i))}
+			require.NoError(t, deduper.Process(ctx, msg))
+		}
+	}
+
+	assert.Nil(t, deduper.seen)
+	assert.Greater(t, deduper.exactKeys, 0)
+
+	// All of the real duplicates are found, plus possibly a few false positives
+	assert.GreaterOrEqual(t, deduper.numDuplicates, int64(1000))
+	assert.Less(t, deduper.numDuplicates, int64(1010))
+	assert.Contains(t, deduper.Summary(), "then approximate")
+}
+
+func TestDeduperDrop(t *testing.T) {
+	ctx := context.Background()
+	tempDir := t.TempDir()
+
+	path := filepath.Join(tempDir, "file.txt")
+	lines := []string{
+		`{"messageId":"a","type":"track"}`,
+		`{"messageId":"b","type":"track"}`,
+		`{"messageId":"a","type":"track"}`,
+		`{"messageId":"c","type":"identify"}`,
+	}
+	require.NoError(t, os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0644))
+
+	for _, numWorkers := range []int{1, 4} {
+		deduper, err := NewDeduper(
+			DedupeConfig{
+				Drop:       true,
+				K:          10,
+				KeyPath:    "messageId",
+				MaxMemory:  1024 * 1024,
+				NumWorkers: numWorkers,
+			},
+		)
+		require.NoError(t, err)
+
+		liveStats, err := NewLiveStats(
+			LiveStatsConfig{
+				K:          10,
+				PathsStr:   "type",
+				NumWorkers: numWorkers,
+			},
+		)
+		require.NoError(t, err)
+
+		digger := &Digger{
+			SourceConsumer: &FileConsumer{Paths: []string{path}},
+			Processors:     []Processor{deduper, liveStats},
+			NumWorkers:     numWorkers,
+		}
+		require.NoError(t, digger.Run(ctx))
+		require.NoError(t, liveStats.Stop())
+
+		// The duplicate isn't counted in the stats
+		buckets := liveStats.topKCounter().Buckets(10, false)
+		require.Equal(t, 2, len(buckets))
+		assert.Equal(t, "track", buckets[0].Key)
+		assert.Equal(t, 2, buckets[0].Count)
+		assert.Equal(t, int64(1), deduper.numDuplicates)
+		assert.Contains(t, deduper.Summary(), "dropped before the other processors")
+	}
+}
+
+func TestDeduperParallel(t *testing.T) {
+	ctx := context.Background()
+
+	deduper, err := NewDeduper(
+		DedupeConfig{
+			K:          10,
+			KeyPath:    "id",
+			MaxMemory:  1024 * 1024,
+			NumWorkers: 4,
+		},
+	)
+	require.NoError(t, err)
+
+	// Each of the 500 keys is seen twice, by different workers
+	wg := sync.WaitGroup{}
+	for worker := 0; worker < 4; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := worker; i < 1000; i += 4 {
+				msg := message.Message{
+					Offset: int64(i),
+					Value:  []byte(fmt.Sprintf(`{"id":"%d"}`, i/2*7%1000)),
+				}
+				assert.NoError(t, deduper.ProcessWorker(ctx, worker, msg, nil))
+			}
+		}(worker)
+	}
+	wg.Wait()
+
+	assert.Equal(t, int64(1000), deduper.numKeyed)
+	assert.Equal(t, int64(500), deduper.numDuplicates)
+	assert.Equal(t, 500, len(deduper.seen))
+}
+
+func TestDeduperCheckpoint(t *testing.T) {
+	ctx := context.Background()
+
+	for _, maxMemory := range []int{1024 * 1024, 1000} {
+		config := DedupeConfig{
+			K:         10,
+			KeyPath:   "id",
+			MaxMemory: maxMemory,
+		}
+
+		deduper, err := NewDeduper(config)
+		require.NoError(t, err)
+
+		for i := 0; i < 1000; i++ {
+			msg := message.Message{
+				Source: "file.txt",
+				Offset: int64(i),
+				Value:  []byte(fmt.Sprintf(`{"id":%d}`, i%600)),
+			}
+			require.NoError(t, deduper.Process(ctx, msg))
+		}
+
+		state, err := deduper.CheckpointState()
+		require.NoError(t, err)
+		stateBytes, err := sjson.Marshal(state)
+		require.NoError(t, err)
+
+		restored, err := NewDeduper(config)
+		require.NoError(t, err)
+		require.NoError(t, restored.RestoreCheckpoint(stateBytes))
+
+		assert.Equal(t, deduper.seen, restored.seen)
+		if deduper.seen != nil {
+			assert.Equal(t, deduper.seenBytes, restored.seenBytes)
+		} else {
+			assert.Equal(t, deduper.bloomFilter.State(), restored.bloomFilter.State())
+		}
+		assert.Equal(t, deduper.exactKeys, restored.exactKeys)
+		assert.Equal(t, deduper.numKeyed, restored.numKeyed)
+		assert.Equal(t, deduper.numMissing, restored.numMissing)
+		assert.Equal(t, deduper.numDuplicates, restored.numDuplicates)
+		assert.Equal(t, deduper.partitionDuplicates, restored.partitionDuplicates)
+		assert.Equal(t, deduper.duplicates, restored.duplicates)
+		assert.Equal(t, deduper.Summary(), restored.Summary())
+
+		// Keys from before the checkpoint are still found after it
+		for _, d := range []*Deduper{deduper, restored} {
+			msg := message.Message{Source: "file.txt", Offset: 1000, Value: []byte(`{"id":5}`)}
+			require.NoError(t, d.Process(ctx, msg))
+		}
+		assert.Equal(t, deduper.numDuplicates, restored.numDuplicates)
+		assert.GreaterOrEqual(t, restored.numDuplicates, int64(401))
+
+		// A different config is rejected
+		other, err := NewDeduper(
+			DedupeConfig{
+				K:         10,
+				KeyPath:   "otherId",
+				MaxMemory: maxMemory,
+			},
+		)
+		require.NoError(t, err)
+		assert.Error(t, other.RestoreCheckpoint(stateBytes))
+	}
+}
+
+func TestDeduperCheckpointLimit(t *testing.T) {
+	config := DedupeConfig{
+		K:         10,
+		KeyPath:   "id",
+		MaxMemory: 1024 * 1024 * 1024,
+	}
+
+	deduper, err := NewDeduper(config)
+	require.NoError(t, err)
+
+	for i := 0; i <= dedupeMaxCheckpointKeys; i++ {
+		deduper.add(fmt.Sprintf("key%d", i), dedupeLocation{Offset: int64(i)})
+	}
+	require.NotNil(t, deduper.seen)
+
+	// Too many keys to save exactly, so they're saved as a Bloom filter
+	state, err := deduper.CheckpointState()
+	require.NoError(t, err)
+	assert.Nil(t, state.(dedupeState).Seen)
+	require.NotNil(t, state.(dedupeState).BloomFilter)
+	assert.Equal(t, dedupeMaxCheckpointKeys+1, state.(dedupeState).ExactKeys)
+
+	stateBytes, err := sjson.Marshal(state)
+	require.NoError(t, err)
+	assert.Less(t, len(stateBytes), 1024*1024)
+
+	// The live deduper still has the exact keys
+	assert.Equal(t, dedupeMaxCheckpointKeys+1, len(deduper.seen))
+
+	restored, err := NewDeduper(config)
+	require.NoError(t, err)
+	require.NoError(t, restored.RestoreCheckpoint(stateBytes))
+	assert.Nil(t, restored.seen)
+	assert.Equal(t, dedupeMaxCheckpointKeys+1, restored.exactKeys)
+
+	duplicate, first := restored.add("key5", dedupeLocation{})
+	assert.True(t, duplicate)
+	assert.Nil(t, first)
+	assert.Contains(t, restored.Summary(), "exact for the first 100001 keys, then approximate")
+}
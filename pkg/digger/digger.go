@@ -2,48 +2,153 @@ package digger
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
 
+	"github.com/segmentio/data-digger/pkg/message"
 	log "github.com/sirupsen/logrus"
 )
 
 const (
-	// Maximum message size to set for scanners
-	maxMessageSize = 512 * 1024
+	// DefaultMaxMessageSize is the maximum message size used by the line-based consumers if
+	// one isn't set explicitly.
+	DefaultMaxMessageSize = 512 * 1024
 )
 
 // Digger is a struct that digs through JSON or proto formatted message streams.
+//
+// The digger owns the messages that it receives from the consumer and releases their pooled
+// buffers once they've been passed through all of the processors, so processors must not keep
+// references to message values (or slices of them) after they return.
 type Digger struct {
 	SourceConsumer Consumer
 	Processors     []Processor
+
+	// Checkpoint is an optional checkpoint that's used to restore the processor states at the
+	// start of the run and that's saved periodically while it's running. It should be shared
+	// with the source consumer so that completed work is skipped.
+	Checkpoint *Checkpoint
+
+	// NumWorkers is the number of workers that process messages in parallel. If it's greater
+	// than one, then processors that implement ParallelProcessor are called concurrently and
+	// their outputs are written in the original message order. Other processors are still
+	// called one message at a time.
+	NumWorkers int
+
+	// Limits are optional limits that stop the run early.
+	Limits Limits
+
+	// stopReason is the reason that the last run stopped.
+	stopReason string
+
+	// output is where the outputs of parallel processors are written; it defaults to stdout.
+	output io.Writer
 }
 
 // Run runs the digger with the provided context. The function returns when all data has been
-// consumed, a fatal error is encountered, or the context is cancelled.
+// consumed, a fatal error is encountered, the context is cancelled, or one of the limits is
+// reached. In the latter case, nil is returned; see StopReason for why the run stopped.
 func (d *Digger) Run(ctx context.Context) error {
-	messageChan := make(chan message)
+	runCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	limiter := newLimiter(d.Limits, d.Processors, cancel)
+	defer limiter.start()()
+
+	err := d.run(runCtx, limiter)
+
+	var limitErr limitReached
+	switch {
+	case errors.As(context.Cause(runCtx), &limitErr):
+		d.stopReason = limitErr.reason
+		return nil
+	case ctx.Err() != nil:
+		d.stopReason = StopReasonInterrupted
+	case err != nil:
+		d.stopReason = fmt.Sprintf("error: %v", err)
+	default:
+		d.stopReason = StopReasonDone
+	}
+
+	return err
+}
+
+// StopReason returns a description of why the last run stopped.
+func (d *Digger) StopReason() string {
+	return d.stopReason
+}
+
+func (d *Digger) run(ctx context.Context, limiter *limiter) error {
+	var checkpointChan <-chan time.Time
+
+	if d.Checkpoint != nil {
+		if err := d.Checkpoint.RestoreSources(d.SourceConsumer); err != nil {
+			return err
+		}
+		if err := d.Checkpoint.Restore(d.Processors); err != nil {
+			return err
+		}
+
+		ticker := time.NewTicker(checkpointInterval)
+		defer ticker.Stop()
+		checkpointChan = ticker.C
+	}
+
+	var messageChan chan message.Message
+	if d.NumWorkers > 1 {
+		messageChan = make(chan message.Message, d.NumWorkers*parallelBufferSize)
+	} else {
+		messageChan = make(chan message.Message)
+	}
 	errChan := make(chan error)
 
 	go func() {
 		errChan <- d.SourceConsumer.Run(ctx, messageChan)
 	}()
 
+	if d.NumWorkers > 1 {
+		return d.runParallel(ctx, limiter, messageChan, errChan, checkpointChan)
+	}
+
 	for {
 		select {
 		case err := <-errChan:
+			d.saveCheckpoint()
 			return err
-		case message := <-messageChan:
-			select {
+		case <-checkpointChan:
+			d.saveCheckpoint()
+		case msg := <-messageChan:
 			// If the context is done, don't process subsequent messages
-			case <-ctx.Done():
+			if ctx.Err() != nil || !limiter.admit() {
+				msg.Release()
 				continue
-			default:
 			}
 
 			for _, p := range d.Processors {
-				if err := p.Process(ctx, message); err != nil {
+				if err := p.Process(ctx, msg); err != nil {
+					if errors.Is(err, ErrSkipMessage) {
+						break
+					}
 					log.Warnf("Failed to process message: %v", err)
 				}
 			}
+
+			d.Checkpoint.update(msg)
+			msg.Release()
+			limiter.check()
 		}
 	}
 }
+
+// saveCheckpoint saves the checkpoint, if there is one. Errors are logged since they shouldn't
+// stop the run.
+func (d *Digger) saveCheckpoint() {
+	if d.Checkpoint == nil {
+		return
+	}
+	if err := d.Checkpoint.Save(d.Processors); err != nil {
+		log.Warnf("Failed to save checkpoint: %v", err)
+	}
+}
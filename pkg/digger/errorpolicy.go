@@ -0,0 +1,242 @@
+package digger
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Actions that an ErrorPolicy can take when a file, object, or partition can't be read
+const (
+	ErrorActionFail  = "fail"
+	ErrorActionSkip  = "skip"
+	ErrorActionRetry = "retry"
+)
+
+const (
+	errorBackoffMin = 200 * time.Millisecond
+	errorBackoffMax = 30 * time.Second
+)
+
+// ErrorPolicy determines what a consumer does when it fails to read a file, object, or partition.
+// With ErrorActionFail, the error stops the whole run. With ErrorActionSkip, the failure is
+// recorded and the consumer moves on. With ErrorActionRetry, the read is retried with exponential
+// backoff up to Retries times (or forever if Retries is negative) before being skipped.
+type ErrorPolicy struct {
+	Action  string
+	Retries int
+}
+
+// ParseErrorPolicy parses an error policy string, which is one of fail, skip, retry, or
+// retry:N. A retry without a count retries forever.
+func ParseErrorPolicy(policyStr string) (ErrorPolicy, error) {
+	action, retriesStr, hasRetries := strings.Cut(policyStr, ":")
+
+	switch action {
+	case ErrorActionFail, ErrorActionSkip:
+		if !hasRetries {
+			return ErrorPolicy{Action: action}, nil
+		}
+	case ErrorActionRetry:
+		if !hasRetries {
+			return ErrorPolicy{Action: action, Retries: -1}, nil
+		}
+
+		retries, err := strconv.Atoi(retriesStr)
+		if err == nil && retries >= 0 {
+			return ErrorPolicy{Action: action, Retries: retries}, nil
+		}
+	}
+
+	return ErrorPolicy{}, fmt.Errorf(
+		"Invalid error policy (expected fail, skip, retry, or retry:N): %s",
+		policyStr,
+	)
+}
+
+// String returns the string representation of this policy.
+func (p ErrorPolicy) String() string {
+	if p.Action == ErrorActionRetry && p.Retries >= 0 {
+		return fmt.Sprintf("%s:%d", p.Action, p.Retries)
+	}
+	return p.Action
+}
+
+// retry returns whether another attempt should be made after the argument number of
+// consecutive failures.
+func (p ErrorPolicy) retry(failures int) bool {
+	return p.Action == ErrorActionRetry && (p.Retries < 0 || failures <= p.Retries)
+}
+
+// skips returns whether sources should be skipped, rather than failing the run, once this
+// policy gives up on them.
+func (p ErrorPolicy) skips() bool {
+	return p.Action == ErrorActionSkip || p.Action == ErrorActionRetry
+}
+
+// do calls fn until it succeeds or this policy gives up on it, waiting between attempts. It
+// returns the number of attempts made and the last error. Errors caused by the context being
+// done aren't retried.
+func (p ErrorPolicy) do(ctx context.Context, fn func() error) (int, error) {
+	attempts := 0
+
+	for {
+		attempts++
+
+		err := fn()
+		if err == nil || ctx.Err() != nil || !p.retry(attempts) {
+			return attempts, err
+		}
+
+		if sleepErr := sleepContext(ctx, errorBackoff(attempts)); sleepErr != nil {
+			return attempts, err
+		}
+	}
+}
+
+// errorBackoff returns the time to wait after the argument number of consecutive failures.
+func errorBackoff(failures int) time.Duration {
+	backoff := errorBackoffMin
+	for i := 1; i < failures && backoff < errorBackoffMax; i++ {
+		backoff *= 2
+	}
+	if backoff > errorBackoffMax {
+		backoff = errorBackoffMax
+	}
+	return backoff
+}
+
+func sleepContext(ctx context.Context, duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SourceFailure records a file, object, or partition that was skipped because it couldn't be
+// read.
+type SourceFailure struct {
+	Source    string    `json:"source"`
+	Partition int       `json:"partition"`
+	Attempts  int       `json:"attempts"`
+	Error     string    `json:"error"`
+	Time      time.Time `json:"time"`
+}
+
+// FailureReport collects the sources that were skipped due to errors during a run. It's safe
+// for concurrent use.
+type FailureReport struct {
+	sync.Mutex
+
+	failures []SourceFailure
+}
+
+// Failures returns the failures recorded so far, sorted by source and partition.
+func (r *FailureReport) Failures() []SourceFailure {
+	r.Lock()
+	defer r.Unlock()
+
+	failures := make([]SourceFailure, len(r.failures))
+	copy(failures, r.failures)
+	sort.Slice(failures, func(a, b int) bool {
+		return failures[a].Source < failures[b].Source ||
+			(failures[a].Source == failures[b].Source &&
+				failures[a].Partition < failures[b].Partition)
+	})
+
+	return failures
+}
+
+// PrettyTable returns a pretty table of the failures recorded so far.
+func (r *FailureReport) PrettyTable() string {
+	buf := &bytes.Buffer{}
+	table := newInfoTable(buf, []string{"Source", "Partition", "Attempts", "Error"})
+
+	for _, failure := range r.Failures() {
+		table.Append(
+			[]string{
+				failure.Source,
+				fmt.Sprintf("%d", failure.Partition),
+				fmt.Sprintf("%d", failure.Attempts),
+				failure.Error,
+			},
+		)
+	}
+
+	table.Render()
+	return buf.String()
+}
+
+// add records a failure. It's safe to call on a nil report.
+func (r *FailureReport) add(source string, partition int, attempts int, err error) {
+	if r == nil {
+		return
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	r.failures = append(
+		r.failures,
+		SourceFailure{
+			Source:    source,
+			Partition: partition,
+			Attempts:  attempts,
+			Error:     err.Error(),
+			Time:      time.Now().UTC(),
+		},
+	)
+}
+
+// readSource reads a single file or object with the argument error policy. The read function is
+// passed the number of records at the start of the source to skip and returns the number that it
+// got through, so that retries and checkpoints don't process the same records twice. If the
+// source is skipped due to the policy, the failure is recorded and nil is returned.
+func readSource(
+	ctx context.Context,
+	policy ErrorPolicy,
+	checkpoint *Checkpoint,
+	failures *FailureReport,
+	source string,
+	partition int,
+	read func(skip int64) (int64, error),
+) error {
+	completed, skip := checkpoint.progress(source)
+	if completed {
+		log.Debugf("Skipping completed source %s", source)
+		return nil
+	}
+
+	attempts, err := policy.do(
+		ctx,
+		func() error {
+			numRecords, err := read(skip)
+			if numRecords > skip {
+				skip = numRecords
+			}
+			if err == nil {
+				checkpoint.finish(source, numRecords)
+			}
+			return err
+		},
+	)
+	if err == nil || ctx.Err() != nil || !policy.skips() {
+		return err
+	}
+
+	log.Warnf("Skipping %s after %d attempt(s): %+v", source, attempts, err)
+	failures.add(source, partition, attempts, err)
+	return nil
+}
@@ -0,0 +1,165 @@
+package digger
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/segmentio/data-digger/pkg/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseErrorPolicy(t *testing.T) {
+	type testCase struct {
+		input    string
+		expected ErrorPolicy
+		err      bool
+	}
+
+	testCases := []testCase{
+		{
+			input:    "fail",
+			expected: ErrorPolicy{Action: ErrorActionFail},
+		},
+		{
+			input:    "skip",
+			expected: ErrorPolicy{Action: ErrorActionSkip},
+		},
+		{
+			input:    "retry",
+			expected: ErrorPolicy{Action: ErrorActionRetry, Retries: -1},
+		},
+		{
+			input:    "retry:3",
+			expected: ErrorPolicy{Action: ErrorActionRetry, Retries: 3},
+		},
+		{
+			input: "retry:-1",
+			err:   true,
+		},
+		{
+			input: "skip:3",
+			err:   true,
+		},
+		{
+			input: "ignore",
+			err:   true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		policy, err := ParseErrorPolicy(testCase.input)
+		if testCase.err {
+			assert.Error(t, err, testCase.input)
+		} else {
+			require.NoError(t, err, testCase.input)
+			assert.Equal(t, testCase.expected, policy, testCase.input)
+			assert.Equal(t, testCase.input, policy.String())
+		}
+	}
+}
+
+func TestErrorBackoff(t *testing.T) {
+	assert.Equal(t, 200*time.Millisecond, errorBackoff(1))
+	assert.Equal(t, 400*time.Millisecond, errorBackoff(2))
+	assert.Equal(t, 800*time.Millisecond, errorBackoff(3))
+	assert.Equal(t, errorBackoffMax, errorBackoff(100))
+}
+
+func TestReadSource(t *testing.T) {
+	ctx := context.Background()
+	readErr := errors.New("connection reset")
+
+	// Each retry should skip the records that were already passed along
+	skips := []int64{}
+	failures := &FailureReport{}
+	err := readSource(
+		ctx,
+		ErrorPolicy{Action: ErrorActionRetry, Retries: 2},
+		nil,
+		failures,
+		"s3://test-bucket/key1",
+		0,
+		func(skip int64) (int64, error) {
+			skips = append(skips, skip)
+			if len(skips) < 3 {
+				return skip + 2, readErr
+			}
+			return skip + 2, nil
+		},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{0, 2, 4}, skips)
+	assert.Empty(t, failures.Failures())
+
+	// Sources are skipped once the retries run out
+	attempts := 0
+	err = readSource(
+		ctx,
+		ErrorPolicy{Action: ErrorActionRetry, Retries: 1},
+		nil,
+		failures,
+		"s3://test-bucket/key2",
+		1,
+		func(skip int64) (int64, error) {
+			attempts++
+			return 0, readErr
+		},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, 2, attempts)
+
+	sourceFailures := failures.Failures()
+	require.Equal(t, 1, len(sourceFailures))
+	assert.Equal(t, "s3://test-bucket/key2", sourceFailures[0].Source)
+	assert.Equal(t, 1, sourceFailures[0].Partition)
+	assert.Equal(t, 2, sourceFailures[0].Attempts)
+	assert.Equal(t, "connection reset", sourceFailures[0].Error)
+
+	// With the default policy, errors are returned
+	err = readSource(
+		ctx,
+		ErrorPolicy{},
+		nil,
+		failures,
+		"s3://test-bucket/key3",
+		2,
+		func(skip int64) (int64, error) {
+			return 0, readErr
+		},
+	)
+	assert.Equal(t, readErr, err)
+	assert.Equal(t, 1, len(failures.Failures()))
+}
+
+func TestFileConsumerSkipErrors(t *testing.T) {
+	ctx := context.Background()
+	tempDir := t.TempDir()
+
+	badPath := filepath.Join(tempDir, "bad.txt.gz")
+	goodPath := filepath.Join(tempDir, "good.txt")
+	require.NoError(t, os.WriteFile(badPath, []byte("not gzipped"), 0644))
+	require.NoError(t, os.WriteFile(goodPath, []byte("line0\nline1"), 0644))
+
+	failures := &FailureReport{}
+	consumer := FileConsumer{
+		Paths:    []string{badPath, goodPath},
+		OnError:  ErrorPolicy{Action: ErrorActionSkip},
+		Failures: failures,
+	}
+	messageChan := make(chan message.Message, 10)
+	require.NoError(t, consumer.Run(ctx, messageChan))
+
+	assert.Equal(t, 2, len(messageChan))
+	sourceFailures := failures.Failures()
+	require.Equal(t, 1, len(sourceFailures))
+	assert.Equal(t, message.FileSource(badPath), sourceFailures[0].Source)
+	assert.Equal(t, 1, sourceFailures[0].Attempts)
+
+	consumer.OnError = ErrorPolicy{Action: ErrorActionFail}
+	assert.Error(t, consumer.Run(ctx, make(chan message.Message, 10)))
+}
@@ -1,40 +1,99 @@
 package digger
 
 import (
-	"bufio"
 	"compress/gzip"
 	"context"
+	"errors"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
-	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/data-digger/pkg/message"
 	log "github.com/sirupsen/logrus"
 )
 
-// FileConsumer is a Consumer implementation that reads from local files.
+// FileConsumer is a Consumer implementation that reads from local files. A path of "-" can be
+// used to read from stdin.
 type FileConsumer struct {
 	Paths     []string
 	Recursive bool
+
+	// Format is the format of the input data; if empty, FormatLines is used.
+	Format string
+
+	// MaxMessageSize is the maximum size of a single message; if zero, DefaultMaxMessageSize is
+	// used. Longer messages are skipped unless TruncateOversized is set.
+	MaxMessageSize    int
+	TruncateOversized bool
+
+	// ParquetColumns is the set of top-level columns to read from parquet inputs; if empty,
+	// all columns are read.
+	ParquetColumns []string
+
+	// CSV stores the options used for the CSV and TSV formats.
+	CSV CSVOptions
+
+	// Checkpoint is an optional checkpoint that's used to skip files that were already
+	// processed. Stdin is never skipped.
+	Checkpoint *Checkpoint
+
+	// OnError is the policy for files that can't be read; if unset, errors stop the run. Stdin
+	// is never retried. Files that are skipped due to errors are recorded in Failures, if it's
+	// set.
+	OnError  ErrorPolicy
+	Failures *FailureReport
 }
 
-var _ Consumer = (*FileConsumer)(nil)
+var _ CheckpointConsumer = (*FileConsumer)(nil)
+
+const (
+	stdinPath   = "-"
+	stdinSource = "stdin://"
+)
 
 type fileSubTask struct {
 	fileInfo    os.FileInfo
-	messageChan chan message
+	messageChan chan message.Message
 	index       int
 }
 
+// fileSources are the inputs of a FileConsumer that are recorded in checkpoints.
+type fileSources struct {
+	Paths     []string `json:"paths"`
+	Recursive bool     `json:"recursive"`
+	Format    string   `json:"format"`
+}
+
+// CheckpointSources returns the paths and the options that determine which records are read
+// from them.
+func (f *FileConsumer) CheckpointSources() interface{} {
+	return fileSources{
+		Paths:     f.Paths,
+		Recursive: f.Recursive,
+		Format:    f.Format,
+	}
+}
+
 // Run starts the file consumer. Messages are passed to the argument message channel.
 func (f *FileConsumer) Run(
 	ctx context.Context,
-	messageChan chan message,
+	messageChan chan message.Message,
 ) error {
 	numFiles := 0
 
 	for _, path := range f.Paths {
+		if path == stdinPath {
+			err := f.processFile(ctx, messageChan, path, nil, numFiles)
+			if err != nil {
+				return err
+			}
+
+			numFiles++
+			continue
+		}
+
 		fileInfo, err := os.Stat(path)
 		if err != nil {
 			return err
@@ -48,7 +107,7 @@ func (f *FileConsumer) Run(
 					}
 
 					if !subInfo.IsDir() {
-						err := f.processFile(ctx, messageChan, subPath, fileInfo, numFiles)
+						err := f.processFile(ctx, messageChan, subPath, subInfo, numFiles)
 						if err != nil {
 							return err
 						}
@@ -77,68 +136,128 @@ func (f *FileConsumer) Run(
 	return nil
 }
 
+// ValidateFilePaths checks that the argument paths can be read in the argument format. Parquet
+// files need to be read out of order, so they can't come from stdin.
+func ValidateFilePaths(paths []string, format string) error {
+	for _, path := range paths {
+		if path == stdinPath && format == FormatParquet {
+			return errors.New("Parquet input can't be read from stdin")
+		}
+	}
+	return nil
+}
+
 func (f *FileConsumer) processFile(
 	ctx context.Context,
-	messageChan chan message,
+	messageChan chan message.Message,
 	filePath string,
 	fileInfo os.FileInfo,
 	index int,
 ) error {
-	log.Debugf("Processing file %s", filePath)
+	source := message.FileSource(filePath)
+	policy := f.OnError
+	checkpoint := f.Checkpoint
 
-	inputFile, err := os.Open(filePath)
-	if err != nil {
-		log.Infof("Error opening file: %+v", err)
-		return err
+	if filePath == stdinPath {
+		// Stdin can't be re-read, so it can't be resumed or retried
+		source = stdinSource
+		checkpoint = nil
+		policy.Retries = 0
 	}
-	defer inputFile.Close()
 
-	var scanReader io.Reader
+	return readSource(
+		ctx,
+		policy,
+		checkpoint,
+		f.Failures,
+		source,
+		index,
+		func(skip int64) (int64, error) {
+			return f.readFile(ctx, messageChan, filePath, fileInfo, source, index, skip)
+		},
+	)
+}
+
+func (f *FileConsumer) readFile(
+	ctx context.Context,
+	messageChan chan message.Message,
+	filePath string,
+	fileInfo os.FileInfo,
+	source string,
+	index int,
+	skip int64,
+) (int64, error) {
+	log.Debugf("Processing file %s", filePath)
 
-	if strings.HasSuffix(filePath, ".gz") {
-		gzipReader, err := gzip.NewReader(inputFile)
+	var inputFile *os.File
+	var modTime time.Time
+	var err error
+
+	if filePath == stdinPath {
+		inputFile = os.Stdin
+	} else {
+		inputFile, err = os.Open(filePath)
 		if err != nil {
-			return err
+			log.Infof("Error opening file: %+v", err)
+			return 0, err
 		}
-		defer gzipReader.Close()
-		scanReader = gzipReader
-	} else {
-		scanReader = inputFile
-	}
-
-	scanner := bufio.NewScanner(scanReader)
-	buf := make([]byte, 4096)
-	scanner.Buffer(buf, maxMessageSize)
+		defer inputFile.Close()
 
-	var offset int64
+		modTime = fileInfo.ModTime()
+	}
 
-	for {
-		if scanner.Scan() {
-			contents := scanner.Bytes()
+	var records recordReader
 
-			// Need to do a copy since scanner can change underlying bytes when
-			// next scan call is made.
-			copiedContents := make([]byte, len(contents))
-			copy(copiedContents, contents)
+	if f.Format == FormatParquet {
+		stat, err := inputFile.Stat()
+		if err != nil {
+			return 0, err
+		}
 
-			messageChan <- message{
-				msg: kafka.Message{
-					Partition: index,
-					Time:      fileInfo.ModTime(),
-					Key:       []byte(filePath),
-					Offset:    offset,
-					Value:     copiedContents,
-				},
-			}
-			offset++
+		records, err = newParquetReader(
+			inputFile,
+			stat.Size(),
+			f.ParquetColumns,
+			f.MaxMessageSize,
+		)
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		var scanReader io.Reader
 
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
-			default:
+		if strings.HasSuffix(filePath, ".gz") {
+			gzipReader, err := gzip.NewReader(inputFile)
+			if err != nil {
+				return 0, err
 			}
+			defer gzipReader.Close()
+			scanReader = gzipReader
 		} else {
-			return scanner.Err()
+			scanReader = inputFile
+		}
+
+		records, err = newRecordReader(
+			f.Format,
+			scanReader,
+			f.MaxMessageSize,
+			f.CSV,
+		)
+		if err != nil {
+			return 0, err
 		}
 	}
+
+	return readRecords(
+		ctx,
+		records,
+		message.Message{
+			Source:    source,
+			Partition: index,
+			ModTime:   modTime,
+		},
+		f.TruncateOversized,
+		skip,
+		messageChan,
+	)
 }
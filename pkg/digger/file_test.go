@@ -4,6 +4,7 @@ import (
 	"context"
 	"testing"
 
+	"github.com/segmentio/data-digger/pkg/message"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -17,7 +18,7 @@ func TestFileConsumerRecursive(t *testing.T) {
 		},
 		Recursive: true,
 	}
-	messageChan := make(chan message, 50)
+	messageChan := make(chan message.Message, 50)
 	err := consumer.Run(ctx, messageChan)
 	require.NoError(t, err)
 
@@ -25,14 +26,16 @@ func TestFileConsumerRecursive(t *testing.T) {
 	message1 := <-messageChan
 	message2 := <-messageChan
 
-	assert.Equal(t, 0, message1.msg.Partition)
-	assert.Equal(t, 0, message2.msg.Partition)
-	assert.Equal(t, int64(0), message1.msg.Offset)
-	assert.Equal(t, int64(1), message2.msg.Offset)
-	assert.Equal(t, []byte("testdata/files/file1.txt"), message1.msg.Key)
-	assert.Equal(t, []byte("testdata/files/file1.txt"), message2.msg.Key)
-	assert.Equal(t, []byte(`{"key1":"value1"}`), message1.msg.Value)
-	assert.Equal(t, []byte(`{"key1":"value2"}`), message2.msg.Value)
+	assert.Equal(t, 0, message1.Partition)
+	assert.Equal(t, 0, message2.Partition)
+	assert.Equal(t, int64(0), message1.Offset)
+	assert.Equal(t, int64(1), message2.Offset)
+	assert.Equal(t, "file://testdata/files/file1.txt", message1.Source)
+	assert.Equal(t, "file://testdata/files/file1.txt", message2.Source)
+	assert.Equal(t, int64(0), message1.Position)
+	assert.Equal(t, int64(18), message2.Position)
+	assert.Equal(t, []byte(`{"key1":"value1"}`), message1.Value)
+	assert.Equal(t, []byte(`{"key1":"value2"}`), message2.Value)
 }
 
 func TestFileConsumerNonRecursive(t *testing.T) {
@@ -45,7 +48,7 @@ func TestFileConsumerNonRecursive(t *testing.T) {
 		},
 		Recursive: false,
 	}
-	messageChan := make(chan message, 50)
+	messageChan := make(chan message.Message, 50)
 	err := consumer.Run(ctx, messageChan)
 	require.NoError(t, err)
 
@@ -53,12 +56,60 @@ func TestFileConsumerNonRecursive(t *testing.T) {
 	message1 := <-messageChan
 	message2 := <-messageChan
 
-	assert.Equal(t, 0, message1.msg.Partition)
-	assert.Equal(t, 1, message2.msg.Partition)
-	assert.Equal(t, int64(0), message1.msg.Offset)
-	assert.Equal(t, int64(0), message2.msg.Offset)
-	assert.Equal(t, []byte("testdata/files/subdir/file3.txt"), message1.msg.Key)
-	assert.Equal(t, []byte("testdata/files/file1.txt"), message2.msg.Key)
-	assert.Equal(t, []byte(`{"key3":"value3"}`), message1.msg.Value)
-	assert.Equal(t, []byte(`{"key1":"value1"}`), message2.msg.Value)
+	assert.Equal(t, 0, message1.Partition)
+	assert.Equal(t, 1, message2.Partition)
+	assert.Equal(t, int64(0), message1.Offset)
+	assert.Equal(t, int64(0), message2.Offset)
+	assert.Equal(t, "file://testdata/files/subdir/file3.txt", message1.Source)
+	assert.Equal(t, "file://testdata/files/file1.txt", message2.Source)
+	assert.False(t, message1.ModTime.IsZero())
+	assert.Equal(t, []byte(`{"key3":"value3"}`), message1.Value)
+	assert.Equal(t, []byte(`{"key1":"value1"}`), message2.Value)
+}
+
+func TestFileConsumerOversized(t *testing.T) {
+	ctx := context.Background()
+
+	consumer := FileConsumer{
+		Paths: []string{
+			"testdata/oversized.txt",
+		},
+		MaxMessageSize: 20,
+	}
+	messageChan := make(chan message.Message, 50)
+	err := consumer.Run(ctx, messageChan)
+	require.NoError(t, err)
+
+	require.Equal(t, 3, len(messageChan))
+	message1 := <-messageChan
+	message2 := <-messageChan
+	message3 := <-messageChan
+
+	assert.False(t, message1.Oversized)
+	assert.True(t, message2.Oversized)
+	assert.Nil(t, message2.Value)
+	assert.Equal(t, int64(1), message2.Offset)
+	assert.False(t, message3.Oversized)
+	assert.Equal(t, []byte(`{"key1":"value3"}`), message3.Value)
+
+	consumer.TruncateOversized = true
+	err = consumer.Run(ctx, messageChan)
+	require.NoError(t, err)
+
+	require.Equal(t, 3, len(messageChan))
+	<-messageChan
+	message2 = <-messageChan
+
+	assert.True(t, message2.Oversized)
+	assert.Equal(t, []byte(`{"key1":"a much long`), message2.Value)
+}
+
+func TestValidateFilePaths(t *testing.T) {
+	assert.NoError(t, ValidateFilePaths([]string{"-"}, FormatLines))
+	assert.NoError(t, ValidateFilePaths([]string{"a.parquet"}, FormatParquet))
+	assert.ErrorContains(
+		t,
+		ValidateFilePaths([]string{"a.parquet", "-"}, FormatParquet),
+		"Parquet input can't be read from stdin",
+	)
 }
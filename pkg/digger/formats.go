@@ -0,0 +1,226 @@
+package digger
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/segmentio/data-digger/pkg/message"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// FormatLines is the default input format for the file and S3 sources; each line in the
+	// input is treated as a separate message.
+	FormatLines = "lines"
+
+	// FormatProtoDelimited is an input format consisting of protobuf messages that are each
+	// prefixed by their varint-encoded length, as written by protodelim or writeDelimitedTo.
+	FormatProtoDelimited = "protodelim"
+
+	// Multiple of the max message size above which a length prefix is treated as corrupt instead
+	// of as an oversized message that's skipped
+	protoDelimMaxLengthFactor = 16
+)
+
+// recordReader is an interface for types that split an input stream into messages.
+type recordReader interface {
+	// next returns the contents of the next record and whether the record exceeded the max
+	// message size (in which case it's truncated). The returned bytes are only valid until the
+	// next call. At the end of the stream, io.EOF is returned.
+	next() ([]byte, bool, error)
+}
+
+// positionRecordReader is implemented by record readers that know the byte position of each
+// record in the input. The positions of the records from other readers are unknown.
+type positionRecordReader interface {
+	recordReader
+
+	// lastPosition returns the byte position in the input of the record that was most recently
+	// returned by next.
+	lastPosition() int64
+}
+
+// attributeRecordReader is implemented by record readers that add their own attributes to the
+// messages, e.g. the parquet row group that each record was read from.
+type attributeRecordReader interface {
+	recordReader
+
+	// lastAttributes returns the attributes for the record that was most recently returned by
+	// next, including the argument ones from the source. Like the source attributes, the
+	// returned map may be shared between records and must not be modified.
+	lastAttributes(base map[string]string) map[string]string
+}
+
+func newRecordReader(
+	format string,
+	reader io.Reader,
+	maxSize int,
+	csvOptions CSVOptions,
+) (recordReader, error) {
+	switch format {
+	case FormatLines, "":
+		return newLineReader(reader, maxSize), nil
+	case FormatProtoDelimited:
+		return newProtoDelimReader(reader, maxSize), nil
+	case FormatJSONStream:
+		return newJSONStreamReader(reader, maxSize), nil
+	case FormatCSV, FormatTSV:
+		return newCSVReader(reader, format, csvOptions, maxSize), nil
+	default:
+		return nil, fmt.Errorf("Unrecognized format: %s", format)
+	}
+}
+
+// readRecords reads all of the records from the argument reader and passes them to the message
+// channel. The source-level fields of each message (e.g., Source and Partition) are copied from
+// the argument template. The first skip records are read but not passed along, e.g. because
+// they were already processed before a checkpoint or a retry. It returns the number of records
+// that were read, including the skipped ones; if there's an error, this is the number that were
+// successfully passed along before it.
+func readRecords(
+	ctx context.Context,
+	records recordReader,
+	template message.Message,
+	truncateOversized bool,
+	skip int64,
+	messageChan chan message.Message,
+) (int64, error) {
+	var index int64
+
+	for {
+		contents, oversized, err := records.next()
+		if err == io.EOF {
+			return index, nil
+		} else if err != nil {
+			return index, err
+		}
+
+		if index < skip {
+			index++
+			continue
+		}
+
+		msg := template
+		msg.Offset = index
+		msg.Position = -1
+		msg.Oversized = oversized
+
+		if positionRecords, ok := records.(positionRecordReader); ok {
+			msg.Position = positionRecords.lastPosition()
+		}
+		if offsetRecords, ok := records.(offsetRecordReader); ok {
+			// The offsets are the byte positions of the records
+			msg.Offset = offsetRecords.lastOffset()
+			msg.Position = msg.Offset
+		}
+		if attributeRecords, ok := records.(attributeRecordReader); ok {
+			msg.Attributes = attributeRecords.lastAttributes(template.Attributes)
+		}
+
+		if !oversized || truncateOversized {
+			// Need to do a copy since the record reader can change underlying bytes when
+			// next call is made; the digger releases the buffer once the message is processed
+			msg.SetPooledValue(contents)
+		} else {
+			log.Debugf("Skipping oversized message at %s:%d", msg.Source, msg.Offset)
+		}
+
+		select {
+		case messageChan <- msg:
+		case <-ctx.Done():
+			return index, ctx.Err()
+		}
+		index++
+	}
+}
+
+// protoDelimReader splits a stream of length-delimited protobuf messages.
+type protoDelimReader struct {
+	reader  *bufio.Reader
+	maxSize int
+	buf     []byte
+
+	position int64
+	start    int64
+}
+
+func newProtoDelimReader(reader io.Reader, maxSize int) *protoDelimReader {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxMessageSize
+	}
+
+	return &protoDelimReader{
+		reader:  bufio.NewReader(reader),
+		maxSize: maxSize,
+		buf:     make([]byte, 0, 4096),
+	}
+}
+
+func (p *protoDelimReader) next() ([]byte, bool, error) {
+	p.start = p.position
+
+	size, err := binary.ReadUvarint(p.reader)
+	if err != nil {
+		// ReadUvarint only returns io.EOF if no bytes were read
+		return nil, false, err
+	}
+	if size > uint64(p.maxSize)*protoDelimMaxLengthFactor {
+		// Skipping this many bytes would probably lose the position of the following messages,
+		// so it's better to stop
+		return nil, false, fmt.Errorf(
+			"Invalid message length %d at byte %d; the input may be corrupt",
+			size,
+			p.start,
+		)
+	}
+	p.position += int64(uvarintLen(size))
+
+	oversized := false
+	readSize := size
+
+	if size > uint64(p.maxSize) {
+		oversized = true
+		readSize = uint64(p.maxSize)
+	}
+
+	if uint64(cap(p.buf)) < readSize {
+		p.buf = make([]byte, readSize)
+	}
+	p.buf = p.buf[0:readSize]
+
+	if _, err := io.ReadFull(p.reader, p.buf); err != nil {
+		return nil, false, unexpectedEOF(err)
+	}
+
+	if oversized {
+		if _, err := p.reader.Discard(int(size - readSize)); err != nil {
+			return nil, false, unexpectedEOF(err)
+		}
+	}
+	p.position += int64(size)
+
+	return p.buf, oversized, nil
+}
+
+func (p *protoDelimReader) lastPosition() int64 {
+	return p.start
+}
+
+func uvarintLen(value uint64) int {
+	n := 1
+	for value >= 0x80 {
+		value >>= 7
+		n++
+	}
+	return n
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,132 @@
+package digger
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/segmentio/data-digger/pkg/message"
+	pb "github.com/segmentio/data-digger/pkg/proto/protobuf"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProtoDelimReader(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	testMessages := []*pb.TestMessage1{
+		{Key1: "value1"},
+		{Key1: "a value that's longer than the max message size"},
+		{Key1: "value3", Key2: 3},
+	}
+	encodedMessages := [][]byte{}
+
+	for _, testMessage := range testMessages {
+		contents, err := proto.Marshal(testMessage)
+		require.NoError(t, err)
+		encodedMessages = append(encodedMessages, contents)
+
+		buf.Write(binary.AppendUvarint(nil, uint64(len(contents))))
+		buf.Write(contents)
+	}
+
+	records, err := newRecordReader(FormatProtoDelimited, buf, 20, CSVOptions{})
+	require.NoError(t, err)
+
+	contents, oversized, err := records.next()
+	require.NoError(t, err)
+	assert.False(t, oversized)
+	assert.Equal(t, encodedMessages[0], contents)
+
+	contents, oversized, err = records.next()
+	require.NoError(t, err)
+	assert.True(t, oversized)
+	assert.Equal(t, encodedMessages[1][0:20], contents)
+
+	contents, oversized, err = records.next()
+	require.NoError(t, err)
+	assert.False(t, oversized)
+	assert.Equal(t, encodedMessages[2], contents)
+
+	_, _, err = records.next()
+	assert.Equal(t, io.EOF, err)
+
+	// Truncated stream
+	records, err = newRecordReader(
+		FormatProtoDelimited,
+		bytes.NewReader(append(binary.AppendUvarint(nil, 10), 'a', 'b')),
+		20,
+		CSVOptions{},
+	)
+	require.NoError(t, err)
+	_, _, err = records.next()
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	// Corrupt length prefix that's far larger than the max message size
+	records, err = newRecordReader(
+		FormatProtoDelimited,
+		bytes.NewReader(append(binary.AppendUvarint(nil, 1<<62), 'a', 'b')),
+		20,
+		CSVOptions{},
+	)
+	require.NoError(t, err)
+	_, _, err = records.next()
+	assert.ErrorContains(t, err, "Invalid message length")
+
+	_, err = newRecordReader("bad-format", buf, 20, CSVOptions{})
+	assert.Error(t, err)
+}
+
+func BenchmarkReadRecords(b *testing.B) {
+	ctx := context.Background()
+	contents := readSampleData(b)
+
+	b.SetBytes(int64(len(contents)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		messageChan := make(chan message.Message, 64)
+		done := make(chan struct{})
+
+		go func() {
+			for msg := range messageChan {
+				msg.Release()
+			}
+			close(done)
+		}()
+
+		_, err := readRecords(
+			ctx,
+			newLineReader(bytes.NewReader(contents), 0),
+			message.Message{},
+			false,
+			0,
+			messageChan,
+		)
+		require.NoError(b, err)
+
+		close(messageChan)
+		<-done
+	}
+}
+
+// readSampleData returns the decompressed contents of the sample dataset in testdata, which was
+// generated with scripts/generate_sample_data.py.
+func readSampleData(tb testing.TB) []byte {
+	file, err := os.Open("testdata/sample/archives_00.gz")
+	require.NoError(tb, err)
+	defer file.Close()
+
+	reader, err := gzip.NewReader(file)
+	require.NoError(tb, err)
+
+	contents, err := io.ReadAll(reader)
+	require.NoError(tb, err)
+	return contents
+}
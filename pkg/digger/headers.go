@@ -0,0 +1,132 @@
+package digger
+
+import (
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/segmentio/data-digger/pkg/message"
+	sjson "github.com/segmentio/encoding/json"
+)
+
+const (
+	// HeaderEncodingString shows header values as JSON strings.
+	HeaderEncodingString = "string"
+
+	// HeaderEncodingJSON shows header values as embedded JSON, falling back to strings for
+	// values that aren't valid JSON.
+	HeaderEncodingJSON = "json"
+
+	// HeaderEncodingBase64 shows header values as base64-encoded strings; useful for binary
+	// values.
+	HeaderEncodingBase64 = "base64"
+)
+
+// headerFilter is a filter that matches the value of a message header against a regexp.
+type headerFilter struct {
+	key    string
+	regexp *regexp.Regexp
+}
+
+type extendedHeader struct {
+	Key   string           `json:"key"`
+	Value sjson.RawMessage `json:"value"`
+}
+
+func parseHeaderFilters(filterStrs []string) ([]headerFilter, error) {
+	filters := []headerFilter{}
+
+	for _, filterStr := range filterStrs {
+		components := strings.SplitN(filterStr, "=", 2)
+		if len(components) != 2 || components[0] == "" {
+			return nil, fmt.Errorf("Header filter must be in key=regexp format: %s", filterStr)
+		}
+
+		valueRegexp, err := regexp.Compile(components[1])
+		if err != nil {
+			return nil, err
+		}
+
+		filters = append(
+			filters,
+			headerFilter{
+				key:    components[0],
+				regexp: valueRegexp,
+			},
+		)
+	}
+
+	return filters, nil
+}
+
+// matchHeaders returns whether the argument headers satisfy all of the filters. A filter
+// matches if any header with its key has a matching value.
+func matchHeaders(headers []message.Header, filters []headerFilter) bool {
+	for _, filter := range filters {
+		matched := false
+
+		for _, header := range headers {
+			if header.Key == filter.key && filter.regexp.Match(header.Value) {
+				matched = true
+				break
+			}
+		}
+
+		if !matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+func validateHeaderEncoding(encoding string) error {
+	switch encoding {
+	case HeaderEncodingString, HeaderEncodingJSON, HeaderEncodingBase64, "":
+		return nil
+	default:
+		return fmt.Errorf("Unrecognized header encoding: %s", encoding)
+	}
+}
+
+// encodeHeaders converts the argument headers to their representation in the raw extended
+// output.
+func encodeHeaders(headers []message.Header, encoding string) []extendedHeader {
+	if len(headers) == 0 {
+		return nil
+	}
+
+	encoded := make([]extendedHeader, 0, len(headers))
+
+	for _, header := range headers {
+		var value []byte
+
+		switch encoding {
+		case HeaderEncodingBase64:
+			value = sjson.AppendEscape(
+				nil,
+				base64.StdEncoding.EncodeToString(header.Value),
+				0,
+			)
+		case HeaderEncodingJSON:
+			if sjson.Valid(header.Value) {
+				value = header.Value
+			} else {
+				value = sjson.AppendEscape(nil, string(header.Value), 0)
+			}
+		default:
+			value = sjson.AppendEscape(nil, string(header.Value), 0)
+		}
+
+		encoded = append(
+			encoded,
+			extendedHeader{
+				Key:   header.Key,
+				Value: sjson.RawMessage(value),
+			},
+		)
+	}
+
+	return encoded
+}
@@ -0,0 +1,122 @@
+package digger
+
+import (
+	"context"
+	"testing"
+
+	"github.com/segmentio/data-digger/pkg/message"
+	sjson "github.com/segmentio/encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeHeaders(t *testing.T) {
+	type testCase struct {
+		encoding string
+		expected string
+	}
+
+	headers := []message.Header{
+		{Key: "type", Value: []byte("track")},
+		{Key: "context", Value: []byte(`{"tenant":"t1"}`)},
+	}
+
+	testCases := []testCase{
+		{
+			encoding: HeaderEncodingString,
+			expected: `[{"key":"type","value":"track"},{"key":"context","value":"{\"tenant\":\"t1\"}"}]`,
+		},
+		{
+			encoding: HeaderEncodingJSON,
+			expected: `[{"key":"type","value":"track"},{"key":"context","value":{"tenant":"t1"}}]`,
+		},
+		{
+			encoding: HeaderEncodingBase64,
+			expected: `[{"key":"type","value":"dHJhY2s="},{"key":"context","value":"eyJ0ZW5hbnQiOiJ0MSJ9"}]`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		output, err := sjson.Marshal(encodeHeaders(headers, testCase.encoding))
+		require.NoError(t, err)
+		assert.Equal(t, testCase.expected, string(output), testCase.encoding)
+	}
+
+	assert.Nil(t, encodeHeaders(nil, HeaderEncodingString))
+}
+
+func TestLiveStatsHeaderFilters(t *testing.T) {
+	ctx := context.Background()
+
+	liveStats, err := NewLiveStats(
+		LiveStatsConfig{
+			K:             10,
+			HeaderFilters: []string{"type=^track$", "tenant=t[12]"},
+			PathsStr:      "id",
+		},
+	)
+	require.Nil(t, err)
+
+	messages := []message.Message{
+		{
+			Headers: []message.Header{
+				{Key: "type", Value: []byte("track")},
+				{Key: "tenant", Value: []byte("t1")},
+			},
+			Value: []byte(`{"id": "id1"}`),
+		},
+		{
+			Headers: []message.Header{
+				{Key: "tenant", Value: []byte("t3")},
+				{Key: "tenant", Value: []byte("t2")},
+				{Key: "type", Value: []byte("track")},
+			},
+			Value: []byte(`{"id": "id2"}`),
+		},
+		{
+			Headers: []message.Header{
+				{Key: "type", Value: []byte("identify")},
+				{Key: "tenant", Value: []byte("t1")},
+			},
+			Value: []byte(`{"id": "id3"}`),
+		},
+		{
+			Headers: []message.Header{
+				{Key: "type", Value: []byte("track")},
+			},
+			Value: []byte(`{"id": "id4"}`),
+		},
+		{
+			// Not decoded, so not counted as invalid
+			Headers: []message.Header{
+				{Key: "type", Value: []byte("page")},
+			},
+			Value: []byte(`not json`),
+		},
+	}
+
+	for _, msg := range messages {
+		err := liveStats.Process(ctx, msg)
+		require.NoError(t, err)
+	}
+
+	err = liveStats.Stop()
+	require.NoError(t, err)
+
+	buckets := liveStats.topKCounter().Buckets(4, true)
+	require.Equal(t, 2, len(buckets))
+	assert.Equal(t, "id1", buckets[0].Key)
+	assert.Equal(t, "id2", buckets[1].Key)
+
+	summary := liveStats.messageCounter().Summary()
+	assert.Equal(t, int64(5), summary.TotalMessages)
+	assert.Equal(t, int64(2), summary.PostFilterMessages)
+}
+
+func TestLiveStatsInvalidHeaderConfig(t *testing.T) {
+	_, err := NewLiveStats(LiveStatsConfig{HeaderEncoding: "hex"})
+	assert.Error(t, err)
+
+	_, err = NewLiveStats(LiveStatsConfig{HeaderFilters: []string{"type"}})
+	assert.Error(t, err)
+}
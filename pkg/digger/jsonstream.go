@@ -0,0 +1,116 @@
+package digger
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+)
+
+const (
+	// FormatJSONStream is an input format for streams of JSON values that aren't necessarily
+	// newline-delimited, e.g. pretty-printed objects or a single, big array. Each top-level
+	// value, or each element of a top-level array, is treated as a separate message.
+	FormatJSONStream = "json-stream"
+)
+
+// offsetRecordReader is implemented by record readers that use the byte position of each record
+// in the input as its offset instead of the record's sequence number.
+type offsetRecordReader interface {
+	recordReader
+
+	// lastOffset returns the offset of the record that was most recently returned by next.
+	lastOffset() int64
+}
+
+// jsonStreamReader is a recordReader implementation that reads a stream of JSON values with a
+// streaming JSON decoder. Since the decoder can't recover from syntax errors, a malformed value
+// stops the stream with an error instead of being passed through.
+type jsonStreamReader struct {
+	decoder *json.Decoder
+	maxSize int
+	value   json.RawMessage
+
+	offset  int64
+	inArray bool
+}
+
+var _ offsetRecordReader = (*jsonStreamReader)(nil)
+
+func newJSONStreamReader(reader io.Reader, maxSize int) *jsonStreamReader {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxMessageSize
+	}
+
+	return &jsonStreamReader{
+		decoder: json.NewDecoder(reader),
+		maxSize: maxSize,
+	}
+}
+
+func (j *jsonStreamReader) next() ([]byte, bool, error) {
+	for {
+		if !j.decoder.More() {
+			// The next token ends the current array, or the input is done or invalid
+			token, err := j.decoder.Token()
+			if err == io.EOF && j.inArray {
+				return nil, false, io.ErrUnexpectedEOF
+			} else if err != nil {
+				return nil, false, err
+			}
+
+			if token == json.Delim(']') {
+				j.inArray = false
+			}
+			continue
+		}
+
+		if !j.inArray && j.peek() == '[' {
+			// Consume the opening bracket so that the elements are decoded one at a time
+			if _, err := j.decoder.Token(); err != nil {
+				return nil, false, err
+			}
+			j.inArray = true
+			continue
+		}
+
+		if err := j.decoder.Decode(&j.value); err != nil {
+			return nil, false, err
+		}
+
+		// The decoder's offset is at the end of the value
+		j.offset = j.decoder.InputOffset() - int64(len(j.value))
+
+		if len(j.value) > j.maxSize {
+			return j.value[:j.maxSize], true, nil
+		}
+		return j.value, false, nil
+	}
+}
+
+func (j *jsonStreamReader) lastOffset() int64 {
+	return j.offset
+}
+
+// peek returns the first byte of the next value. It must be called after More, which reads the
+// start of the value into the decoder's buffer.
+func (j *jsonStreamReader) peek() byte {
+	buffered := j.decoder.Buffered()
+	byteReader, ok := buffered.(io.ByteReader)
+	if !ok {
+		byteReader = bufio.NewReader(buffered)
+	}
+
+	for {
+		b, err := byteReader.ReadByte()
+		if err != nil {
+			return 0
+		}
+
+		switch b {
+		case ' ', '\t', '\n', '\r', ',':
+			// Skip the whitespace and separators that the decoder hasn't consumed yet
+		default:
+			return b
+		}
+	}
+}
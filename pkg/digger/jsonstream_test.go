@@ -0,0 +1,135 @@
+package digger
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONStreamReader(t *testing.T) {
+	type record struct {
+		contents  string
+		offset    int64
+		oversized bool
+	}
+
+	type testCase struct {
+		description     string
+		input           string
+		maxSize         int
+		expectedRecords []record
+		expectedErr     error
+	}
+
+	testCases := []testCase{
+		{
+			description: "pretty-printed objects",
+			input: `{
+  "key": "value1",
+  "nested": {"list": [1, 2]}
+}
+{
+  "key": "value with \"quoted\" braces }]"
+}`,
+			expectedRecords: []record{
+				{
+					contents: "{\n  \"key\": \"value1\",\n  \"nested\": {\"list\": [1, 2]}\n}",
+					offset:   0,
+				},
+				{
+					contents: "{\n  \"key\": \"value with \\\"quoted\\\" braces }]\"\n}",
+					offset:   52,
+				},
+			},
+			expectedErr: io.EOF,
+		},
+		{
+			description: "top-level array",
+			input:       ` [{"key": 1}, "string", 12.5,[true, null] ]`,
+			expectedRecords: []record{
+				{contents: `{"key": 1}`, offset: 2},
+				{contents: `"string"`, offset: 14},
+				{contents: `12.5`, offset: 24},
+				{contents: `[true, null]`, offset: 29},
+			},
+			expectedErr: io.EOF,
+		},
+		{
+			description: "oversized and incomplete values",
+			input:       `{"key": "a long value"} {"key": "value2"`,
+			maxSize:     10,
+			expectedRecords: []record{
+				{contents: `{"key": "a`, offset: 0, oversized: true},
+			},
+			expectedErr: io.ErrUnexpectedEOF,
+		},
+		{
+			description: "nested arrays",
+			input:       "[[1, 2],\n [3]]\n[4]",
+			expectedRecords: []record{
+				{contents: `[1, 2]`, offset: 1},
+				{contents: `[3]`, offset: 10},
+				{contents: `4`, offset: 16},
+			},
+			expectedErr: io.EOF,
+		},
+	}
+
+	for _, testCase := range testCases {
+		records, err := newRecordReader(
+			FormatJSONStream,
+			strings.NewReader(testCase.input),
+			testCase.maxSize,
+			CSVOptions{},
+		)
+		require.NoError(t, err)
+		offsetRecords, ok := records.(offsetRecordReader)
+		require.True(t, ok)
+
+		results := []record{}
+
+		for {
+			contents, oversized, err := records.next()
+			if err != nil {
+				assert.Equal(t, testCase.expectedErr, err, testCase.description)
+				break
+			}
+
+			results = append(
+				results,
+				record{
+					contents:  string(contents),
+					offset:    offsetRecords.lastOffset(),
+					oversized: oversized,
+				},
+			)
+		}
+
+		assert.Equal(t, testCase.expectedRecords, results, testCase.description)
+	}
+}
+
+func TestJSONStreamReaderInvalid(t *testing.T) {
+	inputs := []string{
+		`{"key": 1} ]`,
+		`{"key": 1} garbage`,
+		`[{"key": 1} {"key": 2}]`,
+		`[{"key": 1},`,
+	}
+
+	for _, input := range inputs {
+		records := newJSONStreamReader(strings.NewReader(input), 0)
+
+		contents, _, err := records.next()
+		require.NoError(t, err, input)
+		assert.Equal(t, `{"key": 1}`, string(contents), input)
+
+		// The decoder can't recover from syntax errors, so they stop the stream
+		_, _, err = records.next()
+		assert.Error(t, err, input)
+		assert.NotErrorIs(t, err, io.EOF, input)
+	}
+}
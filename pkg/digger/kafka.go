@@ -1,9 +1,12 @@
 package digger
 
 import (
+	"bytes"
 	"context"
+	"fmt"
 	"time"
 
+	"github.com/segmentio/data-digger/pkg/message"
 	"github.com/segmentio/kafka-go"
 	log "github.com/sirupsen/logrus"
 )
@@ -14,17 +17,39 @@ const (
 	kafkaMaxAttempts    int           = 5
 )
 
-// KafkaConsumer is a Consumer implementation that reads messages from a Kafka topic.
+// newKafkaReader creates the partition readers; it's replaced in tests.
+var newKafkaReader = kafka.NewReader
+
+// KafkaConsumer is a Consumer implementation that reads messages from one or more Kafka topics.
 type KafkaConsumer struct {
-	Address    string
-	Topic      string
+	Address string
+
+	// Topic is the topic for the partitions that don't have their own Topic set.
+	Topic string
+
+	// Partitions are the topic-partitions to consume.
 	Partitions []kafka.Partition
-	Offset     int64
-	Since      time.Time
-	Until      time.Time
+
+	// Key is an optional message key; if it's set, then only the messages with exactly this
+	// key are passed on.
+	Key []byte
+
+	Offset int64
+	Since  time.Time
+	Until  time.Time
+
+	// Offsets are per-partition starting positions. They take precedence over Offset and
+	// Since for the partitions that they apply to. Run returns an error if they don't apply
+	// unambiguously to the topics of the partitions.
+	Offsets PartitionOffsets
 
 	MinBytes int
 	MaxBytes int
+
+	// OnError is the policy for partitions that can't be read; if unset, reads are retried
+	// forever. Partitions that are skipped due to errors are recorded in Failures, if it's set.
+	OnError  ErrorPolicy
+	Failures *FailureReport
 }
 
 var _ Consumer = (*KafkaConsumer)(nil)
@@ -32,14 +57,22 @@ var _ Consumer = (*KafkaConsumer)(nil)
 // Run starts the kafka consumer. Messages are passed to the argument message channel.
 func (k *KafkaConsumer) Run(
 	ctx context.Context,
-	messageChan chan message,
+	messageChan chan message.Message,
 ) error {
+	topics := []string{}
+	for _, partition := range k.Partitions {
+		topics = append(topics, k.partitionTopic(partition))
+	}
+	if err := k.Offsets.CheckTopics(topics); err != nil {
+		return err
+	}
+
 	errChan := make(chan error, len(k.Partitions))
 
 	for _, partition := range k.Partitions {
-		go func(partitionID int) {
-			errChan <- k.consumePartition(ctx, messageChan, partitionID)
-		}(partition.ID)
+		go func(topic string, partitionID int) {
+			errChan <- k.consumePartition(ctx, messageChan, topic, partitionID)
+		}(k.partitionTopic(partition), partition.ID)
 	}
 
 	for i := 0; i < len(k.Partitions); i++ {
@@ -51,50 +84,137 @@ func (k *KafkaConsumer) Run(
 	return nil
 }
 
+// partitionTopic returns the topic of the argument partition.
+func (k *KafkaConsumer) partitionTopic(partition kafka.Partition) string {
+	if partition.Topic == "" {
+		return k.Topic
+	}
+	return partition.Topic
+}
+
 func (k *KafkaConsumer) consumePartition(
 	ctx context.Context,
-	messageChan chan message,
+	messageChan chan message.Message,
+	topic string,
 	partition int,
 ) error {
-	reader, err := k.newReader(ctx, partition)
+	policy := k.OnError
+	if policy.Action == "" {
+		policy = ErrorPolicy{Action: ErrorActionRetry, Retries: -1}
+	}
+
+	source := message.KafkaSource(k.Address, topic)
+	attributes := map[string]string{
+		"topic": topic,
+	}
+
+	var reader *kafka.Reader
+	attempts, err := policy.do(
+		ctx,
+		func() error {
+			var err error
+			reader, err = k.newReader(ctx, topic, partition)
+			return err
+		},
+	)
 	if err != nil {
-		return err
+		return k.partitionFailed(ctx, policy, source, topic, partition, attempts, err)
 	}
 	defer reader.Close()
 
-	for {
-		messageObj := message{}
+	failures := 0
 
+	for {
 		msg, err := reader.ReadMessage(ctx)
 		if err != nil {
-			select {
-			case <-ctx.Done():
+			if ctx.Err() != nil {
 				return ctx.Err()
-			default:
-				log.Warnf("Failed to read message: %v", err)
 			}
 
+			failures++
+			if !policy.retry(failures) {
+				return k.partitionFailed(ctx, policy, source, topic, partition, failures, err)
+			}
+
+			log.Warnf(
+				"Failed to read message from partition %d of %s (attempt %d): %v",
+				partition,
+				topic,
+				failures,
+				err,
+			)
+			if err := sleepContext(ctx, errorBackoff(failures)); err != nil {
+				return err
+			}
 			continue
 		}
+		failures = 0
 
 		if !k.Until.IsZero() && msg.Time.After(k.Until) {
-			log.Warnf("Partition %d has reached until duration, stopping", partition)
+			log.Warnf(
+				"Partition %d of %s has reached until duration, stopping",
+				partition,
+				topic,
+			)
 			return nil
 		}
 
-		messageObj.msg = msg
-		messageChan <- messageObj
+		if !k.matchesKey(msg) {
+			log.Debug("Dropping message due to key")
+			continue
+		}
+
+		select {
+		case messageChan <- fromKafkaMessage(source, attributes, msg):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// matchesKey returns whether the argument message has the consumer's key, if one is set.
+func (k *KafkaConsumer) matchesKey(msg kafka.Message) bool {
+	return len(k.Key) == 0 || bytes.Equal(msg.Key, k.Key)
+}
+
+// partitionFailed handles a partition that couldn't be read according to the argument policy.
+// If the partition should be skipped, then the failure is recorded and nil is returned.
+func (k *KafkaConsumer) partitionFailed(
+	ctx context.Context,
+	policy ErrorPolicy,
+	source string,
+	topic string,
+	partition int,
+	attempts int,
+	err error,
+) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if !policy.skips() {
+		return fmt.Errorf("Error reading partition %d of %s: %+v", partition, topic, err)
 	}
+
+	log.Warnf(
+		"Skipping partition %d of %s after %d attempt(s): %+v",
+		partition,
+		topic,
+		attempts,
+		err,
+	)
+	k.Failures.add(source, partition, attempts, err)
+	return nil
 }
 
 func (k *KafkaConsumer) newReader(
 	ctx context.Context,
+	topic string,
 	partition int,
 ) (*kafka.Reader, error) {
-	reader := kafka.NewReader(
+	reader := newKafkaReader(
 		kafka.ReaderConfig{
 			Brokers:        []string{k.Address},
-			Topic:          k.Topic,
+			Topic:          topic,
 			Partition:      partition,
 			MinBytes:       k.MinBytes,
 			MaxBytes:       k.MaxBytes,
@@ -104,21 +224,102 @@ func (k *KafkaConsumer) newReader(
 		},
 	)
 
-	if !k.Since.IsZero() {
-		err := reader.SetOffsetAt(ctx, k.Since)
+	// Close the reader if it can't be positioned so that it isn't leaked when the partition
+	// is retried
+	if err := k.seekReader(ctx, reader, topic, partition); err != nil {
+		reader.Close()
+		return nil, err
+	}
+
+	return reader, nil
+}
+
+// seekReader sets the starting offset of the argument reader based on the consumer's
+// Offsets, Since, or Offset, in that order of precedence.
+func (k *KafkaConsumer) seekReader(
+	ctx context.Context,
+	reader *kafka.Reader,
+	topic string,
+	partition int,
+) error {
+	if partitionOffset, ok := k.Offsets.Lookup(topic, partition); ok {
+		first, last, err := k.partitionBounds(ctx, topic, partition)
 		if err != nil {
-			return nil, err
+			return err
 		}
+
+		offset := partitionOffset.Resolve(first, last)
+		log.Debugf(
+			"Starting partition %d of %s at offset %d (first=%d, last=%d)",
+			partition,
+			topic,
+			offset,
+			first,
+			last,
+		)
+
+		return reader.SetOffset(offset)
+	} else if !k.Since.IsZero() {
+		return reader.SetOffsetAt(ctx, k.Since)
+	}
+
+	var offset int64
+
+	if k.Offset == 0 {
+		offset = kafka.LastOffset
 	} else {
-		var offset int64
+		offset = k.Offset
+	}
+	reader.SetOffset(offset)
+
+	return nil
+}
+
+// partitionBounds returns the first and last offsets of the argument partition. The last
+// offset is the high watermark, i.e. the offset that the next message will be written at.
+func (k *KafkaConsumer) partitionBounds(
+	ctx context.Context,
+	topic string,
+	partition int,
+) (int64, int64, error) {
+	conn, err := kafka.DialLeader(ctx, "tcp", k.Address, topic, partition)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer conn.Close()
 
-		if k.Offset == 0 {
-			offset = kafka.LastOffset
-		} else {
-			offset = k.Offset
+	return conn.ReadOffsets()
+}
+
+func fromKafkaMessage(
+	source string,
+	attributes map[string]string,
+	msg kafka.Message,
+) message.Message {
+	var headers []message.Header
+
+	if len(msg.Headers) > 0 {
+		headers = make([]message.Header, 0, len(msg.Headers))
+		for _, header := range msg.Headers {
+			headers = append(
+				headers,
+				message.Header{
+					Key:   header.Key,
+					Value: header.Value,
+				},
+			)
 		}
-		reader.SetOffset(offset)
 	}
 
-	return reader, nil
+	return message.Message{
+		Source:     source,
+		Partition:  msg.Partition,
+		Offset:     msg.Offset,
+		Position:   -1,
+		Time:       msg.Time,
+		Key:        msg.Key,
+		Headers:    headers,
+		Value:      msg.Value,
+		Attributes: attributes,
+	}
 }
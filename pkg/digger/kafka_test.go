@@ -3,11 +3,14 @@ package digger
 import (
 	"context"
 	"fmt"
+	"io"
+	"net"
 	"os"
 	"sort"
 	"testing"
 	"time"
 
+	"github.com/segmentio/data-digger/pkg/message"
 	"github.com/segmentio/kafka-go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -42,7 +45,7 @@ func TestKafkaConsumer(t *testing.T) {
 		MaxBytes: 100,
 	}
 
-	messageChan := make(chan message, 10)
+	messageChan := make(chan message.Message, 10)
 	errChan := make(chan error, 1)
 
 	go func() {
@@ -72,13 +75,13 @@ func TestKafkaConsumer(t *testing.T) {
 	err := writer.WriteMessages(ctx, messages...)
 	require.NoError(t, err)
 
-	received := []kafka.Message{}
+	received := []message.Message{}
 
 outerLoop:
 	for {
 		select {
 		case msg := <-messageChan:
-			received = append(received, msg.msg)
+			received = append(received, msg)
 			if len(received) == 10 {
 				break outerLoop
 			}
@@ -97,6 +100,49 @@ outerLoop:
 	assert.Equal(t, "value-0", string(received[0].Value))
 }
 
+func TestKafkaConsumerMatchesKey(t *testing.T) {
+	consumer := KafkaConsumer{}
+	assert.True(t, consumer.matchesKey(kafka.Message{Key: []byte("customer-123")}))
+	assert.True(t, consumer.matchesKey(kafka.Message{}))
+
+	consumer.Key = []byte("customer-123")
+	assert.True(t, consumer.matchesKey(kafka.Message{Key: []byte("customer-123")}))
+	assert.False(t, consumer.matchesKey(kafka.Message{Key: []byte("customer-1234")}))
+	assert.False(t, consumer.matchesKey(kafka.Message{}))
+}
+
+func TestKafkaConsumerClosesReaderOnSeekError(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Get an address that nothing is listening on
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	readers := []*kafka.Reader{}
+	newKafkaReader = func(config kafka.ReaderConfig) *kafka.Reader {
+		reader := kafka.NewReader(config)
+		readers = append(readers, reader)
+		return reader
+	}
+	defer func() {
+		newKafkaReader = kafka.NewReader
+	}()
+
+	consumer := KafkaConsumer{
+		Address: address,
+		Since:   time.Now().Add(-time.Hour),
+	}
+	_, err = consumer.newReader(ctx, "test-topic", 0)
+	require.Error(t, err)
+
+	// The reader that couldn't be positioned was closed
+	require.Equal(t, 1, len(readers))
+	assert.ErrorIs(t, readers[0].SetOffsetAt(ctx, consumer.Since), io.ErrClosedPipe)
+}
+
 func createTestTopic(ctx context.Context, t *testing.T) string {
 	topicName := fmt.Sprintf("test-topic-%d", time.Now().UnixNano())
 
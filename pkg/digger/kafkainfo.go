@@ -0,0 +1,259 @@
+package digger
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/segmentio/kafka-go"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	kafkaInfoConcurrency = 8
+	kafkaInfoTimeout     = 10 * time.Second
+	kafkaInfoMaxBytes    = 10e6
+)
+
+// KafkaTopicInfo stores a summary of a Kafka topic.
+type KafkaTopicInfo struct {
+	Topic      string `json:"topic"`
+	Partitions int    `json:"partitions"`
+	Replicas   int    `json:"replicas"`
+}
+
+// KafkaPartitionInfo stores the details of a Kafka partition.
+type KafkaPartitionInfo struct {
+	Topic       string     `json:"topic"`
+	Partition   int        `json:"partition"`
+	Leader      string     `json:"leader"`
+	FirstOffset int64      `json:"firstOffset"`
+	LastOffset  int64      `json:"lastOffset"`
+	Messages    int64      `json:"messages"`
+	FirstTime   *time.Time `json:"firstTime,omitempty"`
+	LastTime    *time.Time `json:"lastTime,omitempty"`
+
+	// SinceOffset is the first offset at or after the since time, if one was requested.
+	SinceOffset *int64 `json:"sinceOffset,omitempty"`
+}
+
+// GetKafkaTopicInfo summarizes the topics that the argument partitions belong to.
+func GetKafkaTopicInfo(partitions []kafka.Partition) []KafkaTopicInfo {
+	topicsMap := map[string]*KafkaTopicInfo{}
+
+	for _, partition := range partitions {
+		topicInfo, ok := topicsMap[partition.Topic]
+		if !ok {
+			topicInfo = &KafkaTopicInfo{Topic: partition.Topic}
+			topicsMap[partition.Topic] = topicInfo
+		}
+
+		topicInfo.Partitions++
+		if len(partition.Replicas) > topicInfo.Replicas {
+			topicInfo.Replicas = len(partition.Replicas)
+		}
+	}
+
+	topicInfos := []KafkaTopicInfo{}
+	for _, topicInfo := range topicsMap {
+		topicInfos = append(topicInfos, *topicInfo)
+	}
+	sort.Slice(topicInfos, func(a, b int) bool {
+		return topicInfos[a].Topic < topicInfos[b].Topic
+	})
+
+	return topicInfos
+}
+
+// GetKafkaPartitionInfo fetches the offset ranges and first/last message timestamps for each
+// of the argument partitions. If since is set, then the offset that it maps to is also fetched.
+func GetKafkaPartitionInfo(
+	ctx context.Context,
+	address string,
+	partitions []kafka.Partition,
+	since time.Time,
+) ([]KafkaPartitionInfo, error) {
+	partitionInfos := make([]KafkaPartitionInfo, len(partitions))
+	errs := make([]error, len(partitions))
+
+	sem := make(chan struct{}, kafkaInfoConcurrency)
+	wg := sync.WaitGroup{}
+
+	for i, partition := range partitions {
+		wg.Add(1)
+		go func(i int, partition kafka.Partition) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			partitionInfos[i], errs[i] = getPartitionInfo(ctx, address, partition, since)
+		}(i, partition)
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return partitionInfos, nil
+}
+
+func getPartitionInfo(
+	ctx context.Context,
+	address string,
+	partition kafka.Partition,
+	since time.Time,
+) (KafkaPartitionInfo, error) {
+	partitionInfo := KafkaPartitionInfo{
+		Topic:     partition.Topic,
+		Partition: partition.ID,
+		Leader: fmt.Sprintf(
+			"%d (%s:%d)",
+			partition.Leader.ID,
+			partition.Leader.Host,
+			partition.Leader.Port,
+		),
+	}
+
+	conn, err := kafka.DialLeader(ctx, "tcp", address, partition.Topic, partition.ID)
+	if err != nil {
+		return partitionInfo, err
+	}
+	defer conn.Close()
+
+	partitionInfo.FirstOffset, partitionInfo.LastOffset, err = conn.ReadOffsets()
+	if err != nil {
+		return partitionInfo, err
+	}
+	partitionInfo.Messages = partitionInfo.LastOffset - partitionInfo.FirstOffset
+
+	if partitionInfo.Messages > 0 {
+		partitionInfo.FirstTime = readMessageTime(conn, partitionInfo.FirstOffset)
+		partitionInfo.LastTime = readMessageTime(conn, partitionInfo.LastOffset-1)
+	}
+
+	if !since.IsZero() {
+		sinceOffset, err := conn.ReadOffset(since)
+		if err != nil {
+			return partitionInfo, err
+		}
+		partitionInfo.SinceOffset = &sinceOffset
+	}
+
+	return partitionInfo, nil
+}
+
+// readMessageTime returns the timestamp of the message at the argument offset, or nil if it
+// can't be read (e.g., because the message was deleted by retention or compaction).
+func readMessageTime(conn *kafka.Conn, offset int64) *time.Time {
+	if _, err := conn.Seek(offset, kafka.SeekAbsolute); err != nil {
+		log.Debugf("Error seeking to offset %d: %+v", offset, err)
+		return nil
+	}
+
+	conn.SetReadDeadline(time.Now().Add(kafkaInfoTimeout))
+	msg, err := conn.ReadMessage(kafkaInfoMaxBytes)
+	if err != nil {
+		log.Debugf("Error reading message at offset %d: %+v", offset, err)
+		return nil
+	}
+
+	return &msg.Time
+}
+
+// KafkaTopicsTable returns a pretty table of the argument topic infos.
+func KafkaTopicsTable(topicInfos []KafkaTopicInfo) string {
+	buf := &bytes.Buffer{}
+	table := newInfoTable(buf, []string{"Topic", "Partitions", "Replicas"})
+
+	for _, topicInfo := range topicInfos {
+		table.Append(
+			[]string{
+				topicInfo.Topic,
+				fmt.Sprintf("%d", topicInfo.Partitions),
+				fmt.Sprintf("%d", topicInfo.Replicas),
+			},
+		)
+	}
+
+	table.Render()
+	return buf.String()
+}
+
+// KafkaPartitionsTable returns a pretty table of the argument partition infos.
+func KafkaPartitionsTable(partitionInfos []KafkaPartitionInfo, since bool) string {
+	buf := &bytes.Buffer{}
+
+	header := []string{
+		"Topic",
+		"Partition",
+		"Leader",
+		"First Offset",
+		"Last Offset",
+		"Messages",
+		"First Time",
+		"Last Time",
+	}
+	if since {
+		header = append(header, "Since Offset")
+	}
+
+	table := newInfoTable(buf, header)
+
+	for _, partitionInfo := range partitionInfos {
+		row := []string{
+			partitionInfo.Topic,
+			fmt.Sprintf("%d", partitionInfo.Partition),
+			partitionInfo.Leader,
+			fmt.Sprintf("%d", partitionInfo.FirstOffset),
+			fmt.Sprintf("%d", partitionInfo.LastOffset),
+			fmt.Sprintf("%d", partitionInfo.Messages),
+			formatInfoTime(partitionInfo.FirstTime),
+			formatInfoTime(partitionInfo.LastTime),
+		}
+		if since {
+			if partitionInfo.SinceOffset != nil {
+				row = append(row, fmt.Sprintf("%d", *partitionInfo.SinceOffset))
+			} else {
+				row = append(row, "")
+			}
+		}
+
+		table.Append(row)
+	}
+
+	table.Render()
+	return buf.String()
+}
+
+func newInfoTable(buf *bytes.Buffer, header []string) *tablewriter.Table {
+	table := tablewriter.NewWriter(buf)
+	table.SetHeader(header)
+	table.SetAutoWrapText(false)
+	table.SetAutoFormatHeaders(false)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetBorders(
+		tablewriter.Border{
+			Left:   false,
+			Top:    true,
+			Right:  false,
+			Bottom: true,
+		},
+	)
+
+	return table
+}
+
+func formatInfoTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
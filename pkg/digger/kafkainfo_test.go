@@ -0,0 +1,101 @@
+package digger
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetKafkaTopicInfo(t *testing.T) {
+	brokers := []kafka.Broker{{ID: 1}, {ID: 2}}
+
+	topicInfos := GetKafkaTopicInfo(
+		[]kafka.Partition{
+			{Topic: "topic-b", ID: 0, Replicas: brokers},
+			{Topic: "topic-a", ID: 0, Replicas: brokers[0:1]},
+			{Topic: "topic-b", ID: 1, Replicas: brokers},
+		},
+	)
+	assert.Equal(
+		t,
+		[]KafkaTopicInfo{
+			{Topic: "topic-a", Partitions: 1, Replicas: 1},
+			{Topic: "topic-b", Partitions: 2, Replicas: 2},
+		},
+		topicInfos,
+	)
+}
+
+func TestKafkaPartitionsTable(t *testing.T) {
+	firstTime := time.Date(2020, 10, 28, 20, 30, 5, 0, time.UTC)
+	lastTime := time.Date(2020, 10, 29, 1, 0, 0, 0, time.UTC)
+	sinceOffset := int64(150)
+
+	table := KafkaPartitionsTable(
+		[]KafkaPartitionInfo{
+			{
+				Topic:       "test-topic",
+				Partition:   3,
+				Leader:      "1 (localhost:9092)",
+				FirstOffset: 100,
+				LastOffset:  200,
+				Messages:    100,
+				FirstTime:   &firstTime,
+				LastTime:    &lastTime,
+				SinceOffset: &sinceOffset,
+			},
+		},
+		true,
+	)
+	assert.Contains(t, table, "Since Offset")
+	assert.Contains(t, table, "2020-10-28T20:30:05Z")
+	assert.Contains(t, table, "150")
+}
+
+func TestGetKafkaPartitionInfo(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	topicName := createTestTopic(ctx, t)
+
+	writer := kafka.NewWriter(
+		kafka.WriterConfig{
+			Brokers:   []string{testKafkaAddr},
+			Topic:     topicName,
+			BatchSize: 1,
+		},
+	)
+	defer writer.Close()
+
+	for i := 0; i < 5; i++ {
+		err := writer.WriteMessages(
+			ctx,
+			kafka.Message{
+				Value: []byte(fmt.Sprintf("value-%d", i)),
+			},
+		)
+		require.NoError(t, err)
+	}
+
+	partitionInfos, err := GetKafkaPartitionInfo(
+		ctx,
+		testKafkaAddr,
+		[]kafka.Partition{{Topic: topicName, ID: 0}},
+		time.Now().Add(-time.Hour),
+	)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(partitionInfos))
+
+	assert.Equal(t, int64(0), partitionInfos[0].FirstOffset)
+	assert.Equal(t, int64(5), partitionInfos[0].LastOffset)
+	assert.Equal(t, int64(5), partitionInfos[0].Messages)
+	assert.NotNil(t, partitionInfos[0].FirstTime)
+	assert.NotNil(t, partitionInfos[0].LastTime)
+	require.NotNil(t, partitionInfos[0].SinceOffset)
+	assert.Equal(t, int64(0), *partitionInfos[0].SinceOffset)
+}
@@ -0,0 +1,48 @@
+package digger
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	// PartitionerMurmur2 is the default partitioner of the Java Kafka client.
+	PartitionerMurmur2 = "murmur2"
+
+	// PartitionerCRC32 is the default partitioner of librdkafka.
+	PartitionerCRC32 = "crc32"
+
+	// PartitionerFNV is the FNV-1a hash partitioner used by sarama and kafka-go's Hash
+	// balancer.
+	PartitionerFNV = "fnv"
+)
+
+// KeyPartition returns the ID of the partition that the argument key is assigned to by the
+// named partitioner, given the IDs of all of the partitions in the topic.
+func KeyPartition(key []byte, partitioner string, partitionIDs []int) (int, error) {
+	if len(partitionIDs) == 0 {
+		return 0, fmt.Errorf("Cannot compute partition for topic without partitions")
+	}
+
+	var balancer kafka.Balancer
+
+	switch partitioner {
+	case PartitionerMurmur2, "":
+		balancer = kafka.Murmur2Balancer{Consistent: true}
+	case PartitionerCRC32:
+		balancer = kafka.CRC32Balancer{Consistent: true}
+	case PartitionerFNV:
+		balancer = &kafka.Hash{}
+	default:
+		return 0, fmt.Errorf("Unrecognized partitioner: %s", partitioner)
+	}
+
+	// Partitioners assume that the partitions are in order
+	sortedIDs := make([]int, len(partitionIDs))
+	copy(sortedIDs, partitionIDs)
+	sort.Ints(sortedIDs)
+
+	return balancer.Balance(kafka.Message{Key: key}, sortedIDs...), nil
+}
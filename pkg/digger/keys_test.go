@@ -0,0 +1,38 @@
+package digger
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyPartition(t *testing.T) {
+	type testCase struct {
+		key         string
+		partitioner string
+		expected    int
+	}
+
+	// Expected values match the partitions chosen by the corresponding producers for a
+	// topic with 10 partitions.
+	testCases := []testCase{
+		{key: "customer-123", partitioner: PartitionerMurmur2, expected: 8},
+		{key: "customer-123", partitioner: PartitionerCRC32, expected: 3},
+		{key: "customer-123", partitioner: PartitionerFNV, expected: 8},
+		{key: "customer-456", partitioner: PartitionerCRC32, expected: 6},
+	}
+
+	partitionIDs := []int{9, 8, 7, 6, 5, 4, 3, 2, 1, 0}
+
+	for _, testCase := range testCases {
+		partition, err := KeyPartition([]byte(testCase.key), testCase.partitioner, partitionIDs)
+		require.NoError(t, err)
+		assert.Equal(t, testCase.expected, partition, testCase.partitioner)
+	}
+
+	_, err := KeyPartition([]byte("key"), "md5", partitionIDs)
+	assert.Error(t, err)
+	_, err = KeyPartition([]byte("key"), PartitionerMurmur2, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,127 @@
+package digger
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Reasons that a run can stop for, other than reaching one of its limits
+const (
+	StopReasonDone        = "all messages were read"
+	StopReasonInterrupted = "interrupted"
+)
+
+// Limits are optional limits on a run. When one of them is reached, the run's context is
+// cancelled so that all of the consumers stop, and the run returns without an error. Zero values
+// mean no limit.
+type Limits struct {
+	// MaxMessages is the maximum number of messages to process. Messages that are skipped
+	// by the consumers (e.g., because they were already processed before a checkpoint) don't
+	// count towards it.
+	MaxMessages int64
+
+	// MaxMatches is the maximum number of messages that pass the filters of any of the
+	// processors that implement MatchCounter. With multiple processing workers, the messages
+	// that were already in flight when the limit is reached are still processed, so a few more
+	// matches may be counted.
+	MaxMatches int64
+
+	// MaxDuration is the maximum amount of time to run for.
+	MaxDuration time.Duration
+}
+
+// MatchCounter is a Processor that counts the messages that pass its filters, for
+// Limits.MaxMatches. It must be safe to call Matches while messages are being processed.
+type MatchCounter interface {
+	Processor
+
+	// Matches returns the number of messages that have passed the filters so far in the current
+	// run.
+	Matches() int64
+}
+
+// limitReached is the cause of a run's context being cancelled due to one of its limits.
+type limitReached struct {
+	reason string
+}
+
+func (l limitReached) Error() string {
+	return l.reason
+}
+
+// limiter enforces the limits of a single run.
+type limiter struct {
+	limits        Limits
+	matchCounters []MatchCounter
+	cancel        context.CancelCauseFunc
+	messages      atomic.Int64
+}
+
+func newLimiter(limits Limits, processors []Processor, cancel context.CancelCauseFunc) *limiter {
+	l := &limiter{
+		limits: limits,
+		cancel: cancel,
+	}
+
+	if limits.MaxMatches > 0 {
+		for _, p := range processors {
+			if matchCounter, ok := p.(MatchCounter); ok {
+				l.matchCounters = append(l.matchCounters, matchCounter)
+			}
+		}
+	}
+
+	return l
+}
+
+// start starts the timer for the max duration, if there is one. The returned function stops it.
+func (l *limiter) start() func() bool {
+	if l.limits.MaxDuration <= 0 {
+		return func() bool { return false }
+	}
+
+	timer := time.AfterFunc(
+		l.limits.MaxDuration,
+		func() {
+			l.cancel(
+				limitReached{
+					reason: fmt.Sprintf("reached max duration of %s", l.limits.MaxDuration),
+				},
+			)
+		},
+	)
+	return timer.Stop
+}
+
+// admit returns whether another message can be processed without going over the max number of
+// messages. Messages that are admitted must be passed to check after they're processed.
+func (l *limiter) admit() bool {
+	if l.limits.MaxMessages <= 0 {
+		return true
+	}
+	return l.messages.Add(1) <= l.limits.MaxMessages
+}
+
+// check cancels the run if a limit has been reached after processing a message.
+func (l *limiter) check() {
+	if l.limits.MaxMessages > 0 && l.messages.Load() >= l.limits.MaxMessages {
+		l.cancel(
+			limitReached{
+				reason: fmt.Sprintf("reached max messages of %d", l.limits.MaxMessages),
+			},
+		)
+	}
+
+	for _, matchCounter := range l.matchCounters {
+		if matchCounter.Matches() >= l.limits.MaxMatches {
+			l.cancel(
+				limitReached{
+					reason: fmt.Sprintf("reached max matches of %d", l.limits.MaxMatches),
+				},
+			)
+			return
+		}
+	}
+}
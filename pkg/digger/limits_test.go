@@ -0,0 +1,152 @@
+package digger
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/segmentio/data-digger/pkg/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// endlessConsumer is a Consumer that generates messages until its context is cancelled, like a
+// Kafka consumer without an end time.
+type endlessConsumer struct{}
+
+func (e endlessConsumer) Run(ctx context.Context, messageChan chan message.Message) error {
+	for offset := int64(0); ; offset++ {
+		msg := message.Message{
+			Source: "endless",
+			Offset: offset,
+			Value:  []byte(fmt.Sprintf(`{"n": %d}`, offset)),
+		}
+
+		select {
+		case messageChan <- msg:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func TestDiggerLimits(t *testing.T) {
+	type testCase struct {
+		description string
+		limits      Limits
+		numWorkers  int
+		expected    string
+
+		// expectedMessages is the exact number of messages that should be processed, if it's
+		// deterministic
+		expectedMessages int64
+
+		// expectedMatches is the minimum number of messages that should pass the filter
+		expectedMatches int64
+	}
+
+	testCases := []testCase{
+		{
+			description:      "max messages",
+			limits:           Limits{MaxMessages: 25},
+			numWorkers:       1,
+			expected:         "reached max messages of 25",
+			expectedMessages: 25,
+		},
+		{
+			description:      "max messages in parallel",
+			limits:           Limits{MaxMessages: 1000},
+			numWorkers:       4,
+			expected:         "reached max messages of 1000",
+			expectedMessages: 1000,
+		},
+		{
+			description:      "max matches",
+			limits:           Limits{MaxMatches: 5},
+			numWorkers:       1,
+			expected:         "reached max matches of 5",
+			expectedMessages: 41,
+			expectedMatches:  5,
+		},
+		{
+			description:     "max matches in parallel",
+			limits:          Limits{MaxMatches: 5},
+			numWorkers:      4,
+			expected:        "reached max matches of 5",
+			expectedMatches: 5,
+		},
+		{
+			description: "max duration",
+			limits:      Limits{MaxDuration: 100 * time.Millisecond},
+			numWorkers:  1,
+			expected:    "reached max duration of 100ms",
+		},
+		{
+			description: "max duration in parallel",
+			limits:      Limits{MaxDuration: 100 * time.Millisecond},
+			numWorkers:  4,
+			expected:    "reached max duration of 100ms",
+		},
+	}
+
+	for _, testCase := range testCases {
+		liveStats, err := NewLiveStats(
+			LiveStatsConfig{
+				Filter:     `0}$`,
+				K:          10,
+				NumWorkers: testCase.numWorkers,
+			},
+		)
+		require.NoError(t, err, testCase.description)
+
+		digger := &Digger{
+			SourceConsumer: endlessConsumer{},
+			Processors:     []Processor{liveStats},
+			NumWorkers:     testCase.numWorkers,
+			Limits:         testCase.limits,
+		}
+		require.NoError(t, digger.Run(context.Background()), testCase.description)
+		require.NoError(t, liveStats.Stop())
+
+		assert.Equal(t, testCase.expected, digger.StopReason(), testCase.description)
+
+		summary := liveStats.messageCounter().Summary()
+		if testCase.expectedMessages > 0 {
+			assert.Equal(
+				t,
+				testCase.expectedMessages,
+				summary.TotalMessages,
+				testCase.description,
+			)
+		}
+		assert.GreaterOrEqual(
+			t,
+			summary.PostFilterMessages,
+			testCase.expectedMatches,
+			testCase.description,
+		)
+		assert.Equal(t, summary.PostFilterMessages, liveStats.Matches(), testCase.description)
+	}
+}
+
+func TestDiggerStopReason(t *testing.T) {
+	liveStats, err := NewLiveStats(LiveStatsConfig{K: 10})
+	require.NoError(t, err)
+	defer liveStats.Stop()
+
+	digger := &Digger{
+		SourceConsumer: &FileConsumer{Paths: []string{"testdata/sample/archives_00.gz"}},
+		Processors:     []Processor{liveStats},
+		Limits:         Limits{MaxMessages: 1000},
+	}
+	require.NoError(t, digger.Run(context.Background()))
+	assert.Equal(t, StopReasonDone, digger.StopReason())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	digger.SourceConsumer = endlessConsumer{}
+	assert.Error(t, digger.Run(ctx))
+	assert.Equal(t, StopReasonInterrupted, digger.StopReason())
+}
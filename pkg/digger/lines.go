@@ -0,0 +1,87 @@
+package digger
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// lineReader splits a stream into newline-delimited messages. Unlike bufio.Scanner, it doesn't
+// fail when a line is longer than the max message size; instead, the line is truncated to the
+// max size and flagged as oversized so that the caller can decide what to do with it.
+type lineReader struct {
+	reader  *bufio.Reader
+	maxSize int
+	buf     []byte
+
+	position int64
+	start    int64
+}
+
+func newLineReader(reader io.Reader, maxSize int) *lineReader {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxMessageSize
+	}
+
+	return &lineReader{
+		reader:  bufio.NewReader(reader),
+		maxSize: maxSize,
+		buf:     make([]byte, 0, 4096),
+	}
+}
+
+// next returns the contents of the next line (without the trailing newline) and whether the
+// line exceeded the max size. The returned bytes are only valid until the next call. At the end
+// of the stream, io.EOF is returned.
+func (l *lineReader) next() ([]byte, bool, error) {
+	l.buf = l.buf[:0]
+	l.start = l.position
+	oversized := false
+	readAny := false
+
+	for {
+		chunk, err := l.reader.ReadSlice('\n')
+		if len(chunk) > 0 {
+			readAny = true
+		}
+		l.position += int64(len(chunk))
+
+		chunk = bytes.TrimSuffix(chunk, []byte{'\n'})
+
+		if len(l.buf) == 0 && !oversized && len(chunk) <= l.maxSize &&
+			(err == nil || (err == io.EOF && readAny)) {
+			// The whole line is in the reader's buffer, so return it without copying it
+			return bytes.TrimSuffix(chunk, []byte{'\r'}), false, nil
+		}
+
+		remaining := l.maxSize - len(l.buf)
+
+		if len(chunk) > remaining {
+			l.buf = append(l.buf, chunk[0:remaining]...)
+			oversized = true
+		} else {
+			l.buf = append(l.buf, chunk...)
+		}
+
+		if err == bufio.ErrBufferFull {
+			// Line is longer than the reader buffer, keep going
+			continue
+		} else if err == io.EOF {
+			if !readAny {
+				return nil, false, io.EOF
+			}
+			break
+		} else if err != nil {
+			return nil, false, err
+		}
+
+		break
+	}
+
+	// Drop trailing carriage returns, like bufio.ScanLines does
+	return bytes.TrimSuffix(l.buf, []byte{'\r'}), oversized, nil
+}
+
+func (l *lineReader) lastPosition() int64 {
+	return l.start
+}
@@ -0,0 +1,56 @@
+package digger
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLineReader(t *testing.T) {
+	type lineResult struct {
+		contents  string
+		oversized bool
+	}
+
+	input := strings.Join(
+		[]string{
+			"short",
+			"",
+			"exactly10!",
+			"this line is too long",
+			"windows\r",
+			"no newline at end",
+		},
+		"\n",
+	)
+
+	// Use a tiny buffer so that long lines are read in multiple chunks
+	lines := newLineReader(bufio.NewReaderSize(strings.NewReader(input), 16), 10)
+
+	results := []lineResult{}
+	for {
+		contents, oversized, err := lines.next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		results = append(results, lineResult{string(contents), oversized})
+	}
+
+	assert.Equal(
+		t,
+		[]lineResult{
+			{"short", false},
+			{"", false},
+			{"exactly10!", false},
+			{"this line ", true},
+			{"windows", false},
+			{"no newline", true},
+		},
+		results,
+	)
+}
@@ -0,0 +1,65 @@
+package digger
+
+import (
+	"strings"
+	"time"
+
+	"github.com/segmentio/data-digger/pkg/message"
+	sjson "github.com/segmentio/encoding/json"
+)
+
+// messageMeta is the document that virtual @meta paths are evaluated against.
+type messageMeta struct {
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Bucket     string            `json:"bucket,omitempty"`
+	File       string            `json:"file,omitempty"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Key        string            `json:"key"`
+	ModTime    string            `json:"modTime,omitempty"`
+	Offset     int64             `json:"offset"`
+	Partition  int               `json:"partition"`
+	Position   *int64            `json:"position,omitempty"`
+	Size       int               `json:"size"`
+	Source     string            `json:"source"`
+	Time       string            `json:"time,omitempty"`
+	Topic      string            `json:"topic,omitempty"`
+}
+
+// metaJSON returns the metadata of the argument message as a JSON object. The file field is set
+// to the local path for file sources and the object key for S3 sources.
+func metaJSON(msg message.Message) ([]byte, error) {
+	meta := messageMeta{
+		Attributes: msg.Attributes,
+		Bucket:     msg.Attributes["bucket"],
+		Key:        string(msg.Key),
+		Offset:     msg.Offset,
+		Partition:  msg.Partition,
+		Size:       len(msg.Value),
+		Source:     msg.Source,
+		Topic:      msg.Attributes["topic"],
+	}
+
+	if strings.HasPrefix(msg.Source, "file://") {
+		meta.File = strings.TrimPrefix(msg.Source, "file://")
+	} else {
+		meta.File = msg.Attributes["key"]
+	}
+
+	if len(msg.Headers) > 0 {
+		meta.Headers = map[string]string{}
+		for _, header := range msg.Headers {
+			meta.Headers[header.Key] = string(header.Value)
+		}
+	}
+	if msg.Position >= 0 {
+		meta.Position = &msg.Position
+	}
+	if timestamp := msg.Timestamp(); !timestamp.IsZero() {
+		meta.Time = timestamp.UTC().Format(time.RFC3339)
+	}
+	if !msg.ModTime.IsZero() {
+		meta.ModTime = msg.ModTime.UTC().Format(time.RFC3339)
+	}
+
+	return sjson.Marshal(meta)
+}
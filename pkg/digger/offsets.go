@@ -0,0 +1,203 @@
+package digger
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// AllPartitions is the partition in an OffsetTarget for the offset that applies to all
+	// partitions that aren't listed explicitly.
+	AllPartitions = -1
+
+	// AllTopics is the topic in an OffsetTarget for the offsets that apply to every topic.
+	AllTopics = ""
+)
+
+// OffsetKind describes how a PartitionOffset is resolved.
+type OffsetKind int
+
+const (
+	// OffsetAbsolute starts reading at a specific offset.
+	OffsetAbsolute OffsetKind = iota
+
+	// OffsetFirst starts reading at the first available offset in the partition.
+	OffsetFirst
+
+	// OffsetLast starts reading at the end of the partition, i.e. only new messages are read.
+	OffsetLast
+
+	// OffsetRelative starts reading the argument number of messages before the end of the
+	// partition.
+	OffsetRelative
+)
+
+// PartitionOffset is a starting position in a Kafka partition.
+type PartitionOffset struct {
+	Kind  OffsetKind
+	Value int64
+}
+
+// OffsetTarget identifies the partitions that a PartitionOffset applies to. The topic can be
+// AllTopics and the partition can be AllPartitions.
+type OffsetTarget struct {
+	Topic     string
+	Partition int
+}
+
+// PartitionOffsets maps topics and partitions to their starting positions.
+type PartitionOffsets map[OffsetTarget]PartitionOffset
+
+// ParseOffsets parses a comma-separated list of partition:offset pairs or
+// topic:partition:offset triples, e.g. 3:55012,4:first,*:-1000 or events-us-1:3:55012. Each
+// offset can be an absolute offset, first, last, or a negative count relative to the end of the
+// partition. The partition can be * to apply the offset to all partitions that aren't listed
+// explicitly, and the topic can be omitted or * to apply it to all topics.
+func ParseOffsets(offsetsStr string) (PartitionOffsets, error) {
+	offsets := PartitionOffsets{}
+
+	if offsetsStr == "" {
+		return offsets, nil
+	}
+
+	for _, targetStr := range strings.Split(offsetsStr, ",") {
+		components := strings.Split(strings.TrimSpace(targetStr), ":")
+		if len(components) == 2 {
+			components = append([]string{"*"}, components...)
+		}
+		if len(components) != 3 {
+			return nil, fmt.Errorf(
+				"Offset must be in partition:offset or topic:partition:offset format: %s",
+				targetStr,
+			)
+		}
+
+		target := OffsetTarget{Topic: components[0], Partition: AllPartitions}
+
+		if target.Topic == "*" {
+			target.Topic = AllTopics
+		} else if target.Topic == "" {
+			return nil, fmt.Errorf("Invalid topic: %s", targetStr)
+		}
+
+		if components[1] != "*" {
+			var err error
+			target.Partition, err = strconv.Atoi(components[1])
+			if err != nil || target.Partition < 0 {
+				return nil, fmt.Errorf("Invalid partition: %s", components[1])
+			}
+		}
+
+		if _, ok := offsets[target]; ok {
+			return nil, fmt.Errorf("Partition %s is listed more than once", targetStr)
+		}
+
+		offset, err := parsePartitionOffset(components[2])
+		if err != nil {
+			return nil, err
+		}
+		offsets[target] = offset
+	}
+
+	return offsets, nil
+}
+
+func parsePartitionOffset(offsetStr string) (PartitionOffset, error) {
+	switch offsetStr {
+	case "first":
+		return PartitionOffset{Kind: OffsetFirst}, nil
+	case "last":
+		return PartitionOffset{Kind: OffsetLast}, nil
+	}
+
+	value, err := strconv.ParseInt(offsetStr, 10, 64)
+	if err != nil {
+		return PartitionOffset{}, fmt.Errorf("Invalid offset: %s", offsetStr)
+	}
+
+	if value < 0 {
+		return PartitionOffset{Kind: OffsetRelative, Value: -value}, nil
+	}
+	return PartitionOffset{Kind: OffsetAbsolute, Value: value}, nil
+}
+
+// Lookup returns the offset for the argument topic and partition. Offsets for the specific
+// partition take precedence over the AllPartitions ones, and offsets for the specific topic take
+// precedence over the AllTopics ones.
+func (p PartitionOffsets) Lookup(topic string, partition int) (PartitionOffset, bool) {
+	targets := []OffsetTarget{
+		{Topic: topic, Partition: partition},
+		{Topic: AllTopics, Partition: partition},
+		{Topic: topic, Partition: AllPartitions},
+		{Topic: AllTopics, Partition: AllPartitions},
+	}
+
+	for _, target := range targets {
+		if offset, ok := p[target]; ok {
+			return offset, true
+		}
+	}
+	return PartitionOffset{}, false
+}
+
+// CheckTopics returns an error if the offsets don't apply unambiguously to the argument topics,
+// i.e. if specific partitions are listed without topics while more than one topic is being read,
+// or if offsets are listed for topics that aren't being read.
+func (p PartitionOffsets) CheckTopics(topics []string) error {
+	topicSet := map[string]bool{}
+	for _, topic := range topics {
+		topicSet[topic] = true
+	}
+
+	targets := make([]OffsetTarget, 0, len(p))
+	for target := range p {
+		targets = append(targets, target)
+	}
+	sort.Slice(targets, func(a, b int) bool {
+		return targets[a].Topic < targets[b].Topic ||
+			(targets[a].Topic == targets[b].Topic && targets[a].Partition < targets[b].Partition)
+	})
+
+	for _, target := range targets {
+		if target.Topic == AllTopics {
+			if target.Partition != AllPartitions && len(topicSet) > 1 {
+				return fmt.Errorf(
+					"Offset for partition %d is ambiguous with %d topics; "+
+						"use topic:partition:offset instead",
+					target.Partition,
+					len(topicSet),
+				)
+			}
+		} else if !topicSet[target.Topic] {
+			return fmt.Errorf("Offsets are set for topic %s, which isn't being read", target.Topic)
+		}
+	}
+
+	return nil
+}
+
+// Resolve converts the offset to an absolute one, given the first and last offsets of the
+// partition. The result is clamped to the range of offsets that are available.
+func (o PartitionOffset) Resolve(first int64, last int64) int64 {
+	var offset int64
+
+	switch o.Kind {
+	case OffsetFirst:
+		offset = first
+	case OffsetLast:
+		offset = last
+	case OffsetRelative:
+		offset = last - o.Value
+	default:
+		offset = o.Value
+	}
+
+	if offset < first {
+		return first
+	} else if offset > last {
+		return last
+	}
+	return offset
+}
@@ -0,0 +1,170 @@
+package digger
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOffsets(t *testing.T) {
+	type testCase struct {
+		offsetsStr string
+		expected   PartitionOffsets
+		expectErr  bool
+	}
+
+	testCases := []testCase{
+		{
+			offsetsStr: "",
+			expected:   PartitionOffsets{},
+		},
+		{
+			offsetsStr: "3:55012,4:first,5:last,*:-1000",
+			expected: PartitionOffsets{
+				{Topic: AllTopics, Partition: 3}:             {Kind: OffsetAbsolute, Value: 55012},
+				{Topic: AllTopics, Partition: 4}:             {Kind: OffsetFirst},
+				{Topic: AllTopics, Partition: 5}:             {Kind: OffsetLast},
+				{Topic: AllTopics, Partition: AllPartitions}: {Kind: OffsetRelative, Value: 1000},
+			},
+		},
+		{
+			offsetsStr: "events-us-1:3:55012,events-us-2:*:first,*:4:last",
+			expected: PartitionOffsets{
+				{Topic: "events-us-1", Partition: 3}:             {Kind: OffsetAbsolute, Value: 55012},
+				{Topic: "events-us-2", Partition: AllPartitions}: {Kind: OffsetFirst},
+				{Topic: AllTopics, Partition: 4}:                 {Kind: OffsetLast},
+			},
+		},
+		{
+			offsetsStr: "3",
+			expectErr:  true,
+		},
+		{
+			offsetsStr: "x:first",
+			expectErr:  true,
+		},
+		{
+			offsetsStr: "3:latest",
+			expectErr:  true,
+		},
+		{
+			offsetsStr: "3:first,3:last",
+			expectErr:  true,
+		},
+		{
+			offsetsStr: "3:first,*:3:last",
+			expectErr:  true,
+		},
+		{
+			offsetsStr: ":3:first",
+			expectErr:  true,
+		},
+		{
+			offsetsStr: "a:b:3:first",
+			expectErr:  true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		offsets, err := ParseOffsets(testCase.offsetsStr)
+		if testCase.expectErr {
+			assert.Error(t, err, testCase.offsetsStr)
+		} else {
+			require.NoError(t, err, testCase.offsetsStr)
+			assert.Equal(t, testCase.expected, offsets, testCase.offsetsStr)
+		}
+	}
+}
+
+func TestPartitionOffsetsLookup(t *testing.T) {
+	offsets, err := ParseOffsets("3:55012,*:-1000,topic2:*:first,topic2:4:last")
+	require.NoError(t, err)
+
+	offset, ok := offsets.Lookup("topic1", 3)
+	assert.True(t, ok)
+	assert.Equal(t, PartitionOffset{Kind: OffsetAbsolute, Value: 55012}, offset)
+
+	offset, ok = offsets.Lookup("topic1", 4)
+	assert.True(t, ok)
+	assert.Equal(t, PartitionOffset{Kind: OffsetRelative, Value: 1000}, offset)
+
+	// Offsets for a specific partition take precedence over the ones for a specific topic
+	offset, ok = offsets.Lookup("topic2", 3)
+	assert.True(t, ok)
+	assert.Equal(t, PartitionOffset{Kind: OffsetAbsolute, Value: 55012}, offset)
+
+	offset, ok = offsets.Lookup("topic2", 4)
+	assert.True(t, ok)
+	assert.Equal(t, PartitionOffset{Kind: OffsetLast}, offset)
+
+	offset, ok = offsets.Lookup("topic2", 5)
+	assert.True(t, ok)
+	assert.Equal(t, PartitionOffset{Kind: OffsetFirst}, offset)
+
+	_, ok = PartitionOffsets{{Partition: 3}: {Kind: OffsetFirst}}.Lookup("topic1", 4)
+	assert.False(t, ok)
+}
+
+func TestPartitionOffsetsCheckTopics(t *testing.T) {
+	type testCase struct {
+		offsetsStr string
+		topics     []string
+		expectErr  bool
+	}
+
+	testCases := []testCase{
+		{
+			offsetsStr: "3:55012,*:-1000",
+			topics:     []string{"topic1", "topic1"},
+		},
+		{
+			offsetsStr: "*:-1000,topic1:3:55012",
+			topics:     []string{"topic1", "topic2"},
+		},
+		{
+			// Partition 3 would apply to every topic
+			offsetsStr: "3:55012",
+			topics:     []string{"topic1", "topic2"},
+			expectErr:  true,
+		},
+		{
+			offsetsStr: "topic3:3:55012",
+			topics:     []string{"topic1", "topic2"},
+			expectErr:  true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		offsets, err := ParseOffsets(testCase.offsetsStr)
+		require.NoError(t, err)
+
+		err = offsets.CheckTopics(testCase.topics)
+		if testCase.expectErr {
+			assert.Error(t, err, testCase.offsetsStr)
+		} else {
+			assert.NoError(t, err, testCase.offsetsStr)
+		}
+	}
+}
+
+func TestPartitionOffsetResolve(t *testing.T) {
+	type testCase struct {
+		offset   PartitionOffset
+		expected int64
+	}
+
+	testCases := []testCase{
+		{offset: PartitionOffset{Kind: OffsetFirst}, expected: 100},
+		{offset: PartitionOffset{Kind: OffsetLast}, expected: 5000},
+		{offset: PartitionOffset{Kind: OffsetRelative, Value: 1000}, expected: 4000},
+		{offset: PartitionOffset{Kind: OffsetRelative, Value: 10000}, expected: 100},
+		{offset: PartitionOffset{Kind: OffsetAbsolute, Value: 2500}, expected: 2500},
+		{offset: PartitionOffset{Kind: OffsetAbsolute, Value: 10}, expected: 100},
+		{offset: PartitionOffset{Kind: OffsetAbsolute, Value: 9000}, expected: 5000},
+	}
+
+	for _, testCase := range testCases {
+		assert.Equal(t, testCase.expected, testCase.offset.Resolve(100, 5000), "%+v", testCase.offset)
+	}
+}
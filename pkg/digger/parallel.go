@@ -0,0 +1,226 @@
+package digger
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/segmentio/data-digger/pkg/message"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Number of messages per processing worker that can be buffered or in flight at once
+	parallelBufferSize = 256
+)
+
+// ParallelProcessor is a Processor that can be called by multiple processing workers at once.
+// Implementations typically keep separate state for each worker and merge it when summarizing.
+type ParallelProcessor interface {
+	Processor
+
+	// ProcessWorker processes a message on behalf of the argument worker, which is in the range
+	// [0, number of workers). Any output for the message should be written to the argument writer
+	// instead of stdout so that the digger can print it in the original message order.
+	ProcessWorker(ctx context.Context, worker int, msg message.Message, output io.Writer) error
+}
+
+// parallelJob is a message that's passed to a processing worker, along with its position in the
+// message stream, its index in its source for the checkpoint, and a buffer for its output.
+type parallelJob struct {
+	seq    int64
+	index  int64
+	msg    message.Message
+	output *bytes.Buffer
+}
+
+var outputBufferPool = sync.Pool{
+	New: func() interface{} {
+		return &bytes.Buffer{}
+	},
+}
+
+// runParallel processes the messages from the consumer with NumWorkers processing workers. The
+// outputs of the messages are written in the order that they were consumed. Checkpoints are only
+// saved once all of the in-flight messages have been processed, so that the processor states
+// include exactly the records before each source's low-water mark.
+func (d *Digger) runParallel(
+	ctx context.Context,
+	limiter *limiter,
+	messageChan <-chan message.Message,
+	errChan <-chan error,
+	checkpointChan <-chan time.Time,
+) error {
+	maxInFlight := d.NumWorkers * parallelBufferSize
+
+	jobChan := make(chan parallelJob, maxInFlight)
+	resultChan := make(chan parallelJob, maxInFlight)
+
+	// Limits the number of messages that are being processed or waiting for earlier messages to
+	// be output
+	inFlight := make(chan struct{}, maxInFlight)
+
+	// Processors that don't support parallel processing are called one message at a time
+	serialLock := sync.Mutex{}
+
+	workersWG := sync.WaitGroup{}
+
+	for w := 0; w < d.NumWorkers; w++ {
+		workersWG.Add(1)
+
+		go func(worker int) {
+			defer workersWG.Done()
+
+			for job := range jobChan {
+				d.processJob(ctx, worker, job, &serialLock)
+				d.Checkpoint.updateAt(job.msg, job.index)
+
+				job.msg.Release()
+				limiter.check()
+
+				resultChan <- job
+			}
+		}(w)
+	}
+
+	outputDone := make(chan struct{})
+
+	go func() {
+		d.writeOutputs(resultChan, inFlight, maxInFlight)
+		close(outputDone)
+	}()
+
+	var seq int64
+
+	dispatch := func(msg message.Message) {
+		// If the context is done, don't process subsequent messages
+		if ctx.Err() != nil || !limiter.admit() {
+			msg.Release()
+			return
+		}
+
+		select {
+		case inFlight <- struct{}{}:
+		case <-ctx.Done():
+			msg.Release()
+			return
+		}
+
+		jobChan <- parallelJob{
+			seq:    seq,
+			index:  d.Checkpoint.dispatch(msg),
+			msg:    msg,
+			output: outputBufferPool.Get().(*bytes.Buffer),
+		}
+		seq++
+	}
+
+	for {
+		select {
+		case err := <-errChan:
+			// The consumer is done, but some of its messages may still be buffered
+		drainLoop:
+			for {
+				select {
+				case msg := <-messageChan:
+					dispatch(msg)
+				default:
+					break drainLoop
+				}
+			}
+
+			close(jobChan)
+			workersWG.Wait()
+			close(resultChan)
+			<-outputDone
+
+			d.saveCheckpoint()
+			return err
+		case <-checkpointChan:
+			// Taking all of the in-flight slots waits for the workers to finish the messages
+			// that have been dispatched, and keeps new ones from being dispatched until the
+			// checkpoint is saved
+			for i := 0; i < maxInFlight; i++ {
+				inFlight <- struct{}{}
+			}
+			d.saveCheckpoint()
+			for i := 0; i < maxInFlight; i++ {
+				<-inFlight
+			}
+		case msg := <-messageChan:
+			dispatch(msg)
+		}
+	}
+}
+
+// processJob passes the message in the argument job through all of the processors.
+func (d *Digger) processJob(
+	ctx context.Context,
+	worker int,
+	job parallelJob,
+	serialLock *sync.Mutex,
+) {
+	for _, p := range d.Processors {
+		var err error
+
+		if parallelProcessor, ok := p.(ParallelProcessor); ok {
+			err = parallelProcessor.ProcessWorker(ctx, worker, job.msg, job.output)
+		} else {
+			serialLock.Lock()
+			err = p.Process(ctx, job.msg)
+			serialLock.Unlock()
+		}
+
+		if err != nil {
+			if errors.Is(err, ErrSkipMessage) {
+				break
+			}
+			log.Warnf("Failed to process message: %v", err)
+		}
+	}
+}
+
+// writeOutputs writes the outputs of the processed jobs in their original order. Jobs that
+// finish early wait in a ring buffer until the ones before them are done; the in-flight limit
+// ensures that they never overlap in it.
+func (d *Digger) writeOutputs(
+	resultChan <-chan parallelJob,
+	inFlight <-chan struct{},
+	maxInFlight int,
+) {
+	output := d.output
+	if output == nil {
+		output = os.Stdout
+	}
+
+	pending := make([]*bytes.Buffer, maxInFlight)
+	var next int64
+
+	for job := range resultChan {
+		pending[job.seq%int64(maxInFlight)] = job.output
+
+		for {
+			index := next % int64(maxInFlight)
+			buf := pending[index]
+			if buf == nil {
+				break
+			}
+
+			if buf.Len() > 0 {
+				if _, err := output.Write(buf.Bytes()); err != nil {
+					log.Warnf("Failed to write output: %v", err)
+				}
+			}
+
+			buf.Reset()
+			outputBufferPool.Put(buf)
+			pending[index] = nil
+			next++
+			<-inFlight
+		}
+	}
+}
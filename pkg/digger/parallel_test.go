@@ -0,0 +1,76 @@
+package digger
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/segmentio/data-digger/pkg/message"
+	"github.com/segmentio/data-digger/pkg/stats"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiggerParallel(t *testing.T) {
+	ctx := context.Background()
+	tempDir := t.TempDir()
+
+	path := filepath.Join(tempDir, "file.txt")
+	checkpointPath := filepath.Join(tempDir, "checkpoint.json")
+
+	lines := []string{}
+	for i := 0; i < 5000; i++ {
+		lines = append(lines, fmt.Sprintf(`{"id":"id%d","seq":%d}`, i%3, i))
+	}
+	require.NoError(t, os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0644))
+
+	checkpoint, err := LoadCheckpoint(checkpointPath)
+	require.NoError(t, err)
+
+	liveStats, err := NewLiveStats(
+		LiveStatsConfig{
+			K:          10,
+			PathsStr:   "id",
+			Raw:        true,
+			NumWorkers: 4,
+		},
+	)
+	require.NoError(t, err)
+	defer liveStats.Stop()
+
+	output := &bytes.Buffer{}
+	digger := &Digger{
+		SourceConsumer: &FileConsumer{
+			Paths:      []string{path},
+			Checkpoint: checkpoint,
+		},
+		Processors: []Processor{liveStats},
+		Checkpoint: checkpoint,
+		NumWorkers: 4,
+		output:     output,
+	}
+	require.NoError(t, digger.Run(ctx))
+
+	// The raw messages should be printed in their original order
+	assert.Equal(t, strings.Join(lines, "\n")+"\n", output.String())
+
+	assert.Equal(t, int64(5000), liveStats.messageCounter().Summary().TotalMessages)
+	assert.Equal(
+		t,
+		[]stats.Bucket{
+			{Key: "id0", Count: 1667, Min: 1, Max: 1, Sum: 1667},
+			{Key: "id1", Count: 1667, Min: 1, Max: 1, Sum: 1667},
+			{Key: "id2", Count: 1666, Min: 1, Max: 1, Sum: 1666},
+		},
+		bucketsWithoutIndex(liveStats.topKCounter().Buckets(10, false)),
+	)
+
+	checkpoint, err = LoadCheckpoint(checkpointPath)
+	require.NoError(t, err)
+	completed, _ := checkpoint.progress(message.FileSource(path))
+	assert.True(t, completed)
+}
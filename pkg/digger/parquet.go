@@ -0,0 +1,190 @@
+package digger
+
+import (
+	"io"
+	"strconv"
+
+	"github.com/parquet-go/parquet-go"
+	sjson "github.com/segmentio/encoding/json"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// FormatParquet is an input format for parquet files. Each row is converted to a JSON
+	// object and treated as a separate message.
+	FormatParquet = "parquet"
+
+	// Number of rows to read from a row group at a time
+	parquetBatchSize = 256
+
+	// ParquetRowGroupAttribute is the message attribute that's set to the index of the row group
+	// that each parquet row was read from.
+	ParquetRowGroupAttribute = "rowGroup"
+)
+
+// parquetReader is a recordReader implementation that reads the rows in a parquet file. The
+// rows are numbered sequentially across row groups, so each row group maps to a contiguous range
+// of message offsets.
+type parquetReader struct {
+	schema    *parquet.Schema
+	conv      parquet.Conversion
+	rowGroups []parquet.RowGroup
+	maxSize   int
+
+	rowGroupIndex int
+	rowOffset     int64
+	rows          parquet.Rows
+	rowBuf        []parquet.Row
+	rowBufIndex   int
+	rowBufLen     int
+	rowBufGroup   int
+	contents      []byte
+
+	// attributes are the message attributes for the rows in attributesGroup; they're shared
+	// by all of the rows in the group
+	attributes      map[string]string
+	attributesGroup int
+}
+
+var _ attributeRecordReader = (*parquetReader)(nil)
+
+// newParquetReader creates a parquetReader for the argument file contents. If columns is
+// non-empty, then only the top-level columns with these names are read from the file.
+func newParquetReader(
+	readerAt io.ReaderAt,
+	size int64,
+	columns []string,
+	maxSize int,
+	options ...parquet.FileOption,
+) (*parquetReader, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxMessageSize
+	}
+
+	file, err := parquet.OpenFile(readerAt, size, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := &parquetReader{
+		schema:    file.Schema(),
+		rowGroups: file.RowGroups(),
+		maxSize:   maxSize,
+		rowBuf:    make([]parquet.Row, parquetBatchSize),
+
+		attributesGroup: -1,
+	}
+
+	if len(columns) > 0 {
+		projection := parquetProjection(file.Schema(), columns)
+		conv, err := parquet.Convert(projection, file.Schema())
+		if err != nil {
+			return nil, err
+		}
+
+		reader.schema = projection
+		reader.conv = conv
+	}
+
+	return reader, nil
+}
+
+func (p *parquetReader) next() ([]byte, bool, error) {
+	for p.rowBufIndex >= p.rowBufLen {
+		if err := p.fill(); err != nil {
+			return nil, false, err
+		}
+	}
+
+	row := p.rowBuf[p.rowBufIndex]
+	p.rowBufIndex++
+
+	value := map[string]interface{}{}
+	if err := p.schema.Reconstruct(&value, row); err != nil {
+		return nil, false, err
+	}
+
+	var err error
+	p.contents, err = sjson.Append(p.contents[:0], value, sjson.SortMapKeys)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if len(p.contents) > p.maxSize {
+		return p.contents[0:p.maxSize], true, nil
+	}
+	return p.contents, false, nil
+}
+
+// lastAttributes returns the argument attributes plus the index of the row group that the last
+// row was read from.
+func (p *parquetReader) lastAttributes(base map[string]string) map[string]string {
+	if p.attributesGroup != p.rowBufGroup {
+		p.attributes = make(map[string]string, len(base)+1)
+		for key, value := range base {
+			p.attributes[key] = value
+		}
+		p.attributes[ParquetRowGroupAttribute] = strconv.Itoa(p.rowBufGroup)
+		p.attributesGroup = p.rowBufGroup
+	}
+	return p.attributes
+}
+
+// fill reads the next batch of rows into the row buffer, advancing to the next row group if
+// the current one is exhausted.
+func (p *parquetReader) fill() error {
+	if p.rows == nil {
+		if p.rowGroupIndex >= len(p.rowGroups) {
+			return io.EOF
+		}
+
+		rowGroup := p.rowGroups[p.rowGroupIndex]
+		log.Debugf(
+			"Reading parquet row group %d (offsets %d-%d)",
+			p.rowGroupIndex,
+			p.rowOffset,
+			p.rowOffset+rowGroup.NumRows()-1,
+		)
+
+		if p.conv != nil {
+			rowGroup = parquet.ConvertRowGroup(rowGroup, p.conv)
+		}
+		p.rows = rowGroup.Rows()
+		p.rowOffset += rowGroup.NumRows()
+	}
+
+	n, err := p.rows.ReadRows(p.rowBuf)
+	p.rowBufIndex = 0
+	p.rowBufLen = n
+	p.rowBufGroup = p.rowGroupIndex
+
+	if err == io.EOF {
+		p.rows.Close()
+		p.rows = nil
+		p.rowGroupIndex++
+		return nil
+	}
+	return err
+}
+
+// parquetProjection returns a schema that only contains the argument top-level columns. If
+// none of the columns are in the schema, then the first one in the file is used so that rows
+// can still be counted.
+func parquetProjection(schema *parquet.Schema, columns []string) *parquet.Schema {
+	group := parquet.Group{}
+
+	for _, column := range columns {
+		for _, field := range schema.Fields() {
+			if field.Name() == column {
+				group[column] = field
+			}
+		}
+	}
+
+	if len(group) == 0 && len(schema.Fields()) > 0 {
+		field := schema.Fields()[0]
+		group[field.Name()] = field
+	}
+
+	return parquet.NewSchema(schema.Name(), group)
+}
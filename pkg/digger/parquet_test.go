@@ -0,0 +1,153 @@
+package digger
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/segmentio/data-digger/pkg/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testParquetContext struct {
+	OS      string `parquet:"os"`
+	Version string `parquet:"version"`
+}
+
+type testParquetRow struct {
+	App     string             `parquet:"app"`
+	Context testParquetContext `parquet:"context"`
+	Latency int64              `parquet:"latency"`
+}
+
+func TestParquetReader(t *testing.T) {
+	contents := testParquetContents(t, 5, 2)
+
+	records, err := newParquetReader(
+		bytes.NewReader(contents),
+		int64(len(contents)),
+		nil,
+		0,
+	)
+	require.NoError(t, err)
+	assert.Equal(t, 3, len(records.rowGroups))
+
+	values := readAllRecords(t, records)
+	assert.Equal(
+		t,
+		[]string{
+			`{"app":"app0","context":{"os":"os0","version":"1.0"},"latency":0}`,
+			`{"app":"app1","context":{"os":"os1","version":"1.1"},"latency":10}`,
+			`{"app":"app2","context":{"os":"os2","version":"1.2"},"latency":20}`,
+			`{"app":"app3","context":{"os":"os3","version":"1.3"},"latency":30}`,
+			`{"app":"app4","context":{"os":"os4","version":"1.4"},"latency":40}`,
+		},
+		values,
+	)
+
+	records, err = newParquetReader(
+		bytes.NewReader(contents),
+		int64(len(contents)),
+		[]string{"context", "non-existent"},
+		0,
+	)
+	require.NoError(t, err)
+
+	values = readAllRecords(t, records)
+	require.Equal(t, 5, len(values))
+	assert.Equal(t, `{"context":{"os":"os0","version":"1.0"}}`, values[0])
+
+	records, err = newParquetReader(
+		bytes.NewReader(contents),
+		int64(len(contents)),
+		nil,
+		20,
+	)
+	require.NoError(t, err)
+
+	value, oversized, err := records.next()
+	require.NoError(t, err)
+	assert.True(t, oversized)
+	assert.Equal(t, `{"app":"app0","conte`, string(value))
+}
+
+func TestFileConsumerParquet(t *testing.T) {
+	ctx := context.Background()
+
+	filePath := filepath.Join(t.TempDir(), "test.parquet")
+	err := os.WriteFile(filePath, testParquetContents(t, 3, 2), 0644)
+	require.NoError(t, err)
+
+	consumer := FileConsumer{
+		Paths:          []string{filePath},
+		Format:         FormatParquet,
+		ParquetColumns: []string{"app"},
+	}
+	messageChan := make(chan message.Message, 50)
+	err = consumer.Run(ctx, messageChan)
+	require.NoError(t, err)
+
+	require.Equal(t, 3, len(messageChan))
+	message1 := <-messageChan
+	<-messageChan
+	message3 := <-messageChan
+
+	assert.Equal(t, int64(2), message3.Offset)
+	assert.Equal(t, []byte(`{"app":"app2"}`), message3.Value)
+
+	// Each message has the index of its row group
+	assert.Equal(t, map[string]string{ParquetRowGroupAttribute: "0"}, message1.Attributes)
+	assert.Equal(t, map[string]string{ParquetRowGroupAttribute: "1"}, message3.Attributes)
+
+	meta, err := metaJSON(message3)
+	require.NoError(t, err)
+	assert.Contains(t, string(meta), `"attributes":{"rowGroup":"1"}`)
+}
+
+func testParquetContents(t *testing.T, numRows int, rowGroupSize int64) []byte {
+	buf := &bytes.Buffer{}
+	writer := parquet.NewGenericWriter[testParquetRow](
+		buf,
+		parquet.MaxRowsPerRowGroup(rowGroupSize),
+	)
+
+	for i := 0; i < numRows; i++ {
+		_, err := writer.Write(
+			[]testParquetRow{
+				{
+					App: fmt.Sprintf("app%d", i),
+					Context: testParquetContext{
+						OS:      fmt.Sprintf("os%d", i),
+						Version: fmt.Sprintf("1.%d", i),
+					},
+					Latency: int64(i * 10),
+				},
+			},
+		)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, writer.Close())
+	return buf.Bytes()
+}
+
+func readAllRecords(t *testing.T, records recordReader) []string {
+	values := []string{}
+
+	for {
+		value, _, err := records.next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		values = append(values, string(value))
+	}
+
+	return values
+}
@@ -2,6 +2,7 @@ package digger
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -10,11 +11,13 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/briandowns/spinner"
 	"github.com/gosuri/uilive"
 	"github.com/segmentio/data-digger/pkg/json"
+	"github.com/segmentio/data-digger/pkg/message"
 	"github.com/segmentio/data-digger/pkg/proto"
 	"github.com/segmentio/data-digger/pkg/stats"
 	sjson "github.com/segmentio/encoding/json"
@@ -25,42 +28,120 @@ var (
 	spinnerStates = spinner.CharSets[21]
 )
 
+// How often the counters of the processing workers are fully merged to count the distinct values
+// for the progress display
+const progressMergeInterval = time.Second
+
 // Processor is an interface that can process and summarize messages.
 type Processor interface {
-	Process(context.Context, message) error
+	Process(context.Context, message.Message) error
 	Stop() error
 	Summary() string
 }
 
+// Ways that LiveStats can weigh the values in each message
+const (
+	WeightCount = "count"
+	WeightBytes = "bytes"
+)
+
+// ErrSkipMessage can be returned by a processor to stop the current message from being passed to
+// the processors after it. It isn't treated as a failure.
+var ErrSkipMessage = errors.New("Skip message")
+
 // LiveStatsConfig stores the inputs for a LiveStats processor.
 type LiveStatsConfig struct {
-	Filter       string
-	K            int
-	Numeric      bool
-	PathsStr     string
-	PrintMissing bool
-	ProtoTypes   []string
-	Raw          bool
-	RawExtended  bool
-	SortByName   bool
+	Filter         string
+	HeaderEncoding string
+	HeaderFilters  []string
+	K              int
+	Largest        int `json:",omitempty"`
+	Numeric        bool
+	PathFilters    []string
+	PathsStr       string
+	PrintMissing   bool
+	ProtoTypes     []string
+	Raw            bool
+	RawExtended    bool
+	SampleBy       string
+	SampleRate     float64
+	SelectStr      string
+	Sizes          bool `json:",omitempty"`
+	SortByName     bool
+	Weight         string
+
+	// NumWorkers is the number of workers that call ProcessWorker; each one gets its own shard
+	// of counters. It's left out of checkpoints since it doesn't change the results.
+	NumWorkers int `json:"-"`
+
+	// ContextAfter and ContextBefore are the numbers of messages after and before each raw
+	// message in the same partition or file to print along with it. Like NumWorkers, they're
+	// left out of checkpoints since they only change the raw output.
+	ContextAfter  int `json:"-"`
+	ContextBefore int `json:"-"`
+}
+
+// pathFilter is a filter that matches the value at a specific path against a regexp.
+type pathFilter struct {
+	path   string
+	regexp *regexp.Regexp
 }
 
 // LiveStats is a processor that calculates and displays stats based on a structured
 // message stream.
 type LiveStats struct {
-	config       LiveStatsConfig
-	decoder      *proto.Decoder
-	pathGroups   [][]string
-	filterRegexp *regexp.Regexp
-	stopChan     chan struct{}
-	wg           sync.WaitGroup
+	config        LiveStatsConfig
+	pathGroups    [][]string
+	extractor     *json.PathExtractor
+	filterRegexp  *regexp.Regexp
+	headerFilters []headerFilter
+	pathFilters   []pathFilter
+	rawContext    *rawContext
+	sampler       *sampler
+	selectPaths   []string
+	usesMeta      bool
+	stopChan      chan struct{}
+	wg            sync.WaitGroup
+
+	shards []*liveStatsShard
+
+	// matches is the number of messages that have passed the filters in this run
+	matches atomic.Int64
+
+	// progressCategories is the number of distinct values across all of the shards when they
+	// were last merged for the progress display, at progressMergedAt. They're only accessed by
+	// the progress loop.
+	progressCategories int
+	progressMergedAt   time.Time
+}
 
+// liveStatsShard stores the decoder and counters used by a single processing worker. Each worker
+// has its own shard so that workers don't contend for the counter locks; the shards are merged
+// for the progress display, the summary, and checkpoints.
+type liveStatsShard struct {
+	decoder           *proto.Decoder
 	topKCounter       *stats.TopKCounter
 	messageCounter    *stats.MessageCounter
 	timeBucketCounter *stats.TimeBucketCounter
+
+	// sizes is nil if the sizes aren't measured
+	sizes *sizeStats
 }
 
-var _ Processor = (*LiveStats)(nil)
+var (
+	_ CheckpointProcessor = (*LiveStats)(nil)
+	_ MatchCounter        = (*LiveStats)(nil)
+	_ ParallelProcessor   = (*LiveStats)(nil)
+)
+
+// liveStatsState is the checkpointed state of a LiveStats processor. The config is included so
+// that a checkpoint isn't accidentally resumed with different options.
+type liveStatsState struct {
+	Config         LiveStatsConfig           `json:"config"`
+	TopK           stats.TopKCounterState    `json:"topK"`
+	MessageCounter stats.MessageCounterState `json:"messageCounter"`
+	Sizes          *sizeStatsState           `json:"sizes,omitempty"`
+}
 
 // NewLiveStats creates a new LiveStats instance and starts the main progress printing loop.
 func NewLiveStats(config LiveStatsConfig) (*LiveStats, error) {
@@ -74,32 +155,151 @@ func NewLiveStats(config LiveStatsConfig) (*LiveStats, error) {
 		}
 	}
 
-	decoder, err := proto.NewDecoder(config.ProtoTypes)
+	if err := validateHeaderEncoding(config.HeaderEncoding); err != nil {
+		return nil, err
+	}
+
+	headerFilters, err := parseHeaderFilters(config.HeaderFilters)
 	if err != nil {
 		return nil, err
 	}
 
-	pathGroups := [][]string{}
+	pathFilters := []pathFilter{}
 
-	if config.PathsStr != "" {
-		for _, pathGroupStr := range strings.Split(config.PathsStr, ";") {
-			pathGroups = append(pathGroups, strings.Split(pathGroupStr, ","))
+	for _, filterStr := range config.PathFilters {
+		path, expr, ok := splitPathFilter(filterStr)
+		if !ok {
+			return nil, fmt.Errorf("Path filter must be in path=regexp format: %s", filterStr)
 		}
-	} else {
-		pathGroups = append(pathGroups, []string{""})
+		valueRegexp, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		pathFilters = append(pathFilters, pathFilter{path: path, regexp: valueRegexp})
 	}
 
-	l := &LiveStats{
-		config:       config,
-		decoder:      decoder,
-		filterRegexp: filterRegexp,
-		pathGroups:   pathGroups,
-		stopChan:     make(chan struct{}),
-		wg:           sync.WaitGroup{},
+	switch config.Weight {
+	case "", WeightCount:
+	case WeightBytes:
+		if config.Numeric {
+			return nil, errors.New("Values can't be weighted by bytes in numeric mode")
+		}
+	default:
+		return nil, fmt.Errorf(
+			"Invalid weight (expected %s or %s): %s",
+			WeightCount,
+			WeightBytes,
+			config.Weight,
+		)
+	}
 
-		topKCounter:       stats.NewTopKCounter(config.K),
-		messageCounter:    stats.NewMessageCounter(),
-		timeBucketCounter: stats.NewTimeBucketCounter(250*time.Millisecond, 5*time.Second),
+	// A zero rate means that sampling wasn't configured
+	var sampler *sampler
+	if config.SampleRate != 0 || config.SampleBy != "" {
+		sampler, err = newSampler(config.SampleRate, config.SampleBy)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var rawContext *rawContext
+	if config.ContextAfter < 0 || config.ContextBefore < 0 {
+		return nil, errors.New("Number of context messages can't be negative")
+	}
+	if config.ContextAfter > 0 || config.ContextBefore > 0 {
+		if !config.Raw && !config.RawExtended {
+			return nil, errors.New("Context messages can only be shown with raw output")
+		}
+		if config.NumWorkers > 1 {
+			// The messages in each partition need to be processed in order
+			return nil, errors.New("Context messages can't be shown with multiple processors")
+		}
+		rawContext = newRawContext(config.ContextAfter, config.ContextBefore)
+	}
+
+	// Sizes are measured on the messages that pass the filters and sampling, so that they can
+	// reuse the decoded messages and extracted values
+	sizesConfig := sizeStatsConfig{
+		K:        config.K,
+		Largest:  config.Largest,
+		PathsStr: config.PathsStr,
+		Weight:   config.Weight,
+	}
+	if !config.Sizes {
+		// The number of largest messages is only used with sizes, so it's left out of checkpoints
+		// otherwise
+		config.Largest = 0
+	}
+
+	var selectPaths []string
+	if config.SelectStr != "" {
+		selectPaths = strings.Split(config.SelectStr, ",")
+	}
+
+	numShards := config.NumWorkers
+	if numShards < 1 {
+		numShards = 1
+	}
+
+	shards := []*liveStatsShard{}
+
+	for i := 0; i < numShards; i++ {
+		// Decoders reuse their proto instances, so each shard needs its own
+		decoder, err := proto.NewDecoder(config.ProtoTypes)
+		if err != nil {
+			return nil, err
+		}
+
+		var sizes *sizeStats
+		if config.Sizes {
+			sizes, err = newSizeStats(sizesConfig)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		shards = append(
+			shards,
+			&liveStatsShard{
+				decoder:           decoder,
+				topKCounter:       stats.NewTopKCounter(config.K),
+				messageCounter:    stats.NewMessageCounter(),
+				timeBucketCounter: stats.NewTimeBucketCounter(250*time.Millisecond, 5*time.Second),
+				sizes:             sizes,
+			},
+		)
+	}
+
+	pathGroups := parsePathGroups(config.PathsStr)
+
+	usesMeta := false
+	for _, pathGroup := range pathGroups {
+		for _, path := range pathGroup {
+			usesMeta = usesMeta || json.IsMetaPath(path)
+		}
+	}
+	for _, filter := range pathFilters {
+		usesMeta = usesMeta || json.IsMetaPath(filter.path)
+	}
+	for _, path := range selectPaths {
+		usesMeta = usesMeta || json.IsMetaPath(path)
+	}
+	usesMeta = usesMeta || json.IsMetaPath(config.SampleBy)
+
+	l := &LiveStats{
+		config:        config,
+		filterRegexp:  filterRegexp,
+		headerFilters: headerFilters,
+		pathFilters:   pathFilters,
+		pathGroups:    pathGroups,
+		rawContext:    rawContext,
+		sampler:       sampler,
+		extractor:     json.NewPathExtractor(pathGroups),
+		selectPaths:   selectPaths,
+		usesMeta:      usesMeta,
+		stopChan:      make(chan struct{}),
+		wg:            sync.WaitGroup{},
+		shards:        shards,
 	}
 
 	l.wg.Add(1)
@@ -108,41 +308,199 @@ func NewLiveStats(config LiveStatsConfig) (*LiveStats, error) {
 	return l, nil
 }
 
+// parsePathGroups splits a --paths value into groups of paths. Groups are separated by
+// semicolons and the paths in each group by commas; if the value is empty, then the whole
+// message is the only path.
+func parsePathGroups(pathsStr string) [][]string {
+	if pathsStr == "" {
+		return [][]string{{""}}
+	}
+
+	pathGroups := [][]string{}
+	for _, pathGroupStr := range strings.Split(pathsStr, ";") {
+		pathGroups = append(pathGroups, strings.Split(pathGroupStr, ","))
+	}
+	return pathGroups
+}
+
 // Process updates the stats in this LiveStats for a single message.
-func (l *LiveStats) Process(ctx context.Context, messageObj message) error {
-	decodedMsg, err := l.decoder.ToJSON(messageObj.msg.Value)
+func (l *LiveStats) Process(ctx context.Context, msg message.Message) error {
+	return l.process(l.shards[0], msg, os.Stdout)
+}
+
+// ProcessWorker updates the stats in the argument worker's shard for a single message. Raw
+// messages are written to output.
+func (l *LiveStats) ProcessWorker(
+	ctx context.Context,
+	worker int,
+	msg message.Message,
+	output io.Writer,
+) error {
+	return l.process(l.shards[worker%len(l.shards)], msg, output)
+}
+
+func (l *LiveStats) process(shard *liveStatsShard, msg message.Message, output io.Writer) error {
+	if l.rawContext != nil {
+		// Messages that aren't printed as matches may be printed as context
+		matched := false
+		defer func() {
+			if !matched {
+				l.rawContext.miss(msg, output, l.contextRender(shard))
+			}
+		}()
+
+		return l.processMessage(shard, msg, output, &matched)
+	}
+
+	return l.processMessage(shard, msg, output, nil)
+}
+
+// processMessage updates the stats in the argument shard for a single message. If matched isn't
+// nil, then raw messages are printed with context, and it's set for the ones that are printed.
+func (l *LiveStats) processMessage(
+	shard *liveStatsShard,
+	msg message.Message,
+	output io.Writer,
+	matched *bool,
+) error {
+	if msg.Oversized {
+		shard.messageCounter.UpdateOversized()
+
+		if msg.Value == nil {
+			// Message was skipped by the consumer
+			shard.messageCounter.Update(msg, false)
+			return nil
+		}
+	}
+
+	if !matchHeaders(msg.Headers, l.headerFilters) {
+		// Headers are checked before decoding so that non-matching messages are cheap to skip
+		shard.messageCounter.Update(msg, false)
+		log.Debug("Dropping message due to header filter")
+		return nil
+	}
+
+	if l.sampler != nil && l.sampler.byPosition() && !l.sampler.keepPosition(msg) {
+		// Sampling by position doesn't need the contents, so do it before decoding
+		shard.messageCounter.Update(msg, false)
+		return nil
+	}
+
+	decodedMsg, validated, err := shard.decoder.ToJSONUnvalidated(msg.Value)
 
 	if log.IsLevelEnabled(log.DebugLevel) {
-		log.Debugf("Got message: ts=%s partition=%d offset=%d key=%s value=%s",
-			messageObj.msg.Time.Format(time.RFC3339),
-			messageObj.msg.Partition,
-			messageObj.msg.Offset,
-			string(messageObj.msg.Key),
+		log.Debugf("Got message: source=%s partition=%d offset=%d ts=%s key=%s value=%s",
+			msg.Source,
+			msg.Partition,
+			msg.Offset,
+			msg.Timestamp().Format(time.RFC3339),
+			string(msg.Key),
 			string(decodedMsg),
 		)
 	}
 
 	if err != nil {
+		if l.sampler != nil && !l.sampler.byPosition() && !l.sampler.keepValue(nil, nil) {
+			// Messages that can't be decoded are sampled as if their values were missing
+			shard.messageCounter.Update(msg, false)
+			return nil
+		}
+
 		log.Debugf("Error decoding message to JSON (%+v): %s", err, decodedMsg)
-		l.topKCounter.Add(stats.InvalidValue, 1.0)
+		l.addValue(shard, msg, stats.InvalidValue)
+
+		// Sizes are only measured for the messages that pass the filters. Messages that can't be
+		// decoded never match the path filters, and the regexp is matched against their raw
+		// contents.
+		if len(l.pathFilters) == 0 && (l.filterRegexp == nil || l.filterRegexp.Match(decodedMsg)) {
+			shard.addSize(msg, -1, nil)
+		}
 		return nil
 	}
 
-	l.timeBucketCounter.Increment(time.Now(), 1)
+	// Validating the JSON takes a full pass over the message, so it's only done if the message
+	// is dropped or printed, or if none of the paths are found in it. Messages that are invalid
+	// are counted as such instead of as dropped or missing, but their sizes are only measured if
+	// they passed the filters and sampling.
+	invalid := func(passed bool) bool {
+		if validated {
+			return false
+		}
+		validated = true
+
+		if !sjson.Valid(decodedMsg) {
+			log.Debugf("Message is not valid JSON: %s", decodedMsg)
+			l.addValue(shard, msg, stats.InvalidValue)
+			if passed {
+				shard.addSize(msg, -1, nil)
+			}
+			return true
+		}
+		return false
+	}
+
+	shard.timeBucketCounter.Increment(time.Now(), 1)
 
 	if l.filterRegexp != nil && !l.filterRegexp.Match(decodedMsg) {
-		l.messageCounter.Update(messageObj.msg, false)
+		if invalid(false) {
+			return nil
+		}
+		shard.messageCounter.Update(msg, false)
 		log.Debug("Dropping message due to filter")
 		return nil
 	}
 
+	var meta []byte
+	if l.usesMeta {
+		meta, err = metaJSON(msg)
+		if err != nil {
+			return err
+		}
+	}
+
+	if l.sampler != nil && !l.sampler.byPosition() && !l.sampler.keepValue(decodedMsg, meta) {
+		shard.messageCounter.Update(msg, false)
+		return nil
+	}
+
+	for _, filter := range l.pathFilters {
+		result := json.PathResult(decodedMsg, meta, filter.path)
+		if !result.Exists() || !filter.regexp.MatchString(result.String()) {
+			if invalid(false) {
+				return nil
+			}
+			shard.messageCounter.Update(msg, false)
+			log.Debugf("Dropping message due to filter on %s", filter.path)
+			return nil
+		}
+	}
+
 	if l.config.Raw || l.config.RawExtended {
-		fmt.Println(l.rawString(messageObj, decodedMsg))
+		if invalid(true) {
+			return nil
+		}
+
+		rawMsg := decodedMsg
+		if len(l.selectPaths) > 0 {
+			rawMsg = json.Project(decodedMsg, meta, l.selectPaths)
+		}
+		line := l.rawString(msg, rawMsg, "")
+		if matched != nil {
+			*matched = true
+			l.rawContext.match(msg, line, output, l.contextRender(shard))
+		} else {
+			fmt.Fprintln(output, line)
+		}
 	}
 
-	l.messageCounter.Update(messageObj.msg, true)
+	values, found := l.extractor.Values(decodedMsg, meta)
+	if !found && invalid(true) {
+		return nil
+	}
 
-	values := json.GJsonPathValues(decodedMsg, l.pathGroups)
+	shard.messageCounter.Update(msg, true)
+	l.matches.Add(1)
+	shard.addSize(msg, int64(len(decodedMsg)), values)
 
 	for _, value := range values {
 		if l.config.Numeric {
@@ -152,14 +510,14 @@ func (l *LiveStats) Process(ctx context.Context, messageObj message) error {
 			// TODO: Create a new bucket in case that number is missing
 			// but subdimensions are not?
 			if numericComponent == stats.MissingValue {
-				l.topKCounter.Add(stats.MissingValue, 1.0)
+				shard.topKCounter.Add(stats.MissingValue, 1.0)
 				continue
 			}
 
 			floatValue, err := strconv.ParseFloat(numericComponent, 64)
 			if err != nil {
 				log.Debugf("Invalid numeric value: %s", numericComponent)
-				l.topKCounter.Add(stats.InvalidValue, 1.0)
+				shard.topKCounter.Add(stats.InvalidValue, 1.0)
 				continue
 			}
 
@@ -173,14 +531,14 @@ func (l *LiveStats) Process(ctx context.Context, messageObj message) error {
 				)
 			}
 
-			l.topKCounter.Add(bucketValue, floatValue)
+			shard.topKCounter.Add(bucketValue, floatValue)
 		} else {
-			l.topKCounter.Add(value, 1.0)
+			l.addValue(shard, msg, value)
 		}
 	}
 
 	if len(values) == 0 {
-		l.topKCounter.Add(stats.MissingValue, 1.0)
+		l.addValue(shard, msg, stats.MissingValue)
 		if l.config.PrintMissing {
 			log.Infof(
 				"Message is missing all paths: %s",
@@ -192,6 +550,25 @@ func (l *LiveStats) Process(ctx context.Context, messageObj message) error {
 	return nil
 }
 
+// addValue adds a (non-numeric) value from the argument message to the shard's counter. With
+// WeightBytes, the value is weighted by the size of the message.
+func (l *LiveStats) addValue(shard *liveStatsShard, msg message.Message, value string) {
+	if l.config.Weight == WeightBytes {
+		size := len(msg.Value)
+		shard.topKCounter.AddWeighted(value, float64(size), size)
+		return
+	}
+	shard.topKCounter.Add(value, 1.0)
+}
+
+// addSize adds the sizes of the argument message to the shard's size stats, if they're enabled.
+// The decoded size is negative for invalid messages.
+func (s *liveStatsShard) addSize(msg message.Message, decodedSize int64, values []string) {
+	if s.sizes != nil {
+		s.sizes.add(msg, decodedSize, values)
+	}
+}
+
 func (l *LiveStats) progressLoop() {
 	var progressWriter *uilive.Writer
 	var outputWriter io.Writer
@@ -223,10 +600,10 @@ outerLoop:
 		select {
 		case <-l.stopChan:
 			// Print one last update
-			l.printProgress(outputWriter, spinnerIndex)
+			l.printProgress(outputWriter, spinnerIndex, true)
 			break outerLoop
 		case <-ticker.C:
-			l.printProgress(outputWriter, spinnerIndex)
+			l.printProgress(outputWriter, spinnerIndex, false)
 			if progressWriter != nil {
 				progressWriter.Flush()
 			}
@@ -243,9 +620,14 @@ outerLoop:
 	l.wg.Done()
 }
 
-func (l *LiveStats) printProgress(outputWriter io.Writer, spinnerIndex int) {
-	topKSummary := l.topKCounter.Summary()
-	messageSummary := l.messageCounter.Summary()
+func (l *LiveStats) printProgress(outputWriter io.Writer, spinnerIndex int, final bool) {
+	topKSummary, messageSummary := l.progressSummaries(final)
+
+	// The value totals are in bytes if the values are weighted by size
+	unit := ""
+	if l.config.Weight == WeightBytes {
+		unit = "bytes of "
+	}
 
 	fmt.Fprint(
 		outputWriter,
@@ -257,7 +639,7 @@ func (l *LiveStats) printProgress(outputWriter io.Writer, spinnerIndex int) {
 				),
 				fmt.Sprintf(
 					"  %0.0f messages / sec",
-					l.timeBucketCounter.RatePerSec(),
+					l.ratePerSec(),
 				),
 				fmt.Sprintf(
 					"  %d messages total (%d partitions/files, %s->%s)",
@@ -266,23 +648,27 @@ func (l *LiveStats) printProgress(outputWriter io.Writer, spinnerIndex int) {
 					messageSummary.FirstTime.Format(time.RFC3339),
 					messageSummary.LastTime.Format(time.RFC3339),
 				),
+				l.postFilterProgress(messageSummary.PostFilterMessages),
 				fmt.Sprintf(
-					"  %d messages post-filters",
-					messageSummary.PostFilterMessages,
+					"  %d oversized messages skipped or truncated",
+					messageSummary.OversizedMessages,
 				),
-				fmt.Sprintf("  %d message values added", topKSummary.TotalAdded),
+				fmt.Sprintf("  %d %smessage values added", topKSummary.TotalAdded, unit),
 				fmt.Sprintf("  %d categories", topKSummary.NumCategories),
 				fmt.Sprintf(
-					"  %d messages with no value categories",
+					"  %d %smessages with no value categories",
 					topKSummary.TotalMissing,
+					unit,
 				),
 				fmt.Sprintf(
-					"  %d messages with invalid structures",
+					"  %d %smessages with invalid structures",
 					topKSummary.TotalInvalid,
+					unit,
 				),
 				fmt.Sprintf(
-					"  %d message values dropped due to category overflow\n",
+					"  %d %smessage values dropped due to category overflow\n",
 					topKSummary.TotalRemoved,
+					unit,
 				),
 			},
 			"\n",
@@ -290,6 +676,79 @@ func (l *LiveStats) printProgress(outputWriter io.Writer, spinnerIndex int) {
 	)
 }
 
+// progressSummaries returns the counter summaries for the progress display. The totals are
+// summed across the shards on each update, but counting the distinct values requires merging the
+// full counters, so that count is only refreshed every progressMergeInterval and on the final
+// update.
+func (l *LiveStats) progressSummaries(
+	final bool,
+) (stats.TopKCounterSummary, stats.MessageCounterSummary) {
+	if len(l.shards) == 1 {
+		return l.shards[0].topKCounter.Summary(), l.shards[0].messageCounter.Summary()
+	}
+
+	topKSummary := stats.TopKCounterSummary{}
+	messageSummary := stats.MessageCounterSummary{
+		PartitionCounters: map[stats.PartitionKey]stats.PartitionCounter{},
+	}
+
+	for _, shard := range l.shards {
+		shardTopK := shard.topKCounter.Summary()
+		topKSummary.TotalAdded += shardTopK.TotalAdded
+		topKSummary.TotalRemoved += shardTopK.TotalRemoved
+		topKSummary.TotalMissing += shardTopK.TotalMissing
+		topKSummary.TotalInvalid += shardTopK.TotalInvalid
+
+		shardMessages := shard.messageCounter.Summary()
+		messageSummary.TotalMessages += shardMessages.TotalMessages
+		messageSummary.PostFilterMessages += shardMessages.PostFilterMessages
+		messageSummary.OversizedMessages += shardMessages.OversizedMessages
+
+		// Only the number of partitions is shown, so the counters aren't merged
+		for key, partitionCounter := range shardMessages.PartitionCounters {
+			messageSummary.PartitionCounters[key] = partitionCounter
+		}
+		if !shardMessages.FirstTime.IsZero() &&
+			(messageSummary.FirstTime.IsZero() ||
+				shardMessages.FirstTime.Before(messageSummary.FirstTime)) {
+			messageSummary.FirstTime = shardMessages.FirstTime
+		}
+		if shardMessages.LastTime.After(messageSummary.LastTime) {
+			messageSummary.LastTime = shardMessages.LastTime
+		}
+	}
+
+	now := time.Now()
+	if final || now.Sub(l.progressMergedAt) >= progressMergeInterval {
+		l.progressCategories = l.topKCounter().Summary().NumCategories
+		l.progressMergedAt = now
+	}
+	topKSummary.NumCategories = l.progressCategories
+
+	return topKSummary, messageSummary
+}
+
+// postFilterProgress returns the progress line for the number of messages that passed the
+// filters, including the extrapolated number if the messages are sampled.
+func (l *LiveStats) postFilterProgress(postFilterMessages int64) string {
+	if l.sampler == nil {
+		return fmt.Sprintf("  %d messages post-filters", postFilterMessages)
+	}
+
+	return fmt.Sprintf(
+		"  %d messages post-filters and sampling (%s); ~%d estimated without sampling",
+		postFilterMessages,
+		l.sampler,
+		stats.EstimateCount(postFilterMessages, l.sampler.rate),
+	)
+}
+
+// Matches returns the number of messages that have passed the filters so far. Unlike the
+// post-filter count in the summary, it doesn't include messages from restored checkpoints.
+func (l *LiveStats) Matches() int64 {
+	return l.matches.Load()
+}
+
 // Stop stops this LiveStats instance.
 func (l *LiveStats) Stop() error {
 	l.stopChan <- struct{}{}
@@ -299,35 +758,220 @@ func (l *LiveStats) Stop() error {
 
 // Summary returns a pretty table summary of the stats calculated by this LiveStats instance.
 func (l *LiveStats) Summary() string {
+	title := "Top K values"
+	sampleRate := 1.0
+	countLabel := ""
+	estimated := "counts"
+
+	if l.config.Weight == WeightBytes {
+		title += " by bytes"
+		countLabel = "Bytes"
+		estimated = "bytes"
+	}
+
+	if l.sampler != nil {
+		title += fmt.Sprintf(
+			" (approximate; sampled %s, estimated %s are extrapolated from the sample)",
+			l.sampler,
+			estimated,
+		)
+		sampleRate = l.sampler.rate
+	} else {
+		title += " (approximate)"
+	}
+
+	sizesSummary := ""
+	if sizes := l.sizeStats(); sizes != nil {
+		sizesSummary = sizes.summary() + "\n"
+	}
+
 	return fmt.Sprintf(
-		"Top K values (approximate):\n%s",
-		l.topKCounter.PrettyTable(
+		"%s%s:\n%s",
+		sizesSummary,
+		title,
+		l.topKCounter().PrettyTable(
 			len(l.pathGroups),
 			l.config.Numeric,
 			l.config.SortByName,
+			sampleRate,
+			countLabel,
 		),
 	)
 }
 
+// CheckpointState returns the state of this LiveStats for a checkpoint.
+func (l *LiveStats) CheckpointState() (interface{}, error) {
+	state := liveStatsState{
+		Config:         l.config,
+		TopK:           l.topKCounter().State(),
+		MessageCounter: l.messageCounter().State(),
+	}
+	if sizes := l.sizeStats(); sizes != nil {
+		sizesState := sizes.state()
+		state.Sizes = &sizesState
+	}
+	return state, nil
+}
+
+// RestoreCheckpoint restores the state of this LiveStats from a checkpoint. It returns an error
+// if the checkpoint was created with a different config.
+func (l *LiveStats) RestoreCheckpoint(stateBytes sjson.RawMessage) error {
+	state := liveStatsState{}
+	if err := sjson.Unmarshal(stateBytes, &state); err != nil {
+		return err
+	}
+
+	configBytes, err := sjson.Marshal(l.config)
+	if err != nil {
+		return err
+	}
+	stateConfigBytes, err := sjson.Marshal(state.Config)
+	if err != nil {
+		return err
+	}
+	if string(configBytes) != string(stateConfigBytes) {
+		return errors.New("Checkpoint was created with different stats options")
+	}
+
+	// The restored counts go into the first shard; the shards are merged when they're read
+	for s, shard := range l.shards {
+		if s == 0 {
+			shard.topKCounter.Restore(state.TopK)
+			shard.messageCounter.Restore(state.MessageCounter)
+		} else {
+			shard.topKCounter.Restore(stats.TopKCounterState{})
+			shard.messageCounter.Restore(stats.MessageCounterState{})
+		}
+
+		if shard.sizes != nil {
+			if s == 0 && state.Sizes != nil {
+				shard.sizes.restore(*state.Sizes)
+			} else {
+				shard.sizes.restore(sizeStatsState{})
+			}
+		}
+	}
+	return nil
+}
+
+// topKCounter returns a top K counter with the values from all of the shards.
+func (l *LiveStats) topKCounter() *stats.TopKCounter {
+	if len(l.shards) == 1 {
+		return l.shards[0].topKCounter
+	}
+
+	merged := stats.NewTopKCounter(l.config.K)
+	for _, shard := range l.shards {
+		merged.Merge(shard.topKCounter.State())
+	}
+	return merged
+}
+
+// messageCounter returns a message counter with the counts from all of the shards.
+func (l *LiveStats) messageCounter() *stats.MessageCounter {
+	if len(l.shards) == 1 {
+		return l.shards[0].messageCounter
+	}
+
+	merged := stats.NewMessageCounter()
+	for _, shard := range l.shards {
+		merged.Merge(shard.messageCounter.State())
+	}
+	return merged
+}
+
+// sizeStats returns size stats with the sizes from all of the shards, or nil if the sizes
+// aren't measured.
+func (l *LiveStats) sizeStats() *sizeStats {
+	if len(l.shards) == 1 || l.shards[0].sizes == nil {
+		return l.shards[0].sizes
+	}
+
+	merged, _ := newSizeStats(l.shards[0].sizes.config)
+	for _, shard := range l.shards {
+		merged.merge(shard.sizes.state())
+	}
+	return merged
+}
+
+// ratePerSec returns the total processing rate across all of the shards.
+func (l *LiveStats) ratePerSec() float64 {
+	rate := 0.0
+	for _, shard := range l.shards {
+		rate += shard.timeBucketCounter.RatePerSec()
+	}
+	return rate
+}
+
 type extendedMessage struct {
-	DecodedValue sjson.RawMessage `json:"decodedValue"`
-	Key          string           `json:"key"`
-	Offset       int64            `json:"offset"`
-	Partition    int              `json:"partition"`
-	Time         time.Time        `json:"time"`
+	Attributes   map[string]string `json:"attributes,omitempty"`
+	Context      string            `json:"context,omitempty"`
+	DecodedValue sjson.RawMessage  `json:"decodedValue"`
+	Headers      []extendedHeader  `json:"headers,omitempty"`
+	Key          string            `json:"key,omitempty"`
+	ModTime      *time.Time        `json:"modTime,omitempty"`
+	Offset       int64             `json:"offset"`
+	Oversized    bool              `json:"oversized,omitempty"`
+	Partition    int               `json:"partition"`
+	Position     *int64            `json:"position,omitempty"`
+	Source       string            `json:"source"`
+	Time         *time.Time        `json:"time,omitempty"`
+	Topic        string            `json:"topic,omitempty"`
+}
+
+// contextRender returns a function that renders context messages with the argument shard's
+// decoder. Messages that aren't valid JSON after decoding are skipped.
+func (l *LiveStats) contextRender(shard *liveStatsShard) rawContextRender {
+	return func(msg message.Message, kind string) (string, bool) {
+		decodedMsg, _, err := shard.decoder.ToJSONUnvalidated(msg.Value)
+		if err != nil || !sjson.Valid(decodedMsg) {
+			return "", false
+		}
+
+		if len(l.selectPaths) > 0 {
+			var meta []byte
+			if l.usesMeta {
+				meta, err = metaJSON(msg)
+				if err != nil {
+					return "", false
+				}
+			}
+			decodedMsg = json.Project(decodedMsg, meta, l.selectPaths)
+		}
+
+		return l.rawString(msg, decodedMsg, kind), true
+	}
 }
 
-func (l *LiveStats) rawString(messageObj message, decodedBytes []byte) string {
+// rawString returns the raw output line for a message. The context kind is empty for messages
+// that passed the filters, and rawContextBefore or rawContextAfter for ones that are shown as
+// context around them.
+func (l *LiveStats) rawString(msg message.Message, decodedBytes []byte, contextKind string) string {
 	if l.config.RawExtended {
-		output, err := sjson.Marshal(
-			extendedMessage{
-				DecodedValue: sjson.RawMessage(decodedBytes),
-				Key:          string(messageObj.msg.Key),
-				Offset:       messageObj.msg.Offset,
-				Partition:    messageObj.msg.Partition,
-				Time:         messageObj.msg.Time,
-			},
-		)
+		extended := extendedMessage{
+			Attributes:   msg.Attributes,
+			Context:      contextKind,
+			DecodedValue: sjson.RawMessage(decodedBytes),
+			Headers:      encodeHeaders(msg.Headers, l.config.HeaderEncoding),
+			Key:          string(msg.Key),
+			Offset:       msg.Offset,
+			Oversized:    msg.Oversized,
+			Partition:    msg.Partition,
+			Source:       msg.Source,
+			Topic:        msg.Attributes["topic"],
+		}
+
+		if !msg.ModTime.IsZero() {
+			extended.ModTime = &msg.ModTime
+		}
+		if msg.Position >= 0 {
+			extended.Position = &msg.Position
+		}
+		if !msg.Time.IsZero() {
+			extended.Time = &msg.Time
+		}
+
+		output, err := sjson.Marshal(extended)
 		if err != nil {
 			log.Warnf("Error marshalling JSON: %+v", err)
 			return ""
@@ -336,5 +980,34 @@ func (l *LiveStats) rawString(messageObj message, decodedBytes []byte) string {
 		return string(output)
 	}
 
+	if l.rawContext != nil {
+		// Mark the matches so that they stand out from the context
+		if contextKind == "" {
+			return rawMatchMarker + string(decodedBytes)
+		}
+		return rawContextMarker + string(decodedBytes)
+	}
+
 	return string(decodedBytes)
 }
+
+// splitPathFilter splits a path=regexp filter on the first equals sign that isn't inside of a
+// gjson query (e.g., friends.#(last=="Murphy").first).
+func splitPathFilter(filterStr string) (string, string, bool) {
+	depth := 0
+
+	for i, c := range filterStr {
+		switch c {
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+		case '=':
+			if depth == 0 && i > 0 {
+				return filterStr[0:i], filterStr[i+1:], true
+			}
+		}
+	}
+
+	return "", "", false
+}
@@ -1,10 +1,13 @@
 package digger
 
 import (
+	"bytes"
 	"context"
+	"fmt"
 	"testing"
+	"time"
 
-	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/data-digger/pkg/message"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -21,7 +24,7 @@ func TestLiveStatsSingleDimension(t *testing.T) {
 	)
 	require.Nil(t, err)
 
-	kafkaMessages := []kafka.Message{
+	messages := []message.Message{
 		{
 			Value: []byte(`
 				{
@@ -82,15 +85,15 @@ func TestLiveStatsSingleDimension(t *testing.T) {
 		},
 	}
 
-	for _, kafkaMessage := range kafkaMessages {
-		err := liveStats.Process(ctx, message{kafkaMessage})
+	for _, msg := range messages {
+		err := liveStats.Process(ctx, msg)
 		require.NoError(t, err)
 	}
 
 	err = liveStats.Stop()
 	require.NoError(t, err)
 
-	buckets := liveStats.topKCounter.Buckets(4, false)
+	buckets := liveStats.topKCounter().Buckets(4, false)
 	require.Equal(t, 4, len(buckets))
 
 	assert.Equal(t, "id1", buckets[0].Key)
@@ -115,7 +118,7 @@ func TestLiveStatsSingleDimensionNumeric(t *testing.T) {
 	)
 	require.Nil(t, err)
 
-	kafkaMessages := []kafka.Message{
+	messages := []message.Message{
 		{
 			Value: []byte(`
 				{
@@ -170,15 +173,15 @@ func TestLiveStatsSingleDimensionNumeric(t *testing.T) {
 		},
 	}
 
-	for _, kafkaMessage := range kafkaMessages {
-		err := liveStats.Process(ctx, message{kafkaMessage})
+	for _, msg := range messages {
+		err := liveStats.Process(ctx, msg)
 		require.NoError(t, err)
 	}
 
 	err = liveStats.Stop()
 	require.NoError(t, err)
 
-	buckets := liveStats.topKCounter.Buckets(4, false)
+	buckets := liveStats.topKCounter().Buckets(4, false)
 	require.Equal(t, 3, len(buckets))
 
 	assert.Equal(t, "__all__", buckets[0].Key)
@@ -203,7 +206,7 @@ func TestLiveStatsMultiDimension(t *testing.T) {
 	)
 	require.Nil(t, err)
 
-	kafkaMessages := []kafka.Message{
+	messages := []message.Message{
 		{
 			Value: []byte(`
 				{
@@ -260,15 +263,15 @@ func TestLiveStatsMultiDimension(t *testing.T) {
 		},
 	}
 
-	for _, kafkaMessage := range kafkaMessages {
-		err := liveStats.Process(ctx, message{kafkaMessage})
+	for _, msg := range messages {
+		err := liveStats.Process(ctx, msg)
 		require.NoError(t, err)
 	}
 
 	err = liveStats.Stop()
 	require.NoError(t, err)
 
-	buckets := liveStats.topKCounter.Buckets(4, false)
+	buckets := liveStats.topKCounter().Buckets(4, false)
 	require.Equal(t, 4, len(buckets))
 
 	assert.Equal(t, "id1∪∪name1", buckets[0].Key)
@@ -293,7 +296,7 @@ func TestLiveStatsMultiDimensionNumeric(t *testing.T) {
 	)
 	require.Nil(t, err)
 
-	kafkaMessages := []kafka.Message{
+	messages := []message.Message{
 		{
 			Value: []byte(`
 				{
@@ -345,15 +348,15 @@ func TestLiveStatsMultiDimensionNumeric(t *testing.T) {
 		},
 	}
 
-	for _, kafkaMessage := range kafkaMessages {
-		err := liveStats.Process(ctx, message{kafkaMessage})
+	for _, msg := range messages {
+		err := liveStats.Process(ctx, msg)
 		require.NoError(t, err)
 	}
 
 	err = liveStats.Stop()
 	require.NoError(t, err)
 
-	buckets := liveStats.topKCounter.Buckets(4, false)
+	buckets := liveStats.topKCounter().Buckets(4, false)
 	require.Equal(t, 4, len(buckets))
 
 	assert.Equal(t, "id1", buckets[0].Key)
@@ -371,3 +374,356 @@ func TestLiveStatsMultiDimensionNumeric(t *testing.T) {
 	assert.Equal(t, 3.0, buckets[3].Max)
 	assert.Equal(t, 3.0, buckets[3].Sum)
 }
+
+func TestLiveStatsRawExtended(t *testing.T) {
+	liveStats, err := NewLiveStats(
+		LiveStatsConfig{
+			K:           10,
+			RawExtended: true,
+		},
+	)
+	require.Nil(t, err)
+	defer liveStats.Stop()
+
+	assert.Equal(
+		t,
+		`{"attributes":{"bucket":"test-bucket","key":"prefix/key1"},"decodedValue":{"key":"value"},"modTime":"2020-10-28T20:30:05Z","offset":3,"partition":1,"position":120,"source":"s3://test-bucket/prefix/key1"}`,
+		liveStats.rawString(
+			message.Message{
+				Source:    "s3://test-bucket/prefix/key1",
+				Partition: 1,
+				Offset:    3,
+				Position:  120,
+				ModTime:   time.Date(2020, 10, 28, 20, 30, 5, 0, time.UTC),
+				Attributes: map[string]string{
+					"bucket": "test-bucket",
+					"key":    "prefix/key1",
+				},
+			},
+			[]byte(`{"key":"value"}`),
+			"",
+		),
+	)
+	assert.Equal(
+		t,
+		`{"decodedValue":{"key":"value"},"key":"key1","offset":1234,"partition":2,"source":"kafka://localhost:9092/test-topic","time":"2020-10-28T20:30:05Z"}`,
+		liveStats.rawString(
+			message.Message{
+				Source:    "kafka://localhost:9092/test-topic",
+				Partition: 2,
+				Offset:    1234,
+				Position:  -1,
+				Time:      time.Date(2020, 10, 28, 20, 30, 5, 0, time.UTC),
+				Key:       []byte("key1"),
+			},
+			[]byte(`{"key":"value"}`),
+			"",
+		),
+	)
+}
+
+func TestLiveStatsMeta(t *testing.T) {
+	ctx := context.Background()
+
+	liveStats, err := NewLiveStats(
+		LiveStatsConfig{
+			K:           10,
+			PathFilters: []string{"@meta.headers.x-source=^web", `body.#(id=="id1").id=.`},
+			PathsStr:    "@meta.partition;@meta.file",
+		},
+	)
+	require.Nil(t, err)
+
+	messages := []message.Message{
+		{
+			Source:    "file://data/file1.json",
+			Partition: 0,
+			Headers:   []message.Header{{Key: "x-source", Value: []byte("web-1")}},
+			Value:     []byte(`{"body": [{"id": "id1"}]}`),
+		},
+		{
+			Source:    "file://data/file1.json",
+			Partition: 0,
+			Headers:   []message.Header{{Key: "x-source", Value: []byte("web-2")}},
+			Value:     []byte(`{"body": [{"id": "id1"}]}`),
+		},
+		{
+			Source:    "s3://bucket/prefix/key1",
+			Partition: 1,
+			Headers:   []message.Header{{Key: "x-source", Value: []byte("web-1")}},
+			Attributes: map[string]string{
+				"bucket": "bucket",
+				"key":    "prefix/key1",
+			},
+			Value: []byte(`{"body": [{"id": "id1"}]}`),
+		},
+		{
+			Source:    "file://data/file1.json",
+			Partition: 0,
+			Headers:   []message.Header{{Key: "x-source", Value: []byte("mobile")}},
+			Value:     []byte(`{"body": [{"id": "id1"}]}`),
+		},
+		{
+			Source:    "file://data/file1.json",
+			Partition: 0,
+			Headers:   []message.Header{{Key: "x-source", Value: []byte("web-1")}},
+			Value:     []byte(`{"body": [{"id": "id2"}]}`),
+		},
+	}
+
+	for _, msg := range messages {
+		err := liveStats.Process(ctx, msg)
+		require.NoError(t, err)
+	}
+
+	err = liveStats.Stop()
+	require.NoError(t, err)
+
+	buckets := liveStats.topKCounter().Buckets(4, false)
+	require.Equal(t, 2, len(buckets))
+
+	assert.Equal(t, "0∪∪data/file1.json", buckets[0].Key)
+	assert.Equal(t, 2, buckets[0].Count)
+	assert.Equal(t, "1∪∪prefix/key1", buckets[1].Key)
+	assert.Equal(t, 1, buckets[1].Count)
+}
+
+func TestSplitPathFilter(t *testing.T) {
+	type testCase struct {
+		filterStr    string
+		expectedPath string
+		expectedExpr string
+		expectedOK   bool
+	}
+
+	testCases := []testCase{
+		{
+			filterStr:    "type=track",
+			expectedPath: "type",
+			expectedExpr: "track",
+			expectedOK:   true,
+		},
+		{
+			filterStr:    "type=a=b",
+			expectedPath: "type",
+			expectedExpr: "a=b",
+			expectedOK:   true,
+		},
+		{
+			filterStr:    `friends.#(last=="Murphy").first=^D`,
+			expectedPath: `friends.#(last=="Murphy").first`,
+			expectedExpr: "^D",
+			expectedOK:   true,
+		},
+		{
+			filterStr:  "type",
+			expectedOK: false,
+		},
+		{
+			filterStr:  "=track",
+			expectedOK: false,
+		},
+	}
+
+	for _, testCase := range testCases {
+		path, expr, ok := splitPathFilter(testCase.filterStr)
+		assert.Equal(t, testCase.expectedOK, ok, testCase.filterStr)
+		assert.Equal(t, testCase.expectedPath, path, testCase.filterStr)
+		assert.Equal(t, testCase.expectedExpr, expr, testCase.filterStr)
+	}
+}
+
+func TestLiveStatsInvalid(t *testing.T) {
+	ctx := context.Background()
+
+	liveStats, err := NewLiveStats(
+		LiveStatsConfig{
+			K:           4,
+			PathsStr:    "id",
+			PathFilters: []string{"type=^track$"},
+		},
+	)
+	require.NoError(t, err)
+
+	messages := []message.Message{
+		{Value: []byte(`{"id": "id1", "type": "track"}`)},
+		{Value: []byte(`{"other": "id2", "type": "track"}`)},
+		{Value: []byte(`{"id": "id3", "type": "identify"}`)},
+		// Invalid messages are counted as such even if they'd be dropped by the filters
+		{Value: []byte(`{"id": "id4", "type": `)},
+		{Value: []byte(`not json`)},
+	}
+
+	for _, msg := range messages {
+		err := liveStats.Process(ctx, msg)
+		require.NoError(t, err)
+	}
+
+	err = liveStats.Stop()
+	require.NoError(t, err)
+
+	buckets := liveStats.topKCounter().Buckets(4, false)
+	require.Equal(t, 3, len(buckets))
+
+	assert.Equal(t, "__invalid__", buckets[0].Key)
+	assert.Equal(t, 2, buckets[0].Count)
+	assert.Equal(t, "__missing__", buckets[1].Key)
+	assert.Equal(t, 1, buckets[1].Count)
+	assert.Equal(t, "id1", buckets[2].Key)
+	assert.Equal(t, 1, buckets[2].Count)
+
+	summary := liveStats.messageCounter().Summary()
+	assert.Equal(t, int64(3), summary.TotalMessages)
+	assert.Equal(t, int64(2), summary.PostFilterMessages)
+}
+
+func TestLiveStatsTruncated(t *testing.T) {
+	ctx := context.Background()
+
+	liveStats, err := NewLiveStats(LiveStatsConfig{K: 4, PathsStr: "id"})
+	require.NoError(t, err)
+
+	messages := []message.Message{
+		{Value: []byte(`{"id": "id1", "type": "track"}`)},
+		// The paths are found in these, but they're still invalid
+		{Value: []byte(`{"id": "id2", "type": "track"`)},
+		{Value: []byte(`{"id": "id3", "properties": {"a": [1, 2`)},
+		{Value: []byte(`{"id": "id4"} garbage`)},
+		{Value: []byte(`{"id": "id5"}{"id": "id6"}`)},
+	}
+
+	for _, msg := range messages {
+		require.NoError(t, liveStats.Process(ctx, msg))
+	}
+	require.NoError(t, liveStats.Stop())
+
+	buckets := liveStats.topKCounter().Buckets(4, false)
+	require.Equal(t, 2, len(buckets))
+	assert.Equal(t, "__invalid__", buckets[0].Key)
+	assert.Equal(t, 4, buckets[0].Count)
+	assert.Equal(t, "id1", buckets[1].Key)
+	assert.Equal(t, 1, buckets[1].Count)
+}
+
+func TestLiveStatsWeightBytes(t *testing.T) {
+	ctx := context.Background()
+
+	liveStats, err := NewLiveStats(
+		LiveStatsConfig{
+			K:        4,
+			PathsStr: "type",
+			Weight:   WeightBytes,
+		},
+	)
+	require.NoError(t, err)
+
+	messages := []message.Message{
+		{Value: []byte(`{"type": "track"}`)},
+		{Value: []byte(`{"type": "track"}`)},
+		{Value: []byte(`{"type": "identify", "traits": {"name": "test"}}`)},
+		{Value: []byte(`{"other": "value"}`)},
+		{Value: []byte(`not json`)},
+	}
+
+	for _, msg := range messages {
+		err := liveStats.Process(ctx, msg)
+		require.NoError(t, err)
+	}
+
+	err = liveStats.Stop()
+	require.NoError(t, err)
+
+	buckets := liveStats.topKCounter().Buckets(4, false)
+	require.Equal(t, 4, len(buckets))
+
+	// The values are ranked by the total sizes of their messages instead of by their counts
+	assert.Equal(t, "identify", buckets[0].Key)
+	assert.Equal(t, 48, buckets[0].Count)
+	assert.Equal(t, "track", buckets[1].Key)
+	assert.Equal(t, 34, buckets[1].Count)
+	assert.Equal(t, "__missing__", buckets[2].Key)
+	assert.Equal(t, 18, buckets[2].Count)
+	assert.Equal(t, "__invalid__", buckets[3].Key)
+	assert.Equal(t, 8, buckets[3].Count)
+
+	assert.Contains(t, liveStats.Summary(), "Top K values by bytes (approximate):")
+
+	// Both the weight and the sampling are included in the title
+	sampled, err := NewLiveStats(
+		LiveStatsConfig{K: 4, PathsStr: "type", Weight: WeightBytes, SampleRate: 0.5},
+	)
+	require.NoError(t, err)
+	require.NoError(t, sampled.Stop())
+	assert.Contains(
+		t,
+		sampled.Summary(),
+		"Top K values by bytes (approximate; sampled 50% of messages, estimated bytes are",
+	)
+
+	_, err = NewLiveStats(LiveStatsConfig{K: 4, Numeric: true, Weight: WeightBytes})
+	assert.Error(t, err)
+	_, err = NewLiveStats(LiveStatsConfig{K: 4, Weight: "unknown"})
+	assert.Error(t, err)
+}
+
+func BenchmarkLiveStats(b *testing.B) {
+	ctx := context.Background()
+	lines := bytes.Split(bytes.TrimSpace(readSampleData(b)), []byte("\n"))
+
+	liveStats, err := NewLiveStats(
+		LiveStatsConfig{
+			K:           25,
+			PathsStr:    "app;type;context.os",
+			PathFilters: []string{"context.version=[0-9]"},
+
+			// Discards the progress display so that it doesn't mix with the results
+			Raw: true,
+		},
+	)
+	require.NoError(b, err)
+
+	// Stop the progress display before switching back to the regular stats path
+	require.NoError(b, liveStats.Stop())
+	liveStats.config.Raw = false
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		liveStats.Process(ctx, message.Message{Value: lines[i%len(lines)]})
+	}
+}
+
+func TestLiveStatsProgressSummaries(t *testing.T) {
+	ctx := context.Background()
+
+	liveStats, err := NewLiveStats(LiveStatsConfig{K: 10, PathsStr: "type", NumWorkers: 3})
+	require.NoError(t, err)
+	require.NoError(t, liveStats.Stop())
+
+	for i := 0; i < 30; i++ {
+		msg := message.Message{
+			Partition: i % 2,
+			Offset:    int64(i),
+			Value:     []byte(fmt.Sprintf(`{"type":"t%d"}`, i%5)),
+		}
+		require.NoError(t, liveStats.ProcessWorker(ctx, i%3, msg, nil))
+	}
+
+	topKSummary, messageSummary := liveStats.progressSummaries(true)
+	assert.Equal(t, liveStats.topKCounter().Summary(), topKSummary)
+	assert.Equal(t, int64(30), messageSummary.TotalMessages)
+	assert.Equal(t, int64(30), messageSummary.PostFilterMessages)
+	assert.Equal(t, 2, len(messageSummary.PartitionCounters))
+
+	// The distinct values are only recounted periodically, but the totals are always current
+	msg := message.Message{Offset: 30, Value: []byte(`{"type":"new"}`)}
+	require.NoError(t, liveStats.ProcessWorker(ctx, 0, msg, nil))
+	topKSummary, messageSummary = liveStats.progressSummaries(false)
+	assert.Equal(t, 5, topKSummary.NumCategories)
+	assert.Equal(t, 31, topKSummary.TotalAdded)
+	assert.Equal(t, int64(31), messageSummary.TotalMessages)
+
+	topKSummary, _ = liveStats.progressSummaries(true)
+	assert.Equal(t, 6, topKSummary.NumCategories)
+}
@@ -0,0 +1,197 @@
+package digger
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/segmentio/data-digger/pkg/message"
+)
+
+const (
+	// Separator that's printed between non-adjacent groups of raw messages, like in grep
+	rawContextSeparator = "--"
+
+	// Markers that are printed before the matching and context messages in raw (non-extended)
+	// output
+	rawMatchMarker   = "> "
+	rawContextMarker = "  "
+
+	// Number of partitions or files that context is kept for before the ones that haven't been
+	// seen recently are dropped
+	maxRawContextPartitions = 4096
+)
+
+// Kinds of context messages in the raw extended output
+const (
+	rawContextBefore = "before"
+	rawContextAfter  = "after"
+)
+
+// rawContext keeps track of the messages around the matches in each partition or file so that
+// they can be printed along with them in the raw output, like grep -A, -B, and -C. It isn't safe
+// for concurrent use, and it relies on the messages in each partition being passed to it in
+// order.
+type rawContext struct {
+	after      int
+	before     int
+	partitions map[rawContextKey]*rawPartitionContext
+
+	// numMessages is the number of messages seen so far in all partitions
+	numMessages int64
+
+	// nextPrune is the number of partitions at which the ones that haven't been seen recently
+	// are dropped
+	nextPrune int
+
+	printed     bool
+	lastPrinted rawContextKey
+}
+
+type rawContextKey struct {
+	source    string
+	partition int
+}
+
+// rawPartitionContext is the context state for a single partition or file. Messages are
+// numbered by their order in the partition so that gaps between printed messages can be found.
+type rawPartitionContext struct {
+	// before is a ring buffer of the most recent messages that weren't printed, along with
+	// their numbers
+	before    []message.Message
+	beforeSeq []int64
+	start     int
+	count     int
+
+	// afterRemaining is the number of upcoming messages to print after the last match
+	afterRemaining int
+
+	seq            int64
+	lastPrintedSeq int64
+
+	// lastSeen is the value of numMessages when the partition last had a message
+	lastSeen int64
+}
+
+// rawContextRender returns the output line for a context message of the argument kind; if ok
+// is false, then the message can't be shown and it's skipped.
+type rawContextRender func(msg message.Message, kind string) (line string, ok bool)
+
+func newRawContext(after int, before int) *rawContext {
+	return &rawContext{
+		after:      after,
+		before:     before,
+		partitions: map[rawContextKey]*rawPartitionContext{},
+		nextPrune:  maxRawContextPartitions,
+	}
+}
+
+// match prints a matching message, preceded by the messages before it in its partition that
+// haven't been printed yet.
+func (c *rawContext) match(
+	msg message.Message,
+	line string,
+	output io.Writer,
+	render rawContextRender,
+) {
+	key, partition := c.partition(msg)
+
+	for i := 0; i < partition.count; i++ {
+		index := (partition.start + i) % len(partition.before)
+		if beforeLine, ok := render(partition.before[index], rawContextBefore); ok {
+			c.print(key, partition, partition.beforeSeq[index], beforeLine, output)
+		}
+		partition.before[index] = message.Message{}
+	}
+	partition.start = 0
+	partition.count = 0
+
+	c.print(key, partition, partition.seq, line, output)
+	partition.afterRemaining = c.after
+}
+
+// miss handles a message that doesn't match. It's printed if it's soon enough after a match;
+// otherwise, it's kept in case there's a match soon after it. The message is copied before it's
+// kept, so the argument one can be released.
+func (c *rawContext) miss(msg message.Message, output io.Writer, render rawContextRender) {
+	key, partition := c.partition(msg)
+
+	if partition.afterRemaining > 0 {
+		partition.afterRemaining--
+		if line, ok := render(msg, rawContextAfter); ok {
+			c.print(key, partition, partition.seq, line, output)
+		}
+		return
+	}
+
+	if c.before == 0 || msg.Value == nil {
+		return
+	}
+
+	if partition.before == nil {
+		partition.before = make([]message.Message, c.before)
+		partition.beforeSeq = make([]int64, c.before)
+	}
+
+	index := (partition.start + partition.count) % c.before
+	if partition.count == c.before {
+		// The buffer is full, so replace the oldest message
+		partition.start = (partition.start + 1) % c.before
+	} else {
+		partition.count++
+	}
+
+	partition.before[index] = msg.Clone()
+	partition.beforeSeq[index] = partition.seq
+}
+
+// partition returns the state for the argument message's partition, after numbering the
+// message.
+func (c *rawContext) partition(msg message.Message) (rawContextKey, *rawPartitionContext) {
+	c.numMessages++
+
+	key := rawContextKey{source: msg.Source, partition: msg.Partition}
+	partition, ok := c.partitions[key]
+	if !ok {
+		if len(c.partitions) >= c.nextPrune {
+			c.prune()
+		}
+
+		partition = &rawPartitionContext{lastPrintedSeq: -1}
+		c.partitions[key] = partition
+	}
+
+	partition.seq++
+	partition.lastSeen = c.numMessages
+	return key, partition
+}
+
+// prune drops the state for the partitions that haven't had any messages recently; they're
+// typically files or objects that have been fully read.
+func (c *rawContext) prune() {
+	for key, partition := range c.partitions {
+		if partition.lastSeen <= c.numMessages-maxRawContextPartitions && key != c.lastPrinted {
+			delete(c.partitions, key)
+		}
+	}
+
+	c.nextPrune = len(c.partitions) + maxRawContextPartitions
+}
+
+// print prints a line for the argument message number in a partition, preceded by a separator
+// if it doesn't immediately follow the last line that was printed.
+func (c *rawContext) print(
+	key rawContextKey,
+	partition *rawPartitionContext,
+	seq int64,
+	line string,
+	output io.Writer,
+) {
+	if c.printed && (key != c.lastPrinted || seq != partition.lastPrintedSeq+1) {
+		fmt.Fprintln(output, rawContextSeparator)
+	}
+	fmt.Fprintln(output, line)
+
+	c.printed = true
+	c.lastPrinted = key
+	partition.lastPrintedSeq = seq
+}
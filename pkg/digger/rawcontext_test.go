@@ -0,0 +1,195 @@
+package digger
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/segmentio/data-digger/pkg/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRawContext(t *testing.T) {
+	type testCase struct {
+		description string
+		after       int
+		before      int
+		matches     []int
+		expected    []string
+	}
+
+	testCases := []testCase{
+		{
+			description: "after only",
+			after:       2,
+			matches:     []int{1, 7},
+			expected:    []string{"m1", "a2", "a3", "--", "m7", "a8", "a9"},
+		},
+		{
+			description: "before only",
+			before:      2,
+			matches:     []int{1, 7},
+			expected:    []string{"b0", "m1", "--", "b5", "b6", "m7"},
+		},
+		{
+			description: "adjacent groups are merged",
+			after:       1,
+			before:      1,
+			matches:     []int{2, 5, 9},
+			expected:    []string{"b1", "m2", "a3", "b4", "m5", "a6", "--", "b8", "m9"},
+		},
+		{
+			description: "overlapping groups",
+			after:       3,
+			before:      3,
+			matches:     []int{1, 3},
+			expected:    []string{"b0", "m1", "a2", "m3", "a4", "a5", "a6"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		rawContext := newRawContext(testCase.after, testCase.before)
+		output := &bytes.Buffer{}
+		render := func(msg message.Message, kind string) (string, bool) {
+			return fmt.Sprintf("%s%s", kind[0:1], msg.Value), true
+		}
+
+		for i := 0; i < 10; i++ {
+			msg := message.Message{Source: "test", Value: []byte(fmt.Sprintf("%d", i))}
+			msg.SetPooledValue(msg.Value)
+
+			matched := false
+			for _, match := range testCase.matches {
+				matched = matched || match == i
+			}
+
+			if matched {
+				rawContext.match(msg, fmt.Sprintf("m%d", i), output, render)
+			} else {
+				rawContext.miss(msg, output, render)
+			}
+
+			// The kept messages must not depend on the pooled buffers
+			msg.Release()
+		}
+
+		assert.Equal(
+			t,
+			strings.Join(testCase.expected, "\n")+"\n",
+			output.String(),
+			testCase.description,
+		)
+	}
+}
+
+func TestRawContextPartitions(t *testing.T) {
+	rawContext := newRawContext(1, 1)
+	output := &bytes.Buffer{}
+	render := func(msg message.Message, kind string) (string, bool) {
+		return fmt.Sprintf("%s%d:%s", kind[0:1], msg.Partition, msg.Value), true
+	}
+
+	messages := []message.Message{
+		{Partition: 0, Value: []byte("0")},
+		{Partition: 1, Value: []byte("0")},
+		{Partition: 0, Value: []byte("1")},
+		{Partition: 1, Value: []byte("1")},
+		{Partition: 0, Value: []byte("2")},
+		{Partition: 1, Value: []byte("2")},
+	}
+
+	for _, msg := range messages {
+		if msg.Partition == 0 && string(msg.Value) == "1" {
+			rawContext.match(msg, "m0:1", output, render)
+		} else {
+			rawContext.miss(msg, output, render)
+		}
+	}
+
+	// The context comes from the same partition as the match, and messages from other
+	// partitions in between don't split the group
+	assert.Equal(t, "b0:0\nm0:1\na0:2\n", output.String())
+}
+
+func TestLiveStatsContext(t *testing.T) {
+	ctx := context.Background()
+
+	messages := []message.Message{
+		{Source: "file://a", Offset: 0, Position: -1, Value: []byte(`{"level":"info","n":0}`)},
+		{Source: "file://a", Offset: 1, Position: -1, Value: []byte(`{"level":"info","n":1}`)},
+		{Source: "file://a", Offset: 2, Position: -1, Value: []byte(`{"level":"error","n":2}`)},
+		{Source: "file://a", Offset: 3, Position: -1, Value: []byte(`{"level":"info","n":3}`)},
+		{Source: "file://a", Offset: 4, Position: -1, Value: []byte(`{"level":"info","n":4}`)},
+		{Source: "file://a", Offset: 5, Position: -1, Value: []byte(`{"level":"info","n":5}`)},
+		{Source: "file://a", Offset: 6, Position: -1, Value: []byte(`{"level":"error","n":6}`)},
+	}
+
+	liveStats, err := NewLiveStats(
+		LiveStatsConfig{
+			K:             10,
+			Filter:        "error",
+			Raw:           true,
+			ContextAfter:  1,
+			ContextBefore: 1,
+		},
+	)
+	require.NoError(t, err)
+
+	output := &bytes.Buffer{}
+	for _, msg := range messages {
+		require.NoError(t, liveStats.ProcessWorker(ctx, 0, msg, output))
+	}
+	require.NoError(t, liveStats.Stop())
+
+	assert.Equal(
+		t,
+		strings.Join(
+			[]string{
+				`  {"level":"info","n":1}`,
+				`> {"level":"error","n":2}`,
+				`  {"level":"info","n":3}`,
+				`--`,
+				`  {"level":"info","n":5}`,
+				`> {"level":"error","n":6}`,
+			},
+			"\n",
+		)+"\n",
+		output.String(),
+	)
+
+	// Context doesn't affect the stats
+	assert.Equal(t, int64(2), liveStats.messageCounter().Summary().PostFilterMessages)
+
+	liveStats, err = NewLiveStats(
+		LiveStatsConfig{
+			K:             10,
+			Filter:        "error",
+			RawExtended:   true,
+			SelectStr:     "n",
+			ContextBefore: 1,
+		},
+	)
+	require.NoError(t, err)
+
+	output = &bytes.Buffer{}
+	for _, msg := range messages[0:3] {
+		require.NoError(t, liveStats.ProcessWorker(ctx, 0, msg, output))
+	}
+	require.NoError(t, liveStats.Stop())
+
+	assert.Equal(
+		t,
+		`{"context":"before","decodedValue":{"n":1},"offset":1,"partition":0,"source":"file://a"}`+"\n"+
+			`{"decodedValue":{"n":2},"offset":2,"partition":0,"source":"file://a"}`+"\n",
+		output.String(),
+	)
+
+	// Context needs raw output and ordered processing
+	_, err = NewLiveStats(LiveStatsConfig{K: 10, ContextAfter: 1})
+	assert.Error(t, err)
+	_, err = NewLiveStats(LiveStatsConfig{K: 10, Raw: true, ContextAfter: 1, NumWorkers: 2})
+	assert.Error(t, err)
+}
@@ -5,14 +5,28 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/segmentio/kafka-go"
+	"github.com/parquet-go/parquet-go"
+	"github.com/segmentio/data-digger/pkg/message"
+	"github.com/segmentio/data-digger/pkg/util"
 	log "github.com/sirupsen/logrus"
 )
 
+const (
+	// Size of the read buffer used for parquet objects
+	s3ParquetBufferSize = 4 * 1024 * 1024
+
+	// Max number of prefixes to generate from a key layout; if a time range needs more than
+	// this, then the original prefixes are listed instead
+	s3MaxLayoutPrefixes = 10000
+)
+
 // S3Consumer is a Consumer implementation that reads from one or more prefixes in an S3
 // bucket.
 type S3Consumer struct {
@@ -20,19 +34,108 @@ type S3Consumer struct {
 	Bucket     string
 	Prefixes   []string
 	NumWorkers int
+
+	// RequesterPays should be set to read from buckets where the requester is charged for
+	// requests and data transfer.
+	RequesterPays bool
+
+	// Include and Exclude are optional regexps for the object keys to read. If Include is set,
+	// only keys that match it are read; keys that match Exclude are skipped.
+	Include *regexp.Regexp
+	Exclude *regexp.Regexp
+
+	// Since and Until are optional bounds on the times of the objects to read. If KeyLayout is
+	// set, the times are parsed from the object keys; otherwise, the LastModified times of the
+	// objects are used.
+	Since     time.Time
+	Until     time.Time
+	KeyLayout *util.KeyLayout
+
+	// MinSize and MaxSize are optional bounds on the sizes of the objects to read, in bytes.
+	// Zero values are treated as unbounded.
+	MinSize int64
+	MaxSize int64
+
+	// InventoryManifest is an optional s3:// URL of the manifest.json for an S3 inventory of the
+	// bucket. If set, the objects are taken from the inventory instead of listing the prefixes.
+	InventoryManifest string
+
+	// Format is the format of the input data; if empty, FormatLines is used.
+	Format string
+
+	// MaxMessageSize is the maximum size of a single message; if zero, DefaultMaxMessageSize is
+	// used. Longer messages are skipped unless TruncateOversized is set.
+	MaxMessageSize    int
+	TruncateOversized bool
+
+	// ParquetColumns is the set of top-level columns to read from parquet inputs; if empty,
+	// all columns are read.
+	ParquetColumns []string
+
+	// CSV stores the options used for the CSV and TSV formats.
+	CSV CSVOptions
+
+	// Checkpoint is an optional checkpoint that's used to skip objects that were already
+	// processed.
+	Checkpoint *Checkpoint
+
+	// OnError is the policy for objects that can't be read; if unset, errors stop the run.
+	// Objects that are skipped due to errors are recorded in Failures, if it's set.
+	OnError  ErrorPolicy
+	Failures *FailureReport
 }
 
-var _ Consumer = (*S3Consumer)(nil)
+var _ CheckpointConsumer = (*S3Consumer)(nil)
+
+// s3Sources are the inputs of an S3Consumer that are recorded in checkpoints.
+type s3Sources struct {
+	Bucket            string    `json:"bucket"`
+	Prefixes          []string  `json:"prefixes"`
+	Include           string    `json:"include,omitempty"`
+	Exclude           string    `json:"exclude,omitempty"`
+	Since             time.Time `json:"since"`
+	Until             time.Time `json:"until"`
+	KeyLayout         string    `json:"keyLayout,omitempty"`
+	MinSize           int64     `json:"minSize,omitempty"`
+	MaxSize           int64     `json:"maxSize,omitempty"`
+	InventoryManifest string    `json:"inventoryManifest,omitempty"`
+	Format            string    `json:"format"`
+}
 
 type s3ObjTask struct {
 	objInfo *s3.Object
 	index   int
 }
 
+// CheckpointSources returns the bucket, prefixes, and the options that determine which objects
+// and records are read from them.
+func (s *S3Consumer) CheckpointSources() interface{} {
+	sources := s3Sources{
+		Bucket:            s.Bucket,
+		Prefixes:          s.Prefixes,
+		Since:             s.Since.UTC(),
+		Until:             s.Until.UTC(),
+		MinSize:           s.MinSize,
+		MaxSize:           s.MaxSize,
+		InventoryManifest: s.InventoryManifest,
+		Format:            s.Format,
+	}
+	if s.Include != nil {
+		sources.Include = s.Include.String()
+	}
+	if s.Exclude != nil {
+		sources.Exclude = s.Exclude.String()
+	}
+	if s.KeyLayout != nil {
+		sources.KeyLayout = s.KeyLayout.String()
+	}
+	return sources
+}
+
 // Run starts the s3 consumer. Messages are passed to the argument message channel.
 func (s *S3Consumer) Run(
 	ctx context.Context,
-	messageChan chan message,
+	messageChan chan message.Message,
 ) error {
 	objectChan := make(chan s3ObjTask, s.NumWorkers)
 	errChan := make(chan error, s.NumWorkers+1)
@@ -60,18 +163,27 @@ func (s *S3Consumer) processPrefixes(
 	ctx context.Context,
 	objectChan chan s3ObjTask,
 ) error {
+	if s.InventoryManifest != "" {
+		return s.processInventory(ctx, objectChan)
+	}
+
 	keysRead := 0
 	prefixesRead := 0
 
-	for _, prefix := range s.Prefixes {
+	for _, prefix := range s.listPrefixes() {
 		err := s.S3Client.ListObjectsPagesWithContext(
 			ctx,
 			&s3.ListObjectsInput{
-				Bucket: aws.String(s.Bucket),
-				Prefix: aws.String(prefix),
+				Bucket:       aws.String(s.Bucket),
+				Prefix:       aws.String(prefix),
+				RequestPayer: s.requestPayer(),
 			},
 			func(output *s3.ListObjectsOutput, hasMore bool) bool {
 				for _, objInfo := range output.Contents {
+					if !s.includeObject(objInfo) {
+						continue
+					}
+
 					subTask := s3ObjTask{
 						objInfo: objInfo,
 						index:   keysRead,
@@ -97,9 +209,89 @@ func (s *S3Consumer) processPrefixes(
 	return nil
 }
 
+// listPrefixes returns the prefixes to list. If there's a key layout and a start time, then
+// each prefix is narrowed down to the sub-prefixes for the time ranges between Since and Until.
+func (s *S3Consumer) listPrefixes() []string {
+	if s.KeyLayout == nil || s.Since.IsZero() {
+		return s.Prefixes
+	}
+
+	until := s.Until
+	if until.IsZero() {
+		until = time.Now()
+	}
+
+	layoutPrefixes, ok := s.KeyLayout.Prefixes(s.Since, until, s3MaxLayoutPrefixes)
+	if !ok {
+		log.Warnf(
+			"Time range covers more than %d instances of layout %s; listing full prefixes",
+			s3MaxLayoutPrefixes,
+			s.KeyLayout,
+		)
+		return s.Prefixes
+	}
+
+	prefixes := []string{}
+	for _, prefix := range s.Prefixes {
+		for _, layoutPrefix := range layoutPrefixes {
+			prefixes = append(prefixes, prefix+layoutPrefix)
+		}
+	}
+
+	log.Debugf("Generated %d prefixes from layout %s", len(prefixes), s.KeyLayout)
+	return prefixes
+}
+
+func (s *S3Consumer) includeObject(objInfo *s3.Object) bool {
+	key := aws.StringValue(objInfo.Key)
+
+	if s.Include != nil && !s.Include.MatchString(key) {
+		return false
+	}
+	if s.Exclude != nil && s.Exclude.MatchString(key) {
+		return false
+	}
+
+	size := aws.Int64Value(objInfo.Size)
+	if s.MinSize > 0 && size < s.MinSize {
+		return false
+	}
+	if s.MaxSize > 0 && size > s.MaxSize {
+		return false
+	}
+
+	if s.KeyLayout != nil {
+		keyTime, ok := s.KeyLayout.Time(key)
+		if !ok {
+			log.Debugf("Skipping key %s that doesn't match layout", key)
+			return false
+		}
+		return s.KeyLayout.Overlaps(keyTime, s.Since, s.Until)
+	}
+
+	lastModified := aws.TimeValue(objInfo.LastModified)
+	if !s.Since.IsZero() && lastModified.Before(s.Since) {
+		return false
+	}
+	if !s.Until.IsZero() && lastModified.After(s.Until) {
+		return false
+	}
+
+	return true
+}
+
+// requestPayer returns the value of the RequestPayer parameter for requests to this consumer's
+// bucket.
+func (s *S3Consumer) requestPayer() *string {
+	if s.RequesterPays {
+		return aws.String(s3.RequestPayerRequester)
+	}
+	return nil
+}
+
 func (s *S3Consumer) runSubTasks(
 	ctx context.Context,
-	messageChan chan message,
+	messageChan chan message.Message,
 	objectChan chan s3ObjTask,
 ) error {
 	for {
@@ -109,11 +301,22 @@ func (s *S3Consumer) runSubTasks(
 				return nil
 			}
 
-			err := s.processKey(ctx, messageChan, subTask.objInfo, subTask.index)
+			key := aws.StringValue(subTask.objInfo.Key)
+			err := readSource(
+				ctx,
+				s.OnError,
+				s.Checkpoint,
+				s.Failures,
+				message.S3Source(s.Bucket, key),
+				subTask.index,
+				func(skip int64) (int64, error) {
+					return s.processKey(ctx, messageChan, subTask.objInfo, subTask.index, skip)
+				},
+			)
 			if err != nil {
 				return fmt.Errorf(
 					"Error processing key %s: %+v",
-					aws.StringValue(subTask.objInfo.Key),
+					key,
 					err,
 				)
 			}
@@ -125,65 +328,136 @@ func (s *S3Consumer) runSubTasks(
 
 func (s *S3Consumer) processKey(
 	ctx context.Context,
-	messageChan chan message,
+	messageChan chan message.Message,
 	objInfo *s3.Object,
 	index int,
-) error {
-	log.Debugf("Processing key %s", aws.StringValue(objInfo.Key))
+	skip int64,
+) (int64, error) {
+	key := aws.StringValue(objInfo.Key)
+	log.Debugf("Processing key %s", key)
+
+	attributes := map[string]string{
+		"bucket": s.Bucket,
+		"key":    key,
+		"etag":   strings.Trim(aws.StringValue(objInfo.ETag), `"`),
+	}
+
+	var records recordReader
+	var err error
+
+	if s.Format == FormatParquet {
+		records, err = newParquetReader(
+			&s3ObjectReader{
+				ctx:          ctx,
+				s3Client:     s.S3Client,
+				bucket:       s.Bucket,
+				key:          key,
+				requestPayer: s.requestPayer(),
+			},
+			aws.Int64Value(objInfo.Size),
+			s.ParquetColumns,
+			s.MaxMessageSize,
+			// Use a big buffer to cut down on the number of range requests
+			parquet.ReadBufferSize(s3ParquetBufferSize),
+			parquet.SkipPageIndex(true),
+			parquet.SkipBloomFilters(true),
+		)
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		var contentEncoding *string
+		if strings.HasSuffix(aws.StringValue(objInfo.Key), ".gz") {
+			// Assume gzip encoding (which might not actually be set in the object in S3)
+			contentEncoding = aws.String("gzip")
+		}
+
+		obj, err := s.S3Client.GetObjectWithContext(
+			ctx,
+			&s3.GetObjectInput{
+				Bucket:                  aws.String(s.Bucket),
+				Key:                     objInfo.Key,
+				ResponseContentEncoding: contentEncoding,
+				RequestPayer:            s.requestPayer(),
+			},
+		)
+		if err != nil {
+			return 0, err
+		}
+		if obj.Body == nil {
+			err = errors.New("Unexpected nil buffer")
+			return 0, err
+		}
+		defer obj.Body.Close()
+
+		if obj.VersionId != nil {
+			attributes["version"] = aws.StringValue(obj.VersionId)
+		}
 
-	var contentEncoding *string
-	if strings.HasSuffix(aws.StringValue(objInfo.Key), ".gz") {
-		// Assume gzip encoding (which might not actually be set in the object in S3)
-		contentEncoding = aws.String("gzip")
+		// Wrap the body in a large buffer to improve performance
+		buffer := bufio.NewReaderSize(obj.Body, 10e6)
+
+		records, err = newRecordReader(
+			s.Format,
+			buffer,
+			s.MaxMessageSize,
+			s.CSV,
+		)
+		if err != nil {
+			return 0, err
+		}
 	}
 
-	obj, err := s.S3Client.GetObjectWithContext(
+	return readRecords(
 		ctx,
+		records,
+		message.Message{
+			Source:     message.S3Source(s.Bucket, key),
+			Partition:  index,
+			ModTime:    aws.TimeValue(objInfo.LastModified),
+			Attributes: attributes,
+		},
+		s.TruncateOversized,
+		skip,
+		messageChan,
+	)
+}
+
+// s3ObjectReader is an io.ReaderAt implementation that reads ranges of an S3 object. It's used
+// for formats like parquet that can't be read sequentially.
+type s3ObjectReader struct {
+	ctx          context.Context
+	s3Client     *s3.S3
+	bucket       string
+	key          string
+	requestPayer *string
+}
+
+var _ io.ReaderAt = (*s3ObjectReader)(nil)
+
+// ReadAt reads len(p) bytes starting at the argument offset in the object.
+func (r *s3ObjectReader) ReadAt(p []byte, off int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	obj, err := r.s3Client.GetObjectWithContext(
+		r.ctx,
 		&s3.GetObjectInput{
-			Bucket:                  aws.String(s.Bucket),
-			Key:                     objInfo.Key,
-			ResponseContentEncoding: contentEncoding,
+			Bucket:       aws.String(r.bucket),
+			Key:          aws.String(r.key),
+			Range:        aws.String(fmt.Sprintf("bytes=%d-%d", off, off+int64(len(p))-1)),
+			RequestPayer: r.requestPayer,
 		},
 	)
 	if err != nil {
-		return err
-	}
-	if obj.Body == nil {
-		err = errors.New("Unexpected nil buffer")
-		return err
+		return 0, err
 	}
 	defer obj.Body.Close()
 
-	// Wrap the body in a large buffer to improve performance
-	buffer := bufio.NewReaderSize(obj.Body, 10e6)
-
-	scanner := bufio.NewScanner(buffer)
-	buf := make([]byte, 4096)
-	scanner.Buffer(buf, maxMessageSize)
-
-	var offset int64
-
-	for {
-		if scanner.Scan() {
-			contents := scanner.Bytes()
-
-			// Need to do a copy since scanner can change underlying bytes when
-			// next scan call is made.
-			copiedContents := make([]byte, len(contents))
-			copy(copiedContents, contents)
-
-			messageChan <- message{
-				msg: kafka.Message{
-					Partition: index,
-					Time:      aws.TimeValue(objInfo.LastModified),
-					Key:       []byte(aws.StringValue(objInfo.Key)),
-					Offset:    offset,
-					Value:     copiedContents,
-				},
-			}
-			offset++
-		} else {
-			return scanner.Err()
-		}
+	n, err := io.ReadFull(obj.Body, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
 	}
+	return n, err
 }
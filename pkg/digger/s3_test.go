@@ -12,12 +12,50 @@ import (
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/segmentio/data-digger/pkg/message"
+	"github.com/segmentio/data-digger/pkg/util"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
 func TestS3Consumer(t *testing.T) {
 	ctx := context.Background()
+	s3Client := newTestS3Client()
+
+	testBucket := createBucket(ctx, t, s3Client)
+
+	time.Sleep(100 * time.Millisecond)
+	writeKey(ctx, t, s3Client, testBucket, "test-prefix1/key1", "value1\nvalue2")
+	writeKey(ctx, t, s3Client, testBucket, "test-prefix1/key2", "value3\nvalue4")
+	writeKey(ctx, t, s3Client, testBucket, "test-prefix2/key3", "value5")
+
+	messageChan := make(chan message.Message, 5)
+	consumer := S3Consumer{
+		S3Client:   s3Client,
+		Bucket:     testBucket,
+		Prefixes:   []string{"test-prefix1", "test-prefix2"},
+		NumWorkers: 1,
+	}
+	err := consumer.Run(ctx, messageChan)
+	require.NoError(t, err)
+
+	require.Equal(t, 5, len(messageChan))
+	message1 := <-messageChan
+	message2 := <-messageChan
+
+	assert.Equal(t, 0, message1.Partition)
+	assert.Equal(t, 0, message2.Partition)
+	assert.Equal(t, int64(0), message1.Offset)
+	assert.Equal(t, int64(1), message2.Offset)
+	assert.Equal(t, fmt.Sprintf("s3://%s/test-prefix1/key1", testBucket), message1.Source)
+	assert.Equal(t, fmt.Sprintf("s3://%s/test-prefix1/key1", testBucket), message2.Source)
+	assert.Equal(t, "test-prefix1/key1", message1.Attributes["key"])
+	assert.Equal(t, int64(7), message2.Position)
+	assert.Equal(t, []byte("value1"), message1.Value)
+	assert.Equal(t, []byte("value2"), message2.Value)
+}
+
+func newTestS3Client() *s3.S3 {
 	sess := session.Must(session.NewSession())
 
 	var s3Endpoint string
@@ -29,7 +67,7 @@ func TestS3Consumer(t *testing.T) {
 		s3Endpoint = "http://localhost:4572"
 	}
 
-	s3Client := s3.New(
+	return s3.New(
 		sess,
 		&aws.Config{
 			// These need to be set, but they can be anything since localstack
@@ -42,36 +80,6 @@ func TestS3Consumer(t *testing.T) {
 			S3ForcePathStyle: aws.Bool(true),
 		},
 	)
-
-	testBucket := createBucket(ctx, t, s3Client)
-
-	time.Sleep(100 * time.Millisecond)
-	writeKey(ctx, t, s3Client, testBucket, "test-prefix1/key1", "value1\nvalue2")
-	writeKey(ctx, t, s3Client, testBucket, "test-prefix1/key2", "value3\nvalue4")
-	writeKey(ctx, t, s3Client, testBucket, "test-prefix2/key3", "value5")
-
-	messageChan := make(chan message, 5)
-	consumer := S3Consumer{
-		S3Client:   s3Client,
-		Bucket:     testBucket,
-		Prefixes:   []string{"test-prefix1", "test-prefix2"},
-		NumWorkers: 1,
-	}
-	err := consumer.Run(ctx, messageChan)
-	require.NoError(t, err)
-
-	require.Equal(t, 5, len(messageChan))
-	message1 := <-messageChan
-	message2 := <-messageChan
-
-	assert.Equal(t, 0, message1.msg.Partition)
-	assert.Equal(t, 0, message2.msg.Partition)
-	assert.Equal(t, int64(0), message1.msg.Offset)
-	assert.Equal(t, int64(1), message2.msg.Offset)
-	assert.Equal(t, []byte("test-prefix1/key1"), message1.msg.Key)
-	assert.Equal(t, []byte("test-prefix1/key1"), message2.msg.Key)
-	assert.Equal(t, []byte("value1"), message1.msg.Value)
-	assert.Equal(t, []byte("value2"), message2.msg.Value)
 }
 
 func createBucket(ctx context.Context, t *testing.T, s3Client *s3.S3) string {
@@ -107,3 +115,74 @@ func writeKey(
 	)
 	require.NoError(t, err)
 }
+
+func TestS3ConsumerTimeFilters(t *testing.T) {
+	layout, err := util.ParseKeyLayout("dt={YYYY-MM-DD}/hour={HH}")
+	require.NoError(t, err)
+
+	since := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	until := time.Date(2024, 5, 1, 14, 0, 0, 0, time.UTC)
+
+	layoutConsumer := S3Consumer{
+		Prefixes:  []string{"logs/", "other/"},
+		Since:     since,
+		Until:     until,
+		KeyLayout: layout,
+	}
+	assert.Equal(
+		t,
+		[]string{
+			"logs/dt=2024-05-01/hour=12",
+			"logs/dt=2024-05-01/hour=13",
+			"logs/dt=2024-05-01/hour=14",
+			"other/dt=2024-05-01/hour=12",
+			"other/dt=2024-05-01/hour=13",
+			"other/dt=2024-05-01/hour=14",
+		},
+		layoutConsumer.listPrefixes(),
+	)
+
+	assert.True(
+		t,
+		layoutConsumer.includeObject(
+			&s3.Object{Key: aws.String("logs/dt=2024-05-01/hour=12/part-0.gz")},
+		),
+	)
+	assert.False(
+		t,
+		layoutConsumer.includeObject(
+			&s3.Object{Key: aws.String("logs/dt=2024-05-01/hour=11/part-0.gz")},
+		),
+	)
+	assert.False(
+		t,
+		layoutConsumer.includeObject(
+			&s3.Object{Key: aws.String("logs/_SUCCESS")},
+		),
+	)
+
+	modifiedConsumer := S3Consumer{
+		Prefixes: []string{"logs/"},
+		Since:    since,
+		Until:    until,
+	}
+	assert.Equal(t, []string{"logs/"}, modifiedConsumer.listPrefixes())
+	assert.True(
+		t,
+		modifiedConsumer.includeObject(
+			&s3.Object{
+				Key:          aws.String("logs/key1"),
+				LastModified: aws.Time(since.Add(time.Minute)),
+			},
+		),
+	)
+	assert.False(
+		t,
+		modifiedConsumer.includeObject(
+			&s3.Object{
+				Key:          aws.String("logs/key2"),
+				LastModified: aws.Time(until.Add(time.Minute)),
+			},
+		),
+	)
+}
@@ -0,0 +1,343 @@
+package digger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	sjson "github.com/segmentio/encoding/json"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+)
+
+// File formats that can appear in an S3 inventory manifest
+const (
+	s3InventoryCSV     = "CSV"
+	s3InventoryORC     = "ORC"
+	s3InventoryParquet = "Parquet"
+)
+
+// s3InventoryManifest is the manifest.json file that S3 writes along with each inventory.
+type s3InventoryManifest struct {
+	SourceBucket      string `json:"sourceBucket"`
+	DestinationBucket string `json:"destinationBucket"`
+	FileFormat        string `json:"fileFormat"`
+	FileSchema        string `json:"fileSchema"`
+	Files             []struct {
+		Key  string `json:"key"`
+		Size int64  `json:"size"`
+	} `json:"files"`
+}
+
+// s3InventoryColumns stores the names of the inventory columns that are used to build the
+// object list. The names differ between the CSV and Parquet formats.
+type s3InventoryColumns struct {
+	key            string
+	size           string
+	lastModified   string
+	eTag           string
+	isLatest       string
+	isDeleteMarker string
+
+	// urlEncodedKeys is set if the keys need to be URL-decoded
+	urlEncodedKeys bool
+}
+
+var (
+	s3InventoryCSVColumns = s3InventoryColumns{
+		key:            "Key",
+		size:           "Size",
+		lastModified:   "LastModifiedDate",
+		eTag:           "ETag",
+		isLatest:       "IsLatest",
+		isDeleteMarker: "IsDeleteMarker",
+		urlEncodedKeys: true,
+	}
+	s3InventoryParquetColumns = s3InventoryColumns{
+		key:            "key",
+		size:           "size",
+		lastModified:   "last_modified_date",
+		eTag:           "e_tag",
+		isLatest:       "is_latest",
+		isDeleteMarker: "is_delete_marker",
+	}
+)
+
+func (c s3InventoryColumns) names() []string {
+	return []string{c.key, c.size, c.lastModified, c.eTag, c.isLatest, c.isDeleteMarker}
+}
+
+// processInventory reads the object list from the inventory manifest instead of listing the
+// prefixes. Objects are filtered by prefix and the same rules as listed objects.
+func (s *S3Consumer) processInventory(
+	ctx context.Context,
+	objectChan chan s3ObjTask,
+) error {
+	manifest, err := s.readInventoryManifest(ctx)
+	if err != nil {
+		return err
+	}
+
+	if manifest.SourceBucket != s.Bucket {
+		return fmt.Errorf(
+			"Inventory is for bucket %s, not %s",
+			manifest.SourceBucket,
+			s.Bucket,
+		)
+	}
+
+	inventoryBucket := strings.TrimPrefix(manifest.DestinationBucket, "arn:aws:s3:::")
+	keysRead := 0
+
+	for f, file := range manifest.Files {
+		log.Debugf(
+			"Reading inventory file %d/%d: %s",
+			f+1,
+			len(manifest.Files),
+			file.Key,
+		)
+
+		records, closer, err := s.openInventoryFile(
+			ctx,
+			manifest.FileFormat,
+			manifest.FileSchema,
+			inventoryBucket,
+			file.Key,
+			file.Size,
+		)
+		if err != nil {
+			return err
+		}
+
+		columns := s3InventoryCSVColumns
+		if manifest.FileFormat == s3InventoryParquet {
+			columns = s3InventoryParquetColumns
+		}
+
+		err = func() error {
+			defer closer.Close()
+
+			for {
+				contents, _, err := records.next()
+				if err == io.EOF {
+					return nil
+				} else if err != nil {
+					return err
+				}
+
+				objInfo, ok := inventoryObject(contents, columns)
+				if !ok || !s.hasPrefix(aws.StringValue(objInfo.Key)) ||
+					!s.includeObject(objInfo) {
+					continue
+				}
+
+				subTask := s3ObjTask{
+					objInfo: objInfo,
+					index:   keysRead,
+				}
+				select {
+				case objectChan <- subTask:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+				keysRead++
+			}
+		}()
+		if err != nil {
+			return fmt.Errorf("Error reading inventory file %s: %+v", file.Key, err)
+		}
+	}
+
+	log.Debugf("Read %d matching keys from inventory", keysRead)
+	return nil
+}
+
+func (s *S3Consumer) readInventoryManifest(ctx context.Context) (s3InventoryManifest, error) {
+	manifest := s3InventoryManifest{}
+
+	bucket, key, err := parseS3URL(s.InventoryManifest)
+	if err != nil {
+		return manifest, err
+	}
+
+	obj, err := s.S3Client.GetObjectWithContext(
+		ctx,
+		&s3.GetObjectInput{
+			Bucket:       aws.String(bucket),
+			Key:          aws.String(key),
+			RequestPayer: s.requestPayer(),
+		},
+	)
+	if err != nil {
+		return manifest, err
+	}
+	defer obj.Body.Close()
+
+	contents, err := io.ReadAll(obj.Body)
+	if err != nil {
+		return manifest, err
+	}
+	if err := sjson.Unmarshal(contents, &manifest); err != nil {
+		return manifest, fmt.Errorf("Error parsing inventory manifest: %+v", err)
+	}
+
+	return manifest, nil
+}
+
+// openInventoryFile returns a recordReader that converts the rows in the argument inventory
+// file to JSON objects, along with a closer for the underlying object.
+func (s *S3Consumer) openInventoryFile(
+	ctx context.Context,
+	format string,
+	schema string,
+	bucket string,
+	key string,
+	size int64,
+) (recordReader, io.Closer, error) {
+	switch format {
+	case s3InventoryCSV:
+		var contentEncoding *string
+		if strings.HasSuffix(key, ".gz") {
+			// Inventory files are gzipped, but the encoding isn't set on the objects
+			contentEncoding = aws.String("gzip")
+		}
+
+		obj, err := s.S3Client.GetObjectWithContext(
+			ctx,
+			&s3.GetObjectInput{
+				Bucket:                  aws.String(bucket),
+				Key:                     aws.String(key),
+				ResponseContentEncoding: contentEncoding,
+				RequestPayer:            s.requestPayer(),
+			},
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return newCSVReader(
+			obj.Body,
+			FormatCSV,
+			CSVOptions{Columns: inventoryCSVColumns(schema)},
+			DefaultMaxMessageSize,
+		), obj.Body, nil
+	case s3InventoryParquet:
+		records, err := newParquetReader(
+			&s3ObjectReader{
+				ctx:          ctx,
+				s3Client:     s.S3Client,
+				bucket:       bucket,
+				key:          key,
+				requestPayer: s.requestPayer(),
+			},
+			size,
+			s3InventoryParquetColumns.names(),
+			DefaultMaxMessageSize,
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return records, io.NopCloser(nil), nil
+	case s3InventoryORC:
+		return nil, nil, errors.New(
+			"ORC inventories aren't supported; please configure a CSV or Parquet inventory",
+		)
+	default:
+		return nil, nil, fmt.Errorf("Unrecognized inventory format: %s", format)
+	}
+}
+
+// inventoryCSVColumns returns the column names from the fileSchema of a CSV inventory, e.g.
+// "Bucket, Key, Size, LastModifiedDate".
+func inventoryCSVColumns(schema string) []string {
+	columns := strings.Split(schema, ",")
+	for c, column := range columns {
+		columns[c] = strings.TrimSpace(column)
+	}
+	return columns
+}
+
+// inventoryObject converts a JSON-encoded inventory row to an object. It returns false if the
+// row is for a delete marker or a non-current version, since these can't be read by key alone.
+func inventoryObject(contents []byte, columns s3InventoryColumns) (*s3.Object, bool) {
+	results := gjson.GetManyBytes(
+		contents,
+		columns.key,
+		columns.size,
+		columns.lastModified,
+		columns.eTag,
+		columns.isLatest,
+		columns.isDeleteMarker,
+	)
+	keyResult, sizeResult, lastModifiedResult, eTagResult, isLatestResult, isDeleteMarkerResult :=
+		results[0], results[1], results[2], results[3], results[4], results[5]
+
+	if !keyResult.Exists() || isDeleteMarkerResult.Bool() ||
+		(isLatestResult.Exists() && isLatestResult.Type != gjson.Null && !isLatestResult.Bool()) {
+		return nil, false
+	}
+
+	key := keyResult.String()
+	if columns.urlEncodedKeys {
+		unescaped, err := url.QueryUnescape(key)
+		if err != nil {
+			log.Debugf("Could not unescape inventory key %s: %+v", key, err)
+			return nil, false
+		}
+		key = unescaped
+	}
+
+	objInfo := &s3.Object{
+		Key:  aws.String(key),
+		Size: aws.Int64(sizeResult.Int()),
+	}
+
+	if eTagResult.String() != "" {
+		objInfo.ETag = aws.String(eTagResult.String())
+	}
+
+	switch lastModifiedResult.Type {
+	case gjson.Number:
+		// Parquet timestamps are in milliseconds
+		objInfo.LastModified = aws.Time(time.UnixMilli(lastModifiedResult.Int()).UTC())
+	case gjson.String:
+		lastModified, err := time.Parse(time.RFC3339, lastModifiedResult.String())
+		if err == nil {
+			objInfo.LastModified = aws.Time(lastModified)
+		}
+	}
+
+	return objInfo, true
+}
+
+// hasPrefix returns whether the argument key starts with any of this consumer's prefixes.
+func (s *S3Consumer) hasPrefix(key string) bool {
+	if len(s.Prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range s.Prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseS3URL splits an s3://bucket/key URL into its bucket and key.
+func parseS3URL(s3URL string) (string, string, error) {
+	parsed, err := url.Parse(s3URL)
+	if err != nil {
+		return "", "", err
+	}
+	if parsed.Scheme != "s3" || parsed.Host == "" || len(parsed.Path) <= 1 {
+		return "", "", fmt.Errorf("Invalid S3 URL (expected s3://bucket/key): %s", s3URL)
+	}
+	return parsed.Host, parsed.Path[1:], nil
+}
@@ -0,0 +1,163 @@
+package digger
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/parquet-go/parquet-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseS3URL(t *testing.T) {
+	bucket, key, err := parseS3URL("s3://inventory-bucket/src/config/2024-05-01T01-00Z/manifest.json")
+	require.NoError(t, err)
+	assert.Equal(t, "inventory-bucket", bucket)
+	assert.Equal(t, "src/config/2024-05-01T01-00Z/manifest.json", key)
+
+	_, _, err = parseS3URL("https://inventory-bucket/manifest.json")
+	assert.Error(t, err)
+	_, _, err = parseS3URL("s3://inventory-bucket/")
+	assert.Error(t, err)
+}
+
+func TestOpenInventoryFileORC(t *testing.T) {
+	consumer := &S3Consumer{}
+
+	_, _, err := consumer.openInventoryFile(
+		context.Background(),
+		s3InventoryORC,
+		"",
+		"inventory-bucket",
+		"data/inventory.orc",
+		100,
+	)
+	assert.ErrorContains(t, err, "ORC inventories aren't supported")
+}
+
+func TestInventoryObjectsCSV(t *testing.T) {
+	contents := strings.Join(
+		[]string{
+			`"src","logs/key%201","1024","2024-05-01T12:30:00.000Z","abc123","true","false"`,
+			`"src","logs/key2","","2024-05-01T12:31:00.000Z","","true","true"`,
+			`"src","logs/key3","2048","2024-05-01T12:32:00.000Z","def456","false","false"`,
+		},
+		"\n",
+	)
+
+	records := newCSVReader(
+		strings.NewReader(contents),
+		FormatCSV,
+		CSVOptions{
+			Columns: inventoryCSVColumns(
+				"Bucket, Key, Size, LastModifiedDate, ETag, IsLatest, IsDeleteMarker",
+			),
+		},
+		0,
+	)
+
+	objInfos := []*s3.Object{}
+	for {
+		contents, _, err := records.next()
+		if err != nil {
+			break
+		}
+		if objInfo, ok := inventoryObject(contents, s3InventoryCSVColumns); ok {
+			objInfos = append(objInfos, objInfo)
+		}
+	}
+
+	assert.Equal(
+		t,
+		[]*s3.Object{
+			{
+				Key:          aws.String("logs/key 1"),
+				Size:         aws.Int64(1024),
+				LastModified: aws.Time(time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)),
+				ETag:         aws.String("abc123"),
+			},
+		},
+		objInfos,
+	)
+}
+
+func TestInventoryObjectsParquet(t *testing.T) {
+	type inventoryRow struct {
+		Bucket           string    `parquet:"bucket"`
+		Key              string    `parquet:"key"`
+		Size             *int64    `parquet:"size,optional"`
+		LastModifiedDate time.Time `parquet:"last_modified_date,timestamp(millisecond)"`
+		ETag             string    `parquet:"e_tag,optional"`
+		StorageClass     string    `parquet:"storage_class,optional"`
+	}
+
+	lastModified := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	buf := &bytes.Buffer{}
+	err := parquet.Write(
+		buf,
+		[]inventoryRow{
+			{
+				Bucket:           "src",
+				Key:              "logs/key 1",
+				Size:             aws.Int64(1024),
+				LastModifiedDate: lastModified,
+				ETag:             "abc123",
+				StorageClass:     "STANDARD",
+			},
+		},
+	)
+	require.NoError(t, err)
+
+	records, err := newParquetReader(
+		bytes.NewReader(buf.Bytes()),
+		int64(buf.Len()),
+		s3InventoryParquetColumns.names(),
+		0,
+	)
+	require.NoError(t, err)
+
+	contents, _, err := records.next()
+	require.NoError(t, err)
+
+	objInfo, ok := inventoryObject(contents, s3InventoryParquetColumns)
+	require.True(t, ok)
+	assert.Equal(
+		t,
+		&s3.Object{
+			Key:          aws.String("logs/key 1"),
+			Size:         aws.Int64(1024),
+			LastModified: aws.Time(lastModified),
+			ETag:         aws.String("abc123"),
+		},
+		objInfo,
+	)
+}
+
+func TestS3ConsumerInventoryFilters(t *testing.T) {
+	consumer := S3Consumer{
+		Prefixes: []string{"logs/", "other/"},
+		MinSize:  100,
+		MaxSize:  1000,
+	}
+
+	assert.True(t, consumer.hasPrefix("logs/key1"))
+	assert.False(t, consumer.hasPrefix("tmp/key1"))
+
+	assert.True(
+		t,
+		consumer.includeObject(&s3.Object{Key: aws.String("logs/key1"), Size: aws.Int64(500)}),
+	)
+	assert.False(
+		t,
+		consumer.includeObject(&s3.Object{Key: aws.String("logs/key1"), Size: aws.Int64(50)}),
+	)
+	assert.False(
+		t,
+		consumer.includeObject(&s3.Object{Key: aws.String("logs/key1"), Size: aws.Int64(5000)}),
+	)
+}
@@ -0,0 +1,485 @@
+package digger
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	log "github.com/sirupsen/logrus"
+)
+
+// S3ObjectStats stores aggregate stats about a set of S3 objects. The s3 source only decompresses
+// gzip objects (the ones with .gz keys), so those are the only ones that are counted separately.
+type S3ObjectStats struct {
+	Objects       int64     `json:"objects"`
+	TotalSize     int64     `json:"totalSize"`
+	GzipObjects   int64     `json:"gzipObjects"`
+	GzipSize      int64     `json:"gzipSize"`
+	FirstModified time.Time `json:"firstModified"`
+	LastModified  time.Time `json:"lastModified"`
+}
+
+// S3DirectoryStats stores the stats for the objects under a single "directory" in S3.
+type S3DirectoryStats struct {
+	S3ObjectStats
+	Directory string `json:"directory"`
+}
+
+// S3ListSummary summarizes the objects that an S3Consumer would read.
+type S3ListSummary struct {
+	S3ObjectStats
+	Directories []S3DirectoryStats `json:"directories"`
+
+	// SampledBytes is the number of bytes that were read from the newest objects to sample the
+	// read throughput; it's zero if throughput wasn't sampled.
+	SampledBytes int64 `json:"sampledBytes,omitempty"`
+
+	// ReadBytesPerSec is the sampled read throughput across all workers, in object bytes.
+	ReadBytesPerSec float64 `json:"readBytesPerSec,omitempty"`
+
+	// DecompressionRatio is the ratio of the decompressed size of the sample to its size in the
+	// objects. It's 1 if throughput wasn't sampled.
+	DecompressionRatio float64 `json:"decompressionRatio"`
+
+	// ProcessBytesPerSec is the rate at which the decompressed input is expected to be
+	// processed.
+	ProcessBytesPerSec float64 `json:"processBytesPerSec"`
+
+	// EstimatedScanTime is the estimated time to scan all of the objects. Reading and processing
+	// happen at the same time, so it's the longer of the time to read the objects at the sampled
+	// throughput and the time to process their decompressed contents.
+	EstimatedScanTime time.Duration `json:"estimatedScanTimeNs"`
+}
+
+// newestObjects is a min-heap of S3 objects by their modification times. It's used to keep the
+// newest objects in a listing without keeping all of them.
+type newestObjects []*s3.Object
+
+func (n newestObjects) Len() int { return len(n) }
+
+func (n newestObjects) Less(i, j int) bool {
+	return aws.TimeValue(n[i].LastModified).Before(aws.TimeValue(n[j].LastModified))
+}
+
+func (n newestObjects) Swap(i, j int) { n[i], n[j] = n[j], n[i] }
+
+func (n *newestObjects) Push(x interface{}) { *n = append(*n, x.(*s3.Object)) }
+
+func (n *newestObjects) Pop() interface{} {
+	old := *n
+	objInfo := old[len(old)-1]
+	*n = old[0 : len(old)-1]
+	return objInfo
+}
+
+// add adds an object to the heap, dropping the oldest one if there are more than limit.
+func (n *newestObjects) add(objInfo *s3.Object, limit int) {
+	if limit <= 0 {
+		return
+	}
+	if n.Len() < limit {
+		heap.Push(n, objInfo)
+		return
+	}
+	if aws.TimeValue(objInfo.LastModified).After(aws.TimeValue((*n)[0].LastModified)) {
+		(*n)[0] = objInfo
+		heap.Fix(n, 0)
+	}
+}
+
+func (s *S3ObjectStats) add(objInfo *s3.Object) {
+	size := aws.Int64Value(objInfo.Size)
+	modified := aws.TimeValue(objInfo.LastModified)
+
+	s.Objects++
+	s.TotalSize += size
+
+	if strings.HasSuffix(aws.StringValue(objInfo.Key), ".gz") {
+		s.GzipObjects++
+		s.GzipSize += size
+	}
+
+	if s.FirstModified.IsZero() || modified.Before(s.FirstModified) {
+		s.FirstModified = modified
+	}
+	if s.LastModified.IsZero() || modified.After(s.LastModified) {
+		s.LastModified = modified
+	}
+}
+
+// List summarizes the objects that this consumer would read, using the same prefixes and
+// include/exclude rules as Run. The objects are rolled up by "directory", i.e. the path
+// components up to the argument depth below the prefix that they were listed under.
+//
+// The summary includes an estimate of the time it would take to scan all of the objects, based
+// on the argument processing rate in decompressed bytes per second. If sampleBytes is positive,
+// then up to that many bytes are also read from the newest objects to measure the read
+// throughput and how much the objects grow when they're decompressed.
+func (s *S3Consumer) List(
+	ctx context.Context,
+	depth int,
+	sampleBytes int64,
+	processBytesPerSec float64,
+) (S3ListSummary, error) {
+	if processBytesPerSec <= 0 {
+		return S3ListSummary{}, errors.New("Processing rate must be positive")
+	}
+
+	summary := S3ListSummary{
+		DecompressionRatio: 1,
+		ProcessBytesPerSec: processBytesPerSec,
+	}
+	directories := map[string]*S3DirectoryStats{}
+
+	// Only the newest objects are kept since they're the ones that throughput is sampled from
+	numSampled := 0
+	if sampleBytes > 0 {
+		numSampled = s.NumWorkers
+		if numSampled <= 0 {
+			numSampled = 1
+		}
+	}
+	sampled := &newestObjects{}
+
+	objectChan := make(chan s3ObjTask, s.NumWorkers)
+	errChan := make(chan error, 1)
+
+	go func() {
+		errChan <- s.processPrefixes(ctx, objectChan)
+		close(objectChan)
+	}()
+
+	for subTask := range objectChan {
+		key := aws.StringValue(subTask.objInfo.Key)
+		directory := s3Directory(key, s.matchingPrefix(key), depth)
+
+		directoryStats, ok := directories[directory]
+		if !ok {
+			directoryStats = &S3DirectoryStats{Directory: directory}
+			directories[directory] = directoryStats
+		}
+
+		summary.add(subTask.objInfo)
+		directoryStats.add(subTask.objInfo)
+		sampled.add(subTask.objInfo, numSampled)
+
+		if summary.Objects%10000 == 0 {
+			log.Infof("Listed %d objects", summary.Objects)
+		}
+	}
+
+	if err := <-errChan; err != nil {
+		return summary, err
+	}
+
+	for _, directoryStats := range directories {
+		summary.Directories = append(summary.Directories, *directoryStats)
+	}
+	sort.Slice(summary.Directories, func(a, b int) bool {
+		return summary.Directories[a].Directory < summary.Directories[b].Directory
+	})
+
+	if sampleBytes > 0 && summary.TotalSize > 0 {
+		// Read the newest objects first
+		sort.Slice(*sampled, func(a, b int) bool { return sampled.Less(b, a) })
+
+		sample, err := s.sampleThroughput(ctx, *sampled, sampleBytes)
+		if err != nil {
+			return summary, err
+		}
+
+		if sample.bytesPerSec > 0 {
+			summary.SampledBytes = sample.bytes
+			summary.ReadBytesPerSec = sample.bytesPerSec
+			summary.DecompressionRatio = float64(sample.decompressedBytes) / float64(sample.bytes)
+		}
+	}
+
+	processSeconds := float64(summary.TotalSize) * summary.DecompressionRatio /
+		summary.ProcessBytesPerSec
+	scanSeconds := processSeconds
+	if summary.ReadBytesPerSec > 0 {
+		scanSeconds = max(scanSeconds, float64(summary.TotalSize)/summary.ReadBytesPerSec)
+	}
+	summary.EstimatedScanTime = time.Duration(scanSeconds * float64(time.Second))
+
+	return summary, nil
+}
+
+// matchingPrefix returns the longest prefix of this consumer that the argument key starts
+// with.
+func (s *S3Consumer) matchingPrefix(key string) string {
+	matching := ""
+
+	for _, prefix := range s.Prefixes {
+		if strings.HasPrefix(key, prefix) && len(prefix) > len(matching) {
+			matching = prefix
+		}
+	}
+
+	return matching
+}
+
+// s3Directory returns the "directory" of the argument key, i.e. the argument prefix plus up to
+// depth path components after it. The last component of the key (the object name) is never
+// included.
+func s3Directory(key string, prefix string, depth int) string {
+	directory := key[0 : strings.LastIndex(key, "/")+1]
+	if len(directory) <= len(prefix) {
+		return directory
+	}
+
+	// Start after the last slash in the prefix so that partial prefixes like "logs/dt=2024"
+	// are rolled up by their full components
+	start := strings.LastIndex(prefix, "/") + 1
+	remainder := directory[start:]
+	components := strings.SplitAfter(remainder, "/")
+
+	if depth < len(components) {
+		components = components[0:depth]
+	}
+
+	return directory[0:start] + strings.Join(components, "")
+}
+
+// s3Sample is the result of reading a sample of the newest objects.
+type s3Sample struct {
+	bytes             int64
+	decompressedBytes int64
+	bytesPerSec       float64
+}
+
+// sampleThroughput reads up to sampleBytes from the argument objects, with one worker per
+// object, and returns the number of bytes read, their decompressed size, and the overall read
+// throughput in bytes per second. The newest objects are used since they're most representative
+// of what's being written now.
+func (s *S3Consumer) sampleThroughput(
+	ctx context.Context,
+	sampled []*s3.Object,
+	sampleBytes int64,
+) (s3Sample, error) {
+	numWorkers := len(sampled)
+	if numWorkers == 0 {
+		return s3Sample{}, nil
+	}
+
+	bytesPerWorker := sampleBytes / int64(numWorkers)
+	if bytesPerWorker == 0 {
+		bytesPerWorker = 1
+	}
+
+	bytesRead := make([]int64, numWorkers)
+	decompressed := make([]int64, numWorkers)
+	errs := make([]error, numWorkers)
+	wg := sync.WaitGroup{}
+	start := time.Now()
+
+	for i, objInfo := range sampled {
+		wg.Add(1)
+		go func(i int, objInfo *s3.Object) {
+			defer wg.Done()
+			bytesRead[i], decompressed[i], errs[i] = s.readSample(ctx, objInfo, bytesPerWorker)
+		}(i, objInfo)
+	}
+
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	sample := s3Sample{}
+	for i := range sampled {
+		if errs[i] != nil {
+			return s3Sample{}, errs[i]
+		}
+		sample.bytes += bytesRead[i]
+		sample.decompressedBytes += decompressed[i]
+	}
+
+	log.Debugf(
+		"Sampled %d bytes (%d decompressed) in %s",
+		sample.bytes,
+		sample.decompressedBytes,
+		elapsed,
+	)
+
+	if elapsed > 0 && sample.bytes > 0 {
+		sample.bytesPerSec = float64(sample.bytes) / elapsed.Seconds()
+	}
+	return sample, nil
+}
+
+// readSample reads up to maxBytes from the start of the argument object and returns the number
+// of bytes read and their decompressed size. Gzip objects are decompressed as far as the sample
+// goes; other objects are assumed to be uncompressed.
+func (s *S3Consumer) readSample(
+	ctx context.Context,
+	objInfo *s3.Object,
+	maxBytes int64,
+) (int64, int64, error) {
+	size := aws.Int64Value(objInfo.Size)
+	if size == 0 {
+		return 0, 0, nil
+	}
+	if size < maxBytes {
+		maxBytes = size
+	}
+
+	obj, err := s.S3Client.GetObjectWithContext(
+		ctx,
+		&s3.GetObjectInput{
+			Bucket:       aws.String(s.Bucket),
+			Key:          objInfo.Key,
+			Range:        aws.String(fmt.Sprintf("bytes=0-%d", maxBytes-1)),
+			RequestPayer: s.requestPayer(),
+		},
+	)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer obj.Body.Close()
+
+	return sampleSizes(obj.Body)
+}
+
+// sampleSizes reads all of the argument sample and returns its size and its decompressed size.
+// Gzip samples are decompressed as far as they go; others are assumed to be uncompressed.
+func sampleSizes(reader io.Reader) (int64, int64, error) {
+	body := &countingReader{reader: reader}
+	buffered := bufio.NewReader(body)
+
+	var decompressed int64
+	if magic, _ := buffered.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		if gzipReader, err := gzip.NewReader(buffered); err == nil {
+			decompressed, err = io.Copy(io.Discard, gzipReader)
+
+			// The sample usually ends partway through the stream
+			if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+				log.Debugf("Error decompressing sample: %+v", err)
+			}
+		}
+	}
+
+	// Read anything that wasn't decompressed
+	remaining, err := io.Copy(io.Discard, buffered)
+	if err != nil {
+		return 0, 0, err
+	}
+	if decompressed == 0 {
+		decompressed = remaining
+	}
+
+	return body.count, decompressed, nil
+}
+
+// countingReader is an io.Reader that counts the bytes that are read through it.
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.count += int64(n)
+	return n, err
+}
+
+// PrettyTable returns pretty tables of the overall and per-directory stats in this summary.
+func (s S3ListSummary) PrettyTable() string {
+	buf := &bytes.Buffer{}
+
+	table := newInfoTable(
+		buf,
+		[]string{
+			"Directory",
+			"Objects",
+			"Total Size",
+			"Gzip Size",
+			"First Modified",
+			"Last Modified",
+		},
+	)
+
+	for _, directory := range s.Directories {
+		table.Append(objectStatsRow(directory.Directory, directory.S3ObjectStats))
+	}
+	table.SetFooter(objectStatsRow("Total", s.S3ObjectStats))
+	table.Render()
+
+	if s.TotalSize > 0 {
+		fmt.Fprintf(
+			buf,
+			"Estimated scan time: %s, processing %s/sec of decompressed input\n",
+			s.EstimatedScanTime.Round(time.Second),
+			formatBytes(int64(s.ProcessBytesPerSec)),
+		)
+
+		if s.ReadBytesPerSec > 0 {
+			fmt.Fprintf(
+				buf,
+				"Read throughput, based on a %s sample of the newest objects: %s/sec (%.1fx larger when decompressed)\n",
+				formatBytes(s.SampledBytes),
+				formatBytes(int64(s.ReadBytesPerSec)),
+				s.DecompressionRatio,
+			)
+		} else if s.GzipObjects > 0 {
+			fmt.Fprintln(
+				buf,
+				"Gzip objects are counted at their compressed sizes; set --sample-bytes to measure how much larger they are",
+			)
+		}
+	}
+
+	return buf.String()
+}
+
+func objectStatsRow(name string, stats S3ObjectStats) []string {
+	row := []string{
+		name,
+		fmt.Sprintf("%d", stats.Objects),
+		formatBytes(stats.TotalSize),
+		fmt.Sprintf(
+			"%s (%d objects)",
+			formatBytes(stats.GzipSize),
+			stats.GzipObjects,
+		),
+	}
+
+	if stats.Objects > 0 {
+		row = append(
+			row,
+			stats.FirstModified.UTC().Format(time.RFC3339),
+			stats.LastModified.UTC().Format(time.RFC3339),
+		)
+	} else {
+		row = append(row, "", "")
+	}
+
+	return row
+}
+
+// formatBytes formats a byte count with a binary unit suffix, e.g. 1.5 MiB.
+func formatBytes(size int64) string {
+	const unit = 1024
+
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
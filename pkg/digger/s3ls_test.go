@@ -0,0 +1,224 @@
+package digger
+
+import (
+	"bytes"
+	"compress/gzip"
+	"container/heap"
+	"context"
+	"fmt"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestS3Directory(t *testing.T) {
+	type testCase struct {
+		key      string
+		prefix   string
+		depth    int
+		expected string
+	}
+
+	testCases := []testCase{
+		{
+			key:      "logs/dt=2024-05-01/hour=13/obj.gz",
+			prefix:   "logs/",
+			depth:    1,
+			expected: "logs/dt=2024-05-01/",
+		},
+		{
+			key:      "logs/dt=2024-05-01/hour=13/obj.gz",
+			prefix:   "logs/",
+			depth:    2,
+			expected: "logs/dt=2024-05-01/hour=13/",
+		},
+		{
+			key:      "logs/dt=2024-05-01/hour=13/obj.gz",
+			prefix:   "logs/",
+			depth:    5,
+			expected: "logs/dt=2024-05-01/hour=13/",
+		},
+		{
+			key:      "logs/dt=2024-05-01/hour=13/obj.gz",
+			prefix:   "logs/dt=2024-05",
+			depth:    1,
+			expected: "logs/dt=2024-05-01/",
+		},
+		{
+			key:      "logs/dt=2024-05-01/hour=13/obj.gz",
+			prefix:   "logs/",
+			depth:    0,
+			expected: "logs/",
+		},
+		{
+			key:      "logs/obj.gz",
+			prefix:   "logs/",
+			depth:    1,
+			expected: "logs/",
+		},
+		{
+			key:      "obj.gz",
+			prefix:   "",
+			depth:    1,
+			expected: "",
+		},
+	}
+
+	for _, testCase := range testCases {
+		assert.Equal(
+			t,
+			testCase.expected,
+			s3Directory(testCase.key, testCase.prefix, testCase.depth),
+			testCase,
+		)
+	}
+}
+
+func TestS3ObjectStats(t *testing.T) {
+	stats := S3ObjectStats{}
+
+	stats.add(
+		&s3.Object{
+			Key:          aws.String("prefix/key1.gz"),
+			Size:         aws.Int64(100),
+			LastModified: aws.Time(time.Unix(2000, 0)),
+		},
+	)
+	stats.add(
+		&s3.Object{
+			Key:          aws.String("prefix/key2"),
+			Size:         aws.Int64(50),
+			LastModified: aws.Time(time.Unix(1000, 0)),
+		},
+	)
+
+	assert.Equal(
+		t,
+		S3ObjectStats{
+			Objects:       2,
+			TotalSize:     150,
+			GzipObjects:   1,
+			GzipSize:      100,
+			FirstModified: time.Unix(1000, 0),
+			LastModified:  time.Unix(2000, 0),
+		},
+		stats,
+	)
+}
+
+func TestFormatBytes(t *testing.T) {
+	assert.Equal(t, "10 B", formatBytes(10))
+	assert.Equal(t, "1.5 KiB", formatBytes(1536))
+	assert.Equal(t, "2.0 GiB", formatBytes(2*1024*1024*1024))
+}
+
+func TestNewestObjects(t *testing.T) {
+	base := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	newest := &newestObjects{}
+
+	for _, hour := range []int{3, 1, 4, 1, 5, 9, 2, 6} {
+		newest.add(
+			&s3.Object{
+				Key:          aws.String(fmt.Sprintf("key%d", hour)),
+				LastModified: aws.Time(base.Add(time.Duration(hour) * time.Hour)),
+			},
+			3,
+		)
+	}
+
+	keys := []string{}
+	for newest.Len() > 0 {
+		keys = append(keys, aws.StringValue(heap.Pop(newest).(*s3.Object).Key))
+	}
+	assert.Equal(t, []string{"key5", "key6", "key9"}, keys)
+
+	newest.add(&s3.Object{Key: aws.String("key"), LastModified: aws.Time(base)}, 0)
+	assert.Equal(t, 0, newest.Len())
+}
+
+func TestS3ConsumerList(t *testing.T) {
+	ctx := context.Background()
+	s3Client := newTestS3Client()
+
+	testBucket := createBucket(ctx, t, s3Client)
+
+	writeKey(ctx, t, s3Client, testBucket, "test-prefix/dir1/key1", "value1\nvalue2")
+	writeKey(ctx, t, s3Client, testBucket, "test-prefix/dir1/key2", "value3")
+	writeKey(ctx, t, s3Client, testBucket, "test-prefix/dir2/key3.gz", "value4")
+	writeKey(ctx, t, s3Client, testBucket, "test-prefix/dir2/key4.tmp", "value5")
+
+	consumer := S3Consumer{
+		S3Client:   s3Client,
+		Bucket:     testBucket,
+		Prefixes:   []string{"test-prefix/"},
+		NumWorkers: 2,
+		Exclude:    regexp.MustCompile(`\.tmp$`),
+	}
+
+	summary, err := consumer.List(ctx, 1, 1024, 10)
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(3), summary.Objects)
+	assert.Equal(t, int64(25), summary.TotalSize)
+	assert.Equal(t, int64(1), summary.GzipObjects)
+	require.Equal(t, 2, len(summary.Directories))
+	assert.Equal(t, "test-prefix/dir1/", summary.Directories[0].Directory)
+	assert.Equal(t, int64(2), summary.Directories[0].Objects)
+	assert.Equal(t, "test-prefix/dir2/", summary.Directories[1].Directory)
+	assert.Greater(t, summary.ReadBytesPerSec, 0.0)
+	assert.Equal(t, int64(25), summary.SampledBytes)
+
+	// The test objects aren't actually compressed
+	assert.Equal(t, 1.0, summary.DecompressionRatio)
+	assert.GreaterOrEqual(t, summary.EstimatedScanTime, 2500*time.Millisecond)
+	assert.Contains(t, summary.PrettyTable(), "Read throughput")
+
+	// Without sampling, the estimate is only based on the processing rate
+	summary, err = consumer.List(ctx, 1, 0, 10)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), summary.Objects)
+	assert.Equal(t, 0.0, summary.ReadBytesPerSec)
+	assert.Equal(t, int64(0), summary.SampledBytes)
+	assert.Equal(t, 2500*time.Millisecond, summary.EstimatedScanTime)
+	assert.Contains(t, summary.PrettyTable(), "Estimated scan time: 3s")
+	assert.Contains(t, summary.PrettyTable(), "set --sample-bytes")
+
+	_, err = consumer.List(ctx, 1, 0, 0)
+	assert.Error(t, err)
+}
+
+func TestSampleSizes(t *testing.T) {
+	uncompressed := &bytes.Buffer{}
+	for i := 0; i < 20000; i++ {
+		fmt.Fprintf(uncompressed, `{"id":%d,"type":"track"}`+"\n", i*7919%100000)
+	}
+
+	compressed := &bytes.Buffer{}
+	gzipWriter := gzip.NewWriter(compressed)
+	_, err := gzipWriter.Write(uncompressed.Bytes())
+	require.NoError(t, err)
+	require.NoError(t, gzipWriter.Close())
+
+	read, decompressed, err := sampleSizes(bytes.NewReader(uncompressed.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, int64(uncompressed.Len()), read)
+	assert.Equal(t, int64(uncompressed.Len()), decompressed)
+
+	read, decompressed, err = sampleSizes(bytes.NewReader(compressed.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, int64(compressed.Len()), read)
+	assert.Equal(t, int64(uncompressed.Len()), decompressed)
+
+	// A sample that ends partway through the stream is decompressed as far as it goes
+	half := compressed.Bytes()[0 : compressed.Len()/2]
+	read, decompressed, err = sampleSizes(bytes.NewReader(half))
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(half)), read)
+	assert.Greater(t, decompressed, int64(uncompressed.Len()/4))
+	assert.Less(t, decompressed, int64(uncompressed.Len()))
+}
@@ -0,0 +1,84 @@
+package digger
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/segmentio/data-digger/pkg/json"
+	"github.com/segmentio/data-digger/pkg/message"
+	"github.com/segmentio/data-digger/pkg/stats"
+)
+
+// sampler decides which messages are kept when only a fraction of them should be processed.
+//
+// The decisions are based on hashes instead of random numbers so that they're repeatable, e.g.
+// when a checkpointed dig is resumed or the same topic is dug again. By default, each message is
+// hashed by its position (source, partition, and offset). If a path is set, then messages are
+// hashed by the value at that path instead, so that all of the messages with the same value
+// (e.g., all of the events for a user) are either kept or dropped together.
+type sampler struct {
+	rate      float64
+	path      string
+	threshold uint64
+}
+
+// newSampler returns a sampler for the argument rate and (optional) path. It returns nil if the
+// rate is 1, since all messages are kept in that case.
+func newSampler(rate float64, path string) (*sampler, error) {
+	if rate <= 0 || rate > 1 || math.IsNaN(rate) {
+		return nil, fmt.Errorf("Sample rate must be greater than 0 and at most 1: %f", rate)
+	}
+	if rate == 1 {
+		if path != "" {
+			return nil, errors.New("Sampling by a path requires a sample rate below 1")
+		}
+		return nil, nil
+	}
+
+	return &sampler{
+		rate:      rate,
+		path:      path,
+		threshold: uint64(rate * math.MaxUint64),
+	}, nil
+}
+
+// byPosition returns whether this sampler decides which messages to keep based on their
+// positions, which can be done before they're decoded.
+func (s *sampler) byPosition() bool {
+	return s.path == ""
+}
+
+// String returns a description of this sampler for the progress display and summary.
+func (s *sampler) String() string {
+	percent := strconv.FormatFloat(s.rate*100, 'g', -1, 64)
+	if s.byPosition() {
+		return fmt.Sprintf("%s%% of messages", percent)
+	}
+	return fmt.Sprintf("%s%% of %s values", percent, s.path)
+}
+
+// keepPosition returns whether the argument message should be kept based on its position.
+func (s *sampler) keepPosition(msg message.Message) bool {
+	hash := stats.HashString(stats.FNVOffset64, msg.Source)
+	hash = stats.MixHash(hash ^ uint64(msg.Partition))
+	hash = stats.MixHash(hash ^ uint64(msg.Offset))
+	return hash < s.threshold
+}
+
+// keepValue returns whether the argument decoded message should be kept based on the value at
+// this sampler's path. Messages where the path is missing are treated as if they all had the
+// same value.
+func (s *sampler) keepValue(decodedMsg []byte, meta []byte) bool {
+	value := stats.MissingValue
+
+	if decodedMsg != nil {
+		result := json.PathResult(decodedMsg, meta, s.path)
+		if result.Exists() {
+			value = result.String()
+		}
+	}
+
+	return stats.MixHash(stats.HashString(stats.FNVOffset64, value)) < s.threshold
+}
@@ -0,0 +1,158 @@
+package digger
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/segmentio/data-digger/pkg/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSampler(t *testing.T) {
+	type testCase struct {
+		rate     float64
+		path     string
+		expected bool
+		err      bool
+	}
+
+	testCases := []testCase{
+		{
+			rate:     0.01,
+			expected: true,
+		},
+		{
+			rate:     0.5,
+			path:     "userId",
+			expected: true,
+		},
+		{
+			rate: 1.0,
+		},
+		{
+			rate: 1.0,
+			path: "userId",
+			err:  true,
+		},
+		{
+			rate: 0,
+			err:  true,
+		},
+		{
+			rate: -0.5,
+			err:  true,
+		},
+		{
+			rate: 1.5,
+			err:  true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		sampler, err := newSampler(testCase.rate, testCase.path)
+		if testCase.err {
+			assert.Error(t, err, testCase)
+		} else {
+			require.NoError(t, err, testCase)
+			assert.Equal(t, testCase.expected, sampler != nil, testCase)
+		}
+	}
+}
+
+func TestSamplerPosition(t *testing.T) {
+	sampler, err := newSampler(0.1, "")
+	require.NoError(t, err)
+	assert.Equal(t, "10% of messages", sampler.String())
+
+	kept := 0
+	for offset := int64(0); offset < 10000; offset++ {
+		msg := message.Message{Source: "topic", Partition: 3, Offset: offset}
+		keep := sampler.keepPosition(msg)
+		if keep {
+			kept++
+		}
+
+		// Decisions are repeatable
+		assert.Equal(t, keep, sampler.keepPosition(msg))
+	}
+
+	assert.InDelta(t, 1000, kept, 100)
+}
+
+func TestSamplerValue(t *testing.T) {
+	sampler, err := newSampler(0.2, "userId")
+	require.NoError(t, err)
+	assert.Equal(t, "20% of userId values", sampler.String())
+
+	kept := 0
+	for i := 0; i < 5000; i++ {
+		user := fmt.Sprintf("user%d", i)
+		keep := sampler.keepValue([]byte(fmt.Sprintf(`{"userId": "%s", "n": 1}`, user)), nil)
+		if keep {
+			kept++
+		}
+
+		// All of the messages for a user get the same decision
+		assert.Equal(
+			t,
+			keep,
+			sampler.keepValue([]byte(fmt.Sprintf(`{"n": 2, "userId": "%s"}`, user)), nil),
+		)
+	}
+
+	assert.InDelta(t, 1000, kept, 100)
+
+	// Messages that are missing the path are all treated the same way
+	assert.Equal(
+		t,
+		sampler.keepValue([]byte(`{"n": 1}`), nil),
+		sampler.keepValue(nil, nil),
+	)
+}
+
+func TestLiveStatsSampleBy(t *testing.T) {
+	ctx := context.Background()
+	numUsers := 200
+
+	liveStats, err := NewLiveStats(
+		LiveStatsConfig{
+			K:          numUsers,
+			PathsStr:   "userId",
+			SampleBy:   "userId",
+			SampleRate: 0.5,
+		},
+	)
+	require.NoError(t, err)
+
+	for i := 0; i < numUsers; i++ {
+		for j := 0; j < 3; j++ {
+			err := liveStats.Process(
+				ctx,
+				message.Message{
+					Offset: int64(i*3 + j),
+					Value:  []byte(fmt.Sprintf(`{"userId": "user%d", "event": %d}`, i, j)),
+				},
+			)
+			require.NoError(t, err)
+		}
+	}
+
+	err = liveStats.Stop()
+	require.NoError(t, err)
+
+	// Every user that's sampled has all of their events counted
+	buckets := liveStats.topKCounter().Buckets(numUsers, false)
+	require.NotEmpty(t, buckets)
+	for _, bucket := range buckets {
+		assert.Equal(t, 3, bucket.Count, bucket.Key)
+	}
+
+	summary := liveStats.messageCounter().Summary()
+	assert.Equal(t, int64(numUsers*3), summary.TotalMessages)
+	assert.Equal(t, int64(liveStats.topKCounter().Summary().TotalAdded), summary.PostFilterMessages)
+
+	assert.Contains(t, liveStats.Summary(), "sampled 50% of userId values")
+	assert.Contains(t, liveStats.Summary(), "ESTIMATED COUNT")
+}
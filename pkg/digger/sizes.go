@@ -0,0 +1,396 @@
+package digger
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/segmentio/data-digger/pkg/message"
+	"github.com/segmentio/data-digger/pkg/stats"
+)
+
+// Percentiles that are shown in the size summary
+var sizePercentiles = []float64{50, 90, 99}
+
+// sizeStatsConfig stores the inputs for a sizeStats.
+type sizeStatsConfig struct {
+	K        int
+	Largest  int
+	PathsStr string
+	Weight   string
+}
+
+// sizeStats keeps track of the sizes of messages, both as they were read (raw) and after they
+// were decoded to JSON. It keeps histograms of the sizes of all messages and, if PathsStr is set,
+// of the raw sizes for each of the top K values at the paths, along with the locations of the
+// largest messages.
+//
+// It's fed by a LiveStats after its filters and sampling, so it reuses the decoded message and
+// the values that the LiveStats extracted instead of decoding each message again. Like
+// LiveStats, it only keeps the size stats of the values that were seen the most often (or, with
+// WeightBytes, that have the most bytes), so the stats for the values that are seen late in a
+// run may be incomplete.
+type sizeStats struct {
+	sync.Mutex
+
+	config sizeStatsConfig
+
+	raw        stats.SizeHistogram
+	decoded    stats.SizeHistogram
+	numInvalid int64
+	buckets    map[string]*stats.SizeHistogram
+	largest    []sizeLocation
+}
+
+// sizeLocation is the location and sizes of one of the largest messages.
+type sizeLocation struct {
+	Size        int64  `json:"size"`
+	DecodedSize int64  `json:"decodedSize"`
+	Source      string `json:"source"`
+	Partition   int    `json:"partition"`
+	Offset      int64  `json:"offset"`
+}
+
+// sizeStatsState is the checkpointed state of a sizeStats. Its config is checked as part of the
+// LiveStats config.
+type sizeStatsState struct {
+	Raw        stats.SizeHistogram            `json:"raw"`
+	Decoded    stats.SizeHistogram            `json:"decoded"`
+	NumInvalid int64                          `json:"numInvalid"`
+	Buckets    map[string]stats.SizeHistogram `json:"buckets"`
+	Largest    []sizeLocation                 `json:"largest"`
+}
+
+// newSizeStats creates a new sizeStats instance.
+func newSizeStats(config sizeStatsConfig) (*sizeStats, error) {
+	if config.Largest < 0 {
+		return nil, errors.New("Number of largest messages can't be negative")
+	}
+
+	return &sizeStats{
+		config:  config,
+		buckets: map[string]*stats.SizeHistogram{},
+	}, nil
+}
+
+// add updates the size stats for a single message. The decoded size is negative if the message
+// couldn't be decoded or isn't valid JSON, and values are the ones that the LiveStats extracted
+// from it.
+func (s *sizeStats) add(msg message.Message, decodedSize int64, values []string) {
+	s.Lock()
+	defer s.Unlock()
+
+	size := int64(len(msg.Value))
+	s.raw.Add(size)
+
+	if decodedSize < 0 {
+		s.numInvalid++
+		s.addBucket(stats.InvalidValue, size)
+	} else {
+		s.decoded.Add(decodedSize)
+
+		for _, value := range values {
+			s.addBucket(value, size)
+		}
+		if len(values) == 0 {
+			s.addBucket(stats.MissingValue, size)
+		}
+	}
+
+	s.addLargest(
+		sizeLocation{
+			Size:        size,
+			DecodedSize: decodedSize,
+			Source:      msg.Source,
+			Partition:   msg.Partition,
+			Offset:      msg.Offset,
+		},
+	)
+}
+
+// addBucket adds a raw message size to the histogram for a value. The histograms are cleaned up
+// in the same way as the buckets in a TopKCounter.
+func (s *sizeStats) addBucket(value string, size int64) {
+	if s.config.PathsStr == "" {
+		return
+	}
+
+	histogram, ok := s.buckets[value]
+	if !ok {
+		histogram = &stats.SizeHistogram{}
+		s.buckets[value] = histogram
+	}
+	histogram.Add(size)
+	s.trimBuckets()
+}
+
+// trimBuckets removes the histograms for the least common values once there are too many of
+// them.
+func (s *sizeStats) trimBuckets() {
+	if len(s.buckets) > 200*s.config.K {
+		for _, key := range s.topBuckets(len(s.buckets))[100*s.config.K:] {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// topBuckets returns up to the argument number of values, ordered by their message counts or,
+// with WeightBytes, by their total sizes.
+func (s *sizeStats) topBuckets(limit int) []string {
+	weight := func(histogram *stats.SizeHistogram) int64 {
+		if s.config.Weight == WeightBytes {
+			return histogram.Sum
+		}
+		return histogram.Count
+	}
+
+	keys := make([]string, 0, len(s.buckets))
+	for key := range s.buckets {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(a, b int) bool {
+		weightA := weight(s.buckets[keys[a]])
+		weightB := weight(s.buckets[keys[b]])
+		return weightA > weightB || (weightA == weightB && keys[a] < keys[b])
+	})
+
+	if len(keys) > limit {
+		keys = keys[:limit]
+	}
+	return keys
+}
+
+// addLargest adds a message to the largest ones if it's bigger than the smallest of them.
+func (s *sizeStats) addLargest(location sizeLocation) {
+	if len(s.largest) == s.config.Largest &&
+		(len(s.largest) == 0 || location.Size <= s.largest[len(s.largest)-1].Size) {
+		return
+	}
+
+	index := sort.Search(len(s.largest), func(i int) bool {
+		return s.largest[i].Size < location.Size
+	})
+	if len(s.largest) < s.config.Largest {
+		s.largest = append(s.largest, sizeLocation{})
+	}
+	copy(s.largest[index+1:], s.largest[index:])
+	s.largest[index] = location
+}
+
+// summary returns a summary of the message sizes.
+func (s *sizeStats) summary() string {
+	s.Lock()
+	defer s.Unlock()
+
+	lines := []string{
+		"Message sizes:",
+		fmt.Sprintf(
+			"  %d messages, %s raw, %s decoded",
+			s.raw.Count,
+			formatBytes(s.raw.Sum),
+			formatBytes(s.decoded.Sum),
+		),
+	}
+	if s.numInvalid > 0 {
+		lines = append(lines, fmt.Sprintf("  %d messages were invalid", s.numInvalid))
+	}
+	if s.raw.Count == 0 {
+		return strings.Join(lines, "\n")
+	}
+
+	lines = append(
+		lines,
+		"Size histogram:",
+		s.histogramTable(),
+		"Size percentiles:",
+		s.percentilesTable(),
+	)
+
+	if len(s.largest) > 0 {
+		lines = append(
+			lines,
+			fmt.Sprintf("Largest %d messages:", len(s.largest)),
+			s.largestTable(),
+		)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// histogramTable returns a pretty table of the raw and decoded message counts in each size
+// bucket.
+func (s *sizeStats) histogramTable() string {
+	buf := &bytes.Buffer{}
+	table := newInfoTable(
+		buf,
+		[]string{"Size", "Raw Messages", "Raw %", "Decoded Messages", "Decoded %"},
+	)
+
+	first := stats.SizeBucket(s.raw.Min)
+	last := stats.SizeBucket(s.raw.Max)
+	if s.decoded.Count > 0 {
+		first = min(first, stats.SizeBucket(s.decoded.Min))
+		last = max(last, stats.SizeBucket(s.decoded.Max))
+	}
+
+	for b := first; b <= last; b++ {
+		lower, upper := stats.SizeBucketBounds(b)
+		label := formatBytes(lower)
+		if upper-lower > 1 {
+			label = fmt.Sprintf("%s - %s", formatBytes(lower), formatBytes(upper-1))
+		}
+
+		table.Append(
+			[]string{
+				label,
+				fmt.Sprintf("%d", s.raw.Buckets[b]),
+				formatPercent(s.raw.Buckets[b], s.raw.Count),
+				fmt.Sprintf("%d", s.decoded.Buckets[b]),
+				formatPercent(s.decoded.Buckets[b], s.decoded.Count),
+			},
+		)
+	}
+
+	table.Render()
+	return string(bytes.TrimRight(buf.Bytes(), "\n"))
+}
+
+// percentilesTable returns a pretty table of the size percentiles of all messages and of the
+// messages with each of the top K values.
+func (s *sizeStats) percentilesTable() string {
+	header := []string{"Messages", "Count", "Total", "Avg"}
+	for _, p := range sizePercentiles {
+		header = append(header, fmt.Sprintf("P%g", p))
+	}
+	header = append(header, "Max")
+
+	buf := &bytes.Buffer{}
+	table := newInfoTable(buf, header)
+
+	table.Append(percentilesRow("all (raw)", s.raw))
+	if s.decoded.Count > 0 {
+		table.Append(percentilesRow("all (decoded)", s.decoded))
+	}
+	for _, key := range s.topBuckets(s.config.K) {
+		table.Append(
+			percentilesRow(strings.ReplaceAll(key, stats.DimSeparator, ", "), *s.buckets[key]),
+		)
+	}
+
+	table.Render()
+	return string(bytes.TrimRight(buf.Bytes(), "\n"))
+}
+
+func percentilesRow(name string, histogram stats.SizeHistogram) []string {
+	row := []string{
+		name,
+		fmt.Sprintf("%d", histogram.Count),
+		formatBytes(histogram.Sum),
+		formatBytes(int64(histogram.Avg())),
+	}
+	for _, p := range sizePercentiles {
+		row = append(row, formatBytes(histogram.Percentile(p)))
+	}
+	return append(row, formatBytes(histogram.Max))
+}
+
+// largestTable returns a pretty table of the locations of the largest messages.
+func (s *sizeStats) largestTable() string {
+	buf := &bytes.Buffer{}
+	table := newInfoTable(
+		buf,
+		[]string{"Size", "Decoded Size", "Source", "Partition", "Offset"},
+	)
+
+	for _, location := range s.largest {
+		decodedSize := ""
+		if location.DecodedSize >= 0 {
+			decodedSize = formatBytes(location.DecodedSize)
+		}
+
+		table.Append(
+			[]string{
+				formatBytes(location.Size),
+				decodedSize,
+				location.Source,
+				fmt.Sprintf("%d", location.Partition),
+				fmt.Sprintf("%d", location.Offset),
+			},
+		)
+	}
+
+	table.Render()
+	return string(bytes.TrimRight(buf.Bytes(), "\n"))
+}
+
+func formatPercent(count int64, total int64) string {
+	if total == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%0.2f%%", float64(count)/float64(total)*100.0)
+}
+
+// state returns a snapshot of the sizes that have been seen so far.
+func (s *sizeStats) state() sizeStatsState {
+	s.Lock()
+	defer s.Unlock()
+
+	buckets := map[string]stats.SizeHistogram{}
+	for key, histogram := range s.buckets {
+		buckets[key] = *histogram
+	}
+
+	return sizeStatsState{
+		Raw:        s.raw,
+		Decoded:    s.decoded,
+		NumInvalid: s.numInvalid,
+		Buckets:    buckets,
+		Largest:    append([]sizeLocation{}, s.largest...),
+	}
+}
+
+// merge adds the sizes from the argument state, e.g. the one of another processing worker, to
+// these ones.
+func (s *sizeStats) merge(state sizeStatsState) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.raw.Merge(state.Raw)
+	s.decoded.Merge(state.Decoded)
+	s.numInvalid += state.NumInvalid
+
+	if s.config.PathsStr != "" {
+		for key, histogram := range state.Buckets {
+			existing, ok := s.buckets[key]
+			if !ok {
+				existing = &stats.SizeHistogram{}
+				s.buckets[key] = existing
+			}
+			existing.Merge(histogram)
+		}
+		s.trimBuckets()
+	}
+
+	for _, location := range state.Largest {
+		s.addLargest(location)
+	}
+}
+
+// restore replaces the sizes with the ones from a checkpoint.
+func (s *sizeStats) restore(state sizeStatsState) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.raw = state.Raw
+	s.decoded = state.Decoded
+	s.numInvalid = state.NumInvalid
+	s.buckets = map[string]*stats.SizeHistogram{}
+	for key, histogram := range state.Buckets {
+		histogram := histogram
+		s.buckets[key] = &histogram
+	}
+	s.largest = state.Largest
+}
@@ -0,0 +1,268 @@
+package digger
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/segmentio/data-digger/pkg/message"
+	"github.com/segmentio/data-digger/pkg/stats"
+	sjson "github.com/segmentio/encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSizeStats(t *testing.T) {
+	ctx := context.Background()
+
+	config := LiveStatsConfig{
+		K:        10,
+		Largest:  2,
+		PathsStr: "type",
+		Sizes:    true,
+	}
+	liveStats, err := NewLiveStats(config)
+	require.NoError(t, err)
+	require.NoError(t, liveStats.Stop())
+
+	messages := []message.Message{
+		{Source: "file://a.json", Partition: 0, Offset: 0, Value: []byte(`{"type":"track"}`)},
+		{Source: "file://a.json", Partition: 0, Offset: 1, Value: []byte(`{"type":"identify","traits":{"name":"x"}}`)},
+		{Source: "file://b.json", Partition: 1, Offset: 0, Value: []byte(`{"type":"track","properties":{}}`)},
+		{Source: "file://b.json", Partition: 1, Offset: 1, Value: []byte(`{"other":1}`)},
+		{Source: "file://b.json", Partition: 1, Offset: 2, Value: []byte(`{"other":`)},
+		{Source: "file://b.json", Partition: 1, Offset: 3, Value: nil, Oversized: true},
+	}
+
+	for _, msg := range messages {
+		require.NoError(t, liveStats.Process(ctx, msg))
+	}
+
+	sizes := liveStats.sizeStats()
+	require.NotNil(t, sizes)
+
+	assert.Equal(t, int64(5), sizes.raw.Count)
+	assert.Equal(t, int64(16+41+32+11+9), sizes.raw.Sum)
+	assert.Equal(t, int64(9), sizes.raw.Min)
+	assert.Equal(t, int64(41), sizes.raw.Max)
+	assert.Equal(t, int64(4), sizes.decoded.Count)
+	assert.Equal(t, int64(1), sizes.numInvalid)
+
+	assert.Equal(t, int64(2), sizes.buckets["track"].Count)
+	assert.Equal(t, int64(48), sizes.buckets["track"].Sum)
+	assert.Equal(t, int64(1), sizes.buckets["identify"].Count)
+	assert.Equal(t, int64(1), sizes.buckets[stats.MissingValue].Count)
+	assert.Equal(t, int64(1), sizes.buckets[stats.InvalidValue].Count)
+	assert.Equal(
+		t,
+		[]string{"track", stats.InvalidValue, stats.MissingValue, "identify"},
+		sizes.topBuckets(10),
+	)
+
+	assert.Equal(
+		t,
+		[]sizeLocation{
+			{Size: 41, DecodedSize: 41, Source: "file://a.json", Partition: 0, Offset: 1},
+			{Size: 32, DecodedSize: 32, Source: "file://b.json", Partition: 1, Offset: 0},
+		},
+		sizes.largest,
+	)
+
+	summary := liveStats.Summary()
+	assert.Contains(t, summary, "5 messages, 109 B raw, 100 B decoded")
+	assert.Contains(t, summary, "1 messages were invalid")
+	assert.Contains(t, summary, "Largest 2 messages:")
+	assert.Contains(t, summary, "all (raw)")
+	assert.Contains(t, summary, "identify")
+	assert.Contains(t, summary, "file://a.json")
+	assert.Contains(t, summary, "Top K values")
+
+	// The sizes are restored along with the rest of the stats
+	state, err := liveStats.CheckpointState()
+	require.NoError(t, err)
+	stateBytes, err := sjson.Marshal(state)
+	require.NoError(t, err)
+
+	restored, err := NewLiveStats(config)
+	require.NoError(t, err)
+	require.NoError(t, restored.Stop())
+	require.NoError(t, restored.RestoreCheckpoint(stateBytes))
+	assert.Equal(t, sizes.raw, restored.sizeStats().raw)
+	assert.Equal(t, sizes.buckets, restored.sizeStats().buckets)
+	assert.Equal(t, sizes.largest, restored.sizeStats().largest)
+	assert.Equal(t, summary, restored.Summary())
+
+	different, err := NewLiveStats(
+		LiveStatsConfig{K: 10, Largest: 5, PathsStr: "type", Sizes: true},
+	)
+	require.NoError(t, err)
+	require.NoError(t, different.Stop())
+	assert.Error(t, different.RestoreCheckpoint(stateBytes))
+
+	// Without sizes, the number of largest messages doesn't matter
+	withoutSizes, err := NewLiveStats(LiveStatsConfig{K: 10, Largest: 5, PathsStr: "type"})
+	require.NoError(t, err)
+	require.NoError(t, withoutSizes.Stop())
+	assert.Nil(t, withoutSizes.sizeStats())
+	assert.NotContains(t, withoutSizes.Summary(), "Message sizes:")
+	assert.Equal(t, 0, withoutSizes.config.Largest)
+
+	_, err = NewLiveStats(LiveStatsConfig{K: 10, Largest: -1, Sizes: true})
+	assert.Error(t, err)
+}
+
+func TestSizeStatsFiltered(t *testing.T) {
+	ctx := context.Background()
+
+	liveStats, err := NewLiveStats(
+		LiveStatsConfig{
+			K:           10,
+			PathFilters: []string{"type=track"},
+			PathsStr:    "type",
+			SampleRate:  0.5,
+			Sizes:       true,
+		},
+	)
+	require.NoError(t, err)
+	require.NoError(t, liveStats.Stop())
+
+	for i := 0; i < 1000; i++ {
+		eventType := "track"
+		if i%2 == 1 {
+			eventType = "identify"
+		}
+		msg := message.Message{
+			Offset: int64(i),
+			Value:  []byte(fmt.Sprintf(`{"type":"%s"}`, eventType)),
+		}
+		require.NoError(t, liveStats.Process(ctx, msg))
+	}
+
+	// Only the messages that passed the filter and were sampled are measured
+	matches := liveStats.messageCounter().State().PostFilterMessages
+	assert.Greater(t, matches, int64(0))
+	assert.Less(t, matches, int64(500))
+	assert.Equal(t, matches, liveStats.sizeStats().raw.Count)
+	assert.Equal(t, matches, liveStats.sizeStats().buckets["track"].Count)
+	assert.NotContains(t, liveStats.sizeStats().buckets, "identify")
+}
+
+func TestSizeStatsFilteredInvalid(t *testing.T) {
+	ctx := context.Background()
+
+	messages := []string{
+		`{"type":"track"}`,
+		`{"type":"identify"}`,
+		`{"type":"track",`,
+		`{"type":"identify",`,
+		`not json`,
+	}
+
+	testCases := []struct {
+		config        LiveStatsConfig
+		expectedSizes int64
+		expectedValid int64
+	}{
+		{
+			config:        LiveStatsConfig{K: 10, PathsStr: "type", Sizes: true},
+			expectedSizes: 5,
+			expectedValid: 2,
+		},
+		{
+			// Invalid messages that fail the path filter aren't measured, but ones that pass it
+			// are
+			config: LiveStatsConfig{
+				K:           10,
+				PathFilters: []string{"type=track"},
+				PathsStr:    "type",
+				Sizes:       true,
+			},
+			expectedSizes: 2,
+			expectedValid: 1,
+		},
+		{
+			config:        LiveStatsConfig{K: 10, Filter: "identify", PathsStr: "type", Sizes: true},
+			expectedSizes: 2,
+			expectedValid: 1,
+		},
+	}
+
+	for _, testCase := range testCases {
+		liveStats, err := NewLiveStats(testCase.config)
+		require.NoError(t, err)
+		require.NoError(t, liveStats.Stop())
+
+		for _, value := range messages {
+			require.NoError(t, liveStats.Process(ctx, message.Message{Value: []byte(value)}))
+		}
+
+		sizes := liveStats.sizeStats()
+		assert.Equal(t, testCase.expectedSizes, sizes.raw.Count, testCase.config)
+		assert.Equal(t, testCase.expectedValid, sizes.decoded.Count, testCase.config)
+		assert.Equal(
+			t,
+			testCase.expectedSizes-testCase.expectedValid,
+			sizes.numInvalid,
+			testCase.config,
+		)
+	}
+}
+
+func TestSizeStatsWeight(t *testing.T) {
+	ctx := context.Background()
+
+	liveStats, err := NewLiveStats(
+		LiveStatsConfig{
+			K:        1,
+			PathsStr: "type",
+			Sizes:    true,
+			Weight:   WeightBytes,
+		},
+	)
+	require.NoError(t, err)
+	require.NoError(t, liveStats.Stop())
+
+	messages := []string{
+		`{"type":"a"}`,
+		`{"type":"a"}`,
+		`{"type":"b","padding":"` + strings.Repeat("x", 100) + `"}`,
+	}
+	for _, value := range messages {
+		require.NoError(t, liveStats.Process(ctx, message.Message{Value: []byte(value)}))
+	}
+
+	// b has fewer messages but more bytes
+	assert.Equal(t, []string{"b"}, liveStats.sizeStats().topBuckets(1))
+	assert.Empty(t, liveStats.sizeStats().largest)
+}
+
+func TestSizeStatsParallel(t *testing.T) {
+	ctx := context.Background()
+
+	config := LiveStatsConfig{K: 10, Largest: 3, PathsStr: "type", Sizes: true}
+	serial, err := NewLiveStats(config)
+	require.NoError(t, err)
+	require.NoError(t, serial.Stop())
+
+	config.NumWorkers = 4
+	parallel, err := NewLiveStats(config)
+	require.NoError(t, err)
+	require.NoError(t, parallel.Stop())
+
+	for i := 0; i < 100; i++ {
+		msg := message.Message{
+			Offset: int64(i),
+			Value: []byte(
+				fmt.Sprintf(`{"type":"t%d","padding":"%s"}`, i%3, strings.Repeat("x", i)),
+			),
+		}
+		require.NoError(t, serial.Process(ctx, msg))
+		require.NoError(t, parallel.ProcessWorker(ctx, i%4, msg, nil))
+	}
+
+	// Each worker measures the sizes in its own shard, and they're merged when they're read
+	assert.Equal(t, int64(25), parallel.shards[1].sizes.raw.Count)
+	assert.Equal(t, serial.sizeStats().state(), parallel.sizeStats().state())
+	assert.Equal(t, serial.Summary(), parallel.Summary())
+}
@@ -0,0 +1,478 @@
+package json
+
+import (
+	"strings"
+	"unsafe"
+
+	"github.com/segmentio/data-digger/pkg/stats"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+)
+
+// PathExtractor pulls the values for a fixed set of gjson path groups out of messages.
+//
+// Instead of searching the full message once per path, the top-level fields that the paths refer
+// to are found in a single pass over the message, and the rest of each path is evaluated against
+// just its field. Paths whose top-level field can't be determined statically (e.g., ones that
+// start with a modifier) are evaluated against the full message as usual.
+type PathExtractor struct {
+	pathGroups [][]extractorPath
+	roots      map[string]int
+}
+
+// extractorPath is a single path in a PathExtractor.
+type extractorPath struct {
+	path string
+
+	// all is set for the empty path, which matches every message
+	all bool
+
+	// meta is set for paths that are evaluated against the message metadata
+	meta bool
+
+	// root is the index of the path's top-level field, or -1 if it's evaluated against the full
+	// message; rest is the remainder of the path within that field
+	root int
+	rest string
+}
+
+// NewPathExtractor returns a PathExtractor for the argument path groups. See
+// GJsonPathValuesWithMeta for the semantics of the groups.
+func NewPathExtractor(pathGroups [][]string) *PathExtractor {
+	extractor := &PathExtractor{
+		roots: map[string]int{},
+	}
+
+	for _, pathGroup := range pathGroups {
+		extractorPaths := []extractorPath{}
+
+		for _, path := range pathGroup {
+			extractorPath := extractorPath{
+				path: path,
+				root: -1,
+			}
+
+			if path == "" {
+				extractorPath.all = true
+			} else if IsMetaPath(path) {
+				extractorPath.meta = true
+				extractorPath.path = path[len(MetaPrefix):]
+			} else if root, rest, ok := splitPathRoot(path); ok {
+				index, ok := extractor.roots[root]
+				if !ok {
+					index = len(extractor.roots)
+					extractor.roots[root] = index
+				}
+				extractorPath.root = index
+				extractorPath.rest = rest
+			}
+
+			extractorPaths = append(extractorPaths, extractorPath)
+		}
+
+		extractor.pathGroups = append(extractor.pathGroups, extractorPaths)
+	}
+
+	return extractor
+}
+
+// Values returns the values for this extractor's paths in the argument message contents and
+// metadata, along with whether any of the paths that refer to the contents were found. Found is
+// only true if the contents were also checked to be valid JSON, so callers don't need to
+// validate them again. If the contents are invalid, e.g. because they're truncated or have
+// malformed tokens, then the values that were found before the problem are returned, but found
+// is false so that callers validate the message instead of assuming that it's valid. The returned values don't reference the argument
+// byte slices, so they can be kept after the message buffer is reused.
+func (e *PathExtractor) Values(contents []byte, meta []byte) ([]string, bool) {
+	// The contents aren't modified while this function runs, and all of the values are copied
+	// before it returns
+	json := unsafeString(contents)
+	found := false
+
+	// Most extractors only have a few roots, so avoid allocating for them
+	var rootResultsBuf [8]gjson.Result
+	var rootResults []gjson.Result
+	if len(e.roots) <= len(rootResultsBuf) {
+		rootResults = rootResultsBuf[:len(e.roots)]
+	} else {
+		rootResults = make([]gjson.Result, len(e.roots))
+	}
+	rootsFound, complete := e.findRoots(json, rootResults)
+
+	valueGroups := make([][]string, 0, len(e.pathGroups))
+
+	for _, pathGroup := range e.pathGroups {
+		valueGroup := make([]string, 0, len(pathGroup))
+
+		for _, path := range pathGroup {
+			var result gjson.Result
+
+			switch {
+			case path.all:
+				valueGroup = append(valueGroup, stats.AllValue)
+				continue
+			case path.meta:
+				result = gjson.GetBytes(meta, path.path)
+			case path.root >= 0 && rootsFound:
+				result = rootResults[path.root]
+				if path.rest != "" && result.Exists() {
+					result = gjson.Get(result.Raw, path.rest)
+				}
+			default:
+				result = gjson.Get(json, path.path)
+			}
+
+			if !result.Exists() {
+				continue
+			}
+			if !path.meta {
+				found = true
+			}
+
+			if result.IsArray() {
+				for _, subResult := range result.Array() {
+					valueGroup = append(valueGroup, subResult.String())
+				}
+			} else {
+				valueGroup = append(valueGroup, result.String())
+			}
+		}
+
+		valueGroups = append(valueGroups, valueGroup)
+	}
+
+	if found && !rootsFound {
+		// The paths were evaluated against the full message, so it hasn't been scanned yet
+		end, valid := skipValue(json, skipSpace(json, 0))
+		complete = valid && skipSpace(json, end) == len(json)
+	}
+	if !complete {
+		found = false
+	}
+
+	if len(valueGroups) == 1 {
+		// The values may point into the contents, so copy them; multi-dimensional values are
+		// already copied when they're joined
+		for v, value := range valueGroups[0] {
+			valueGroups[0][v] = strings.Clone(value)
+		}
+	}
+
+	return joinValueGroups(valueGroups), found
+}
+
+// findRoots finds the top-level fields that this extractor's paths refer to and stores them in
+// results. The values of other fields are skipped without being parsed. If the message isn't an
+// object, then the first return value is false and the paths should be evaluated against the full
+// message instead. The second return value is whether the object was scanned completely, i.e. it
+// is valid JSON and isn't followed by anything other than whitespace.
+func (e *PathExtractor) findRoots(json string, results []gjson.Result) (bool, bool) {
+	if len(e.roots) == 0 {
+		return false, false
+	}
+
+	i := skipSpace(json, 0)
+	if i >= len(json) || json[i] != '{' {
+		return false, false
+	}
+
+	// The rest of the object is scanned even after all of the roots are found so that truncated
+	// messages and ones with trailing garbage aren't mistaken for valid ones
+	i = skipSpace(json, i+1)
+	if i < len(json) && json[i] == '}' {
+		return true, skipSpace(json, i+1) == len(json)
+	}
+
+	for i < len(json) {
+		if json[i] != '"' {
+			return true, false
+		}
+
+		keyEnd, escaped, ok := skipString(json, i)
+		if !ok {
+			return true, false
+		}
+		key := json[i+1 : keyEnd-1]
+		if escaped {
+			key = gjson.Parse(json[i:keyEnd]).Str
+		}
+
+		i = skipSpace(json, keyEnd)
+		if i >= len(json) || json[i] != ':' {
+			return true, false
+		}
+
+		valueStart := skipSpace(json, i+1)
+		valueEnd, ok := skipValue(json, valueStart)
+		if !ok {
+			return true, false
+		}
+
+		index, ok := e.roots[key]
+		if ok && !results[index].Exists() {
+			// Like gjson.Get, use the first value if a key is repeated
+			results[index] = gjson.Parse(json[valueStart:valueEnd])
+		}
+
+		i = skipSpace(json, valueEnd)
+		if i >= len(json) {
+			return true, false
+		}
+
+		switch json[i] {
+		case ',':
+			i = skipSpace(json, i+1)
+		case '}':
+			return true, skipSpace(json, i+1) == len(json)
+		default:
+			return true, false
+		}
+	}
+
+	return true, false
+}
+
+// skipSpace returns the index of the first non-whitespace character at or after i.
+func skipSpace(json string, i int) int {
+	for i < len(json) {
+		switch json[i] {
+		case ' ', '\t', '\n', '\r':
+			i++
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// skipString returns the index just after the string that starts at i, along with whether the
+// string contains any escape sequences and whether it's a valid, terminated string.
+func skipString(json string, i int) (int, bool, bool) {
+	escaped := false
+
+	for i++; i < len(json); i++ {
+		switch c := json[i]; {
+		case c == '\\':
+			escaped = true
+			i++
+			if i >= len(json) {
+				return len(json), escaped, false
+			}
+
+			switch json[i] {
+			case '"', '\\', '/', 'b', 'f', 'n', 'r', 't':
+			case 'u':
+				if i+4 >= len(json) {
+					return len(json), escaped, false
+				}
+				for _, h := range json[i+1 : i+5] {
+					if !isHexDigit(byte(h)) {
+						return i, escaped, false
+					}
+				}
+				i += 4
+			default:
+				return i, escaped, false
+			}
+		case c == '"':
+			return i + 1, escaped, true
+		case c < ' ':
+			return i, escaped, false
+		}
+	}
+
+	return len(json), escaped, false
+}
+
+// skipValue returns the index just after the value that starts at i, along with whether the
+// value is complete and valid. Like sjson.Valid, the tokens are checked against the JSON grammar,
+// but the contents of strings aren't checked for valid UTF-8.
+func skipValue(json string, i int) (int, bool) {
+	if i >= len(json) {
+		return i, false
+	}
+
+	switch json[i] {
+	case '"':
+		end, _, ok := skipString(json, i)
+		return end, ok
+	case '{':
+		i = skipSpace(json, i+1)
+		if i < len(json) && json[i] == '}' {
+			return i + 1, true
+		}
+
+		for i < len(json) {
+			if json[i] != '"' {
+				return i, false
+			}
+
+			var ok bool
+			i, _, ok = skipString(json, i)
+			if !ok {
+				return i, false
+			}
+			i = skipSpace(json, i)
+			if i >= len(json) || json[i] != ':' {
+				return i, false
+			}
+			i, ok = skipValue(json, skipSpace(json, i+1))
+			if !ok {
+				return i, false
+			}
+
+			i = skipSpace(json, i)
+			if i >= len(json) {
+				return i, false
+			}
+			switch json[i] {
+			case ',':
+				i = skipSpace(json, i+1)
+			case '}':
+				return i + 1, true
+			default:
+				return i, false
+			}
+		}
+
+		return i, false
+	case '[':
+		i = skipSpace(json, i+1)
+		if i < len(json) && json[i] == ']' {
+			return i + 1, true
+		}
+
+		for i < len(json) {
+			var ok bool
+			i, ok = skipValue(json, i)
+			if !ok {
+				return i, false
+			}
+
+			i = skipSpace(json, i)
+			if i >= len(json) {
+				return i, false
+			}
+			switch json[i] {
+			case ',':
+				i = skipSpace(json, i+1)
+			case ']':
+				return i + 1, true
+			default:
+				return i, false
+			}
+		}
+
+		return i, false
+	case 't':
+		return skipLiteral(json, i, "true")
+	case 'f':
+		return skipLiteral(json, i, "false")
+	case 'n':
+		return skipLiteral(json, i, "null")
+	default:
+		return skipNumber(json, i)
+	}
+}
+
+// skipLiteral returns the index just after the argument literal, which is expected to start at
+// i, along with whether it's there.
+func skipLiteral(json string, i int, literal string) (int, bool) {
+	if strings.HasPrefix(json[i:], literal) {
+		return i + len(literal), true
+	}
+	return i, false
+}
+
+// skipNumber returns the index just after the number that starts at i, along with whether it's
+// a valid JSON number.
+func skipNumber(json string, i int) (int, bool) {
+	if i < len(json) && json[i] == '-' {
+		i++
+	}
+
+	// The integer part can't have leading zeros
+	if i < len(json) && json[i] == '0' {
+		i++
+	} else if i < len(json) && isDigit(json[i]) {
+		i = skipDigits(json, i)
+	} else {
+		return i, false
+	}
+
+	if i < len(json) && json[i] == '.' {
+		start := i + 1
+		i = skipDigits(json, start)
+		if i == start {
+			return i, false
+		}
+	}
+
+	if i < len(json) && (json[i] == 'e' || json[i] == 'E') {
+		i++
+		if i < len(json) && (json[i] == '+' || json[i] == '-') {
+			i++
+		}
+		start := i
+		i = skipDigits(json, start)
+		if i == start {
+			return i, false
+		}
+	}
+
+	return i, true
+}
+
+// skipDigits returns the index of the first non-digit character at or after i.
+func skipDigits(json string, i int) int {
+	for i < len(json) && isDigit(json[i]) {
+		i++
+	}
+	return i
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// joinValueGroups combines the values found for each path group into the final list of values.
+// If there's more than one group, then the values are joined into a single multi-dimensional
+// value.
+func joinValueGroups(valueGroups [][]string) []string {
+	if len(valueGroups) == 0 {
+		return nil
+	} else if len(valueGroups) == 1 {
+		return valueGroups[0]
+	}
+
+	values := make([]string, 0, len(valueGroups))
+
+	for _, valueGroup := range valueGroups {
+		if len(valueGroup) == 0 {
+			values = append(values, stats.MissingValue)
+		} else if len(valueGroup) == 1 {
+			values = append(values, valueGroup[0])
+		} else {
+			// TODO: Evaluate full cross-product?
+			log.Debugf(
+				"Found more than one value for multi-dimensional path query; dropping extra values",
+			)
+			values = append(values, valueGroup[0])
+		}
+	}
+
+	// TODO: Keep sub-values instead of using a string to join them
+	return []string{strings.Join(values, stats.DimSeparator)}
+}
+
+// unsafeString returns a string that shares the memory of the argument byte slice.
+func unsafeString(contents []byte) string {
+	if len(contents) == 0 {
+		return ""
+	}
+	return unsafe.String(unsafe.SliceData(contents), len(contents))
+}
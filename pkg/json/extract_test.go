@@ -0,0 +1,255 @@
+package json
+
+import (
+	"strings"
+	"testing"
+
+	sjson "github.com/segmentio/encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+var sampleMessages = [][]byte{
+	[]byte(`{"app": "fun game", "context": {"os": "ios", "version": "13.2"}, "latency": 9.810121327754976, "messageId": "658z4xJQbAfn9qSbsgYT", "timestamp": "2026-10-09T13:33:22Z", "type": "buy upgrade"}`),
+	[]byte(`{"app": "cute pictures", "context": {"os": "ios", "version": "14.0"}, "latency": 112.82177307213358, "messageId": "cHSg0EQy5hZugG1v7GhV", "timestamp": "2026-10-17T05:28:16Z", "type": "swipe picture"}`),
+	[]byte(`{"app": "amazing sports", "context": {"os": "android", "version": "honeycomb"}, "latency": 5.112, "messageId": "Xb2yqQwYt7rJ1pPZcLmA", "timestamp": "2026-10-12T21:04:51Z", "type": "view page"}`),
+}
+
+func TestPathExtractor(t *testing.T) {
+	type testCase struct {
+		contents       string
+		pathGroups     [][]string
+		expectedValues []string
+		expectedFound  bool
+	}
+
+	testCases := []testCase{
+		{
+			contents:       `{"key1": "value1", "key2": {"sub": [1, 2]}}`,
+			pathGroups:     [][]string{{"key2.sub", "key1"}},
+			expectedValues: []string{"1", "2", "value1"},
+			expectedFound:  true,
+		},
+		{
+			contents:       `{"key1": "value1", "key2": {"sub": [1, 2]}}`,
+			pathGroups:     [][]string{{"key2.sub.#"}, {"key1|@trim:3"}},
+			expectedValues: []string{"2∪∪val"},
+			expectedFound:  true,
+		},
+		{
+			// Repeated keys use the first value, like gjson
+			contents:       `{"key1": "value1", "key1": "value2", "a.b": "escaped"}`,
+			pathGroups:     [][]string{{"key1", `a\.b`}},
+			expectedValues: []string{"value1", "escaped"},
+			expectedFound:  true,
+		},
+		{
+			contents:       `[{"key1": "value1"}, {"key1": "value2"}]`,
+			pathGroups:     [][]string{{"#.key1", "0.key1"}},
+			expectedValues: []string{"value1", "value2", "value1"},
+			expectedFound:  true,
+		},
+		{
+			contents:       `{"key1": "value1"}`,
+			pathGroups:     [][]string{{"key2"}},
+			expectedValues: []string{},
+			expectedFound:  false,
+		},
+		{
+			contents:       `not json`,
+			pathGroups:     [][]string{{""}},
+			expectedValues: []string{"__all__"},
+			expectedFound:  false,
+		},
+		{
+			// Truncated and garbage-suffixed objects still return the values found before the
+			// problem, but they aren't reported as found so that they're validated
+			contents:       `{"key1": "value1", "key2": {"sub": [1, `,
+			pathGroups:     [][]string{{"key1"}},
+			expectedValues: []string{"value1"},
+			expectedFound:  false,
+		},
+		{
+			contents:       `{"key1": "value1", "key2": "unterminated`,
+			pathGroups:     [][]string{{"key1"}},
+			expectedValues: []string{"value1"},
+			expectedFound:  false,
+		},
+		{
+			contents:       `{"key1": "value1"} garbage`,
+			pathGroups:     [][]string{{"key1"}},
+			expectedValues: []string{"value1"},
+			expectedFound:  false,
+		},
+		{
+			contents:       `{"key1": "value1", "key2": 2,}`,
+			pathGroups:     [][]string{{"key1"}},
+			expectedValues: []string{"value1"},
+			expectedFound:  false,
+		},
+		{
+			// Malformed literals and numbers aren't mistaken for valid ones
+			contents:       `{"a":tru}`,
+			pathGroups:     [][]string{{"a"}},
+			expectedValues: []string{},
+			expectedFound:  false,
+		},
+		{
+			contents:       `{"a":1,"b":nul}`,
+			pathGroups:     [][]string{{"a"}},
+			expectedValues: []string{"1"},
+			expectedFound:  false,
+		},
+		{
+			contents:       `{"a":1,"b":{"c":[1, 2, falsey]}}`,
+			pathGroups:     [][]string{{"a"}},
+			expectedValues: []string{"1"},
+			expectedFound:  false,
+		},
+		{
+			contents:       `{"a":1,"b":01}`,
+			pathGroups:     [][]string{{"a"}},
+			expectedValues: []string{"1"},
+			expectedFound:  false,
+		},
+		{
+			// Messages that aren't objects are validated after the paths are evaluated
+			contents:       `[{"key1": "value1"}, tru]`,
+			pathGroups:     [][]string{{"0.key1"}},
+			expectedValues: []string{"value1"},
+			expectedFound:  false,
+		},
+		{
+			contents:       "{\"key1\": \"value1\", \"key2\": [true, {}]}\n",
+			pathGroups:     [][]string{{"key1"}},
+			expectedValues: []string{"value1"},
+			expectedFound:  true,
+		},
+		{
+			contents:       `{"key1": "value1"}`,
+			pathGroups:     [][]string{{"@meta.partition"}},
+			expectedValues: []string{"3"},
+			expectedFound:  false,
+		},
+	}
+
+	for _, testCase := range testCases {
+		values, found := NewPathExtractor(testCase.pathGroups).Values(
+			[]byte(testCase.contents),
+			[]byte(`{"partition": 3}`),
+		)
+		assert.Equal(t, testCase.expectedValues, values, testCase.contents)
+		assert.Equal(t, testCase.expectedFound, found, testCase.contents)
+	}
+}
+
+func TestPathExtractorMatchesGJson(t *testing.T) {
+	paths := []string{
+		"app",
+		"context.os",
+		"context|@pretty",
+		"context.version|@trim:2",
+		"latency",
+		"type",
+		"missing",
+		"context.missing",
+		"@this.app",
+		"..0",
+	}
+	extractor := NewPathExtractor([][]string{paths})
+
+	for _, contents := range sampleMessages {
+		expected := []string{}
+		for _, path := range paths {
+			result := gjson.GetBytes(contents, path)
+			if result.Exists() {
+				expected = append(expected, result.String())
+			}
+		}
+
+		values, _ := extractor.Values(contents, nil)
+		assert.Equal(t, expected, values)
+	}
+}
+
+func TestPathExtractorMatchesValid(t *testing.T) {
+	extractor := NewPathExtractor([][]string{{"a", "app"}})
+
+	messages := []string{
+		`{"a":1}`,
+		`{"a":-1.5e+10,"b":[true,false,null,{}],"c":"\u00e9\n"}`,
+		`{"a":1,"b":-}`,
+		`{"a":1,"b":1.}`,
+		`{"a":1,"b":1e}`,
+		`{"a":1,"b":.5}`,
+		`{"a":1,"b":[1,]}`,
+		`{"a":1,"b":{"c":1,}}`,
+		`{"a":1,"b":{"c" 1}}`,
+		`{"a":1,"b":[1 2]}`,
+		`{"a":1,"b":"\x"}`,
+		`{"a":1,"b":"\u12"}`,
+		"{\"a\":1,\"b\":\"\x01\"}",
+		"{\"a\":1,\x01\"b\":2}",
+		`{"a":1,"b":truex}`,
+		`{"a":1,"b":nan}`,
+		`{"a":1} {}`,
+	}
+	for _, contents := range sampleMessages {
+		messages = append(messages, string(contents))
+	}
+
+	for _, contents := range messages {
+		_, found := extractor.Values([]byte(contents), nil)
+		assert.Equal(t, sjson.Valid([]byte(contents)), found, contents)
+	}
+}
+
+func TestPathExtractorCopiesValues(t *testing.T) {
+	contents := []byte(`{"key1": "value1"}`)
+	values, _ := NewPathExtractor([][]string{{"key1"}}).Values(contents, nil)
+
+	copy(contents, strings.Repeat("x", len(contents)))
+	assert.Equal(t, []string{"value1"}, values)
+}
+
+// perPathValues is the previous implementation of GJsonPathValues, which searches the full
+// message for each path. It's kept as a baseline for the benchmarks.
+func perPathValues(contents []byte, pathGroups [][]string) []string {
+	valueGroups := [][]string{}
+
+	for _, pathGroup := range pathGroups {
+		valueGroup := []string{}
+
+		for _, path := range pathGroup {
+			result := gjson.GetBytes(contents, path)
+			if result.IsArray() {
+				for _, subResult := range result.Array() {
+					valueGroup = append(valueGroup, subResult.String())
+				}
+			} else if result.Exists() {
+				valueGroup = append(valueGroup, result.String())
+			}
+		}
+
+		valueGroups = append(valueGroups, valueGroup)
+	}
+
+	return joinValueGroups(valueGroups)
+}
+
+func BenchmarkPathValues(b *testing.B) {
+	pathGroups := [][]string{{"app"}, {"context.os"}, {"type"}}
+
+	b.Run("per-path", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			perPathValues(sampleMessages[i%len(sampleMessages)], pathGroups)
+		}
+	})
+
+	b.Run("extractor", func(b *testing.B) {
+		extractor := NewPathExtractor(pathGroups)
+		for i := 0; i < b.N; i++ {
+			extractor.Values(sampleMessages[i%len(sampleMessages)], nil)
+		}
+	})
+}
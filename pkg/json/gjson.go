@@ -12,6 +12,10 @@ import (
 	"github.com/tidwall/gjson"
 )
 
+// MetaPrefix is the prefix for virtual paths that refer to message metadata (e.g., the Kafka
+// partition) instead of the message contents.
+const MetaPrefix = "@meta."
+
 func init() {
 	gjson.AddModifier("base64d", base64Decode)
 	gjson.AddModifier("trim", trimString)
@@ -80,57 +84,88 @@ func trimString(json, arg string) string {
 // GJsonPathValues returns the values associated with one or more gjson-formatted paths in
 // the argument JSON blob.
 func GJsonPathValues(contents []byte, pathGroups [][]string) []string {
-	valueGroups := [][]string{}
+	return GJsonPathValuesWithMeta(contents, nil, pathGroups)
+}
 
-	for _, pathGroup := range pathGroups {
-		valueGroup := []string{}
+// GJsonPathValuesWithMeta is like GJsonPathValues, but paths that start with MetaPrefix are
+// evaluated against the argument metadata JSON blob instead of the message contents. Callers that
+// extract the same paths from many messages should use a PathExtractor instead.
+func GJsonPathValuesWithMeta(contents []byte, meta []byte, pathGroups [][]string) []string {
+	values, _ := NewPathExtractor(pathGroups).Values(contents, meta)
+	return values
+}
 
-		for _, path := range pathGroup {
-			if path == "" {
-				valueGroup = append(valueGroup, stats.AllValue)
-				continue
-			}
+// IsMetaPath returns whether the argument path refers to message metadata.
+func IsMetaPath(path string) bool {
+	return strings.HasPrefix(path, MetaPrefix)
+}
 
-			result := gjson.GetBytes(contents, path)
-
-			if result.Exists() {
-				if result.IsArray() {
-					for _, subResult := range result.Array() {
-						value := subResult.String()
-						valueGroup = append(valueGroup, value)
-					}
-				} else {
-					value := result.String()
-					valueGroup = append(valueGroup, value)
-				}
-			}
+// PathResult evaluates a single gjson path against either the message contents or, if the
+// path starts with MetaPrefix, the message metadata.
+func PathResult(contents []byte, meta []byte, path string) gjson.Result {
+	if IsMetaPath(path) {
+		return gjson.GetBytes(meta, path[len(MetaPrefix):])
+	}
+	return gjson.GetBytes(contents, path)
+}
+
+// Project returns a JSON object that maps each of the argument paths to its value in the
+// argument contents (or metadata). Missing values are set to null.
+func Project(contents []byte, meta []byte, paths []string) []byte {
+	projection := []byte{'{'}
+
+	for i, path := range paths {
+		if i > 0 {
+			projection = append(projection, ',')
 		}
+		projection = sjson.AppendEscape(projection, path, 0)
+		projection = append(projection, ':')
 
-		valueGroups = append(valueGroups, valueGroup)
+		result := PathResult(contents, meta, path)
+		if result.Exists() {
+			projection = append(projection, result.Raw...)
+		} else {
+			projection = append(projection, "null"...)
+		}
 	}
 
-	if len(valueGroups) == 0 {
-		return nil
-	} else if len(valueGroups) == 1 {
-		return valueGroups[0]
-	}
+	return append(projection, '}')
+}
 
-	values := []string{}
+// PathRoot returns the name of the top-level field referenced by the argument gjson path. If
+// the root can't be determined statically (e.g., because the path starts with a modifier or
+// wildcard), then false is returned.
+func PathRoot(path string) (string, bool) {
+	root, _, ok := splitPathRoot(path)
+	return root, ok
+}
 
-	for _, valueGroup := range valueGroups {
-		if len(valueGroup) == 0 {
-			values = append(values, stats.MissingValue)
-		} else if len(valueGroup) == 1 {
-			values = append(values, valueGroup[0])
-		} else {
-			// TODO: Evaluate full cross-product?
-			log.Debugf(
-				"Found more than one value for multi-dimensional path query; dropping extra values",
-			)
-			values = append(values, valueGroup[0])
+// splitPathRoot splits the argument gjson path into the name of its top-level field and the
+// remainder of the path within that field, e.g. context.os.name into context and os.name. The
+// remainder is empty if the path only refers to the top-level field.
+func splitPathRoot(path string) (string, string, bool) {
+	root := []byte{}
+
+	for i := 0; i < len(path); i++ {
+		switch path[i] {
+		case '\\':
+			if i+1 < len(path) {
+				i++
+				root = append(root, path[i])
+			}
+			continue
+		case '.', '|':
+			return string(root), path[i+1:], len(root) > 0
+		case '*', '?':
+			return "", "", false
+		case '@', '#', '[', '{', '!':
+			if len(root) == 0 {
+				return "", "", false
+			}
 		}
+
+		root = append(root, path[i])
 	}
 
-	// TODO: Keep sub-values instead of using a string to join them
-	return []string{strings.Join(values, stats.DimSeparator)}
+	return string(root), "", len(root) > 0
 }
@@ -107,3 +107,75 @@ func TestGJsonPathValues(t *testing.T) {
 		),
 	)
 }
+
+func TestPathRoot(t *testing.T) {
+	type testCase struct {
+		path         string
+		expectedRoot string
+		expectedOK   bool
+	}
+
+	testCases := []testCase{
+		{path: "key1", expectedRoot: "key1", expectedOK: true},
+		{path: "context.os", expectedRoot: "context", expectedOK: true},
+		{path: "timestamp|@trim:10", expectedRoot: "timestamp", expectedOK: true},
+		{path: `a\.b.c`, expectedRoot: "a.b", expectedOK: true},
+		{path: "tags.#", expectedRoot: "tags", expectedOK: true},
+		{path: "ke*.value", expectedOK: false},
+		{path: "@this.key", expectedOK: false},
+		{path: "", expectedOK: false},
+	}
+
+	for _, testCase := range testCases {
+		root, ok := PathRoot(testCase.path)
+		assert.Equal(t, testCase.expectedOK, ok, testCase.path)
+		assert.Equal(t, testCase.expectedRoot, root, testCase.path)
+	}
+}
+
+func TestGJsonPathValuesWithMeta(t *testing.T) {
+	contents := []byte(`{"key1": "value1"}`)
+	meta := []byte(`{"partition": 3, "headers": {"x-source": "test"}}`)
+
+	assert.Equal(
+		t,
+		[]string{"value1∪∪3"},
+		GJsonPathValuesWithMeta(
+			contents,
+			meta,
+			[][]string{{"key1"}, {"@meta.partition"}},
+		),
+	)
+	assert.Equal(
+		t,
+		[]string{"test"},
+		GJsonPathValuesWithMeta(
+			contents,
+			meta,
+			[][]string{{"@meta.headers.x-source"}},
+		),
+	)
+	assert.Equal(
+		t,
+		[]string{},
+		GJsonPathValuesWithMeta(
+			contents,
+			nil,
+			[][]string{{"@meta.partition"}},
+		),
+	)
+}
+
+func TestProject(t *testing.T) {
+	assert.Equal(
+		t,
+		`{"key1":"value1","key2":{"a":1},"@meta.partition":3,"missing":null}`,
+		string(
+			Project(
+				[]byte(`{"key1": "value1", "key2": {"a":1}}`),
+				[]byte(`{"partition": 3}`),
+				[]string{"key1", "key2", "@meta.partition", "missing"},
+			),
+		),
+	)
+}
@@ -0,0 +1,131 @@
+package message
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// Buffers that have grown larger than this aren't returned to the pool
+	maxPooledValueSize = 1024 * 1024
+)
+
+var valuePool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 0, 4096)
+		return &buf
+	},
+}
+
+// Message is a source-neutral representation of a single message read by the digger.
+type Message struct {
+	// Source is a URI that identifies the stream that the message was read from, e.g.
+	// kafka://[address]/[topic], s3://[bucket]/[key], or file://[path].
+	Source string
+
+	// Partition is the Kafka partition or, for the file and S3 sources, the index of the
+	// file or object in the overall read order.
+	Partition int
+
+	// Offset is the Kafka offset or, for the file and S3 sources, the index of the record
+	// within its file or object (or its byte position for the json-stream format).
+	Offset int64
+
+	// Position is the byte position of the message within its (decompressed) file or object.
+	// It's -1 if the position is unknown or not applicable.
+	Position int64
+
+	// Time is the timestamp of the message itself, if the source provides one.
+	Time time.Time
+
+	// ModTime is the last modification time of the file or object that the message was read
+	// from, if applicable.
+	ModTime time.Time
+
+	Key     []byte
+	Headers []Header
+	Value   []byte
+
+	// Attributes are source-specific details about the message, e.g. the version of the S3
+	// object that it came from. The map may be shared between messages from the same source
+	// and must not be modified.
+	Attributes map[string]string
+
+	// Oversized is set if the message exceeded the consumer's max message size. If the consumer
+	// is configured to skip these messages, then Value will be nil.
+	Oversized bool
+
+	// valueBuffer is the pooled buffer that backs Value, if any
+	valueBuffer *[]byte
+}
+
+// Header is a key/value pair attached to a message.
+type Header struct {
+	Key   string
+	Value []byte
+}
+
+// Timestamp returns the best available timestamp for the message, i.e. the message time if
+// set and the modification time of its file or object otherwise.
+func (m Message) Timestamp() time.Time {
+	if !m.Time.IsZero() {
+		return m.Time
+	}
+	return m.ModTime
+}
+
+// SetPooledValue sets the message value to a copy of the argument contents that's stored in a
+// pooled buffer. This lets consumers that read records into temporary buffers avoid allocating a
+// new slice for each message.
+//
+// The message then owns the buffer until Release is called; after that, the value (and any
+// slices of it) must not be used.
+func (m *Message) SetPooledValue(contents []byte) {
+	buf := valuePool.Get().(*[]byte)
+	*buf = append((*buf)[:0], contents...)
+
+	m.Value = *buf
+	m.valueBuffer = buf
+}
+
+// Release returns the message's pooled value buffer, if any, so that it can be reused for later
+// messages. It should be called exactly once, by the final owner of the message, after the
+// message has been fully processed. It's a no-op for messages without pooled values.
+func (m *Message) Release() {
+	if m.valueBuffer == nil {
+		return
+	}
+
+	if cap(*m.valueBuffer) <= maxPooledValueSize {
+		valuePool.Put(m.valueBuffer)
+	}
+	m.Value = nil
+	m.valueBuffer = nil
+}
+
+// Clone returns a copy of the message with its own copy of the value, so that it can be kept
+// after the original message is released. The copy doesn't use a pooled buffer.
+func (m Message) Clone() Message {
+	clone := m
+	clone.valueBuffer = nil
+	if m.Value != nil {
+		clone.Value = append(make([]byte, 0, len(m.Value)), m.Value...)
+	}
+	return clone
+}
+
+// KafkaSource returns the source URI for the argument Kafka address and topic.
+func KafkaSource(address string, topic string) string {
+	return fmt.Sprintf("kafka://%s/%s", address, topic)
+}
+
+// S3Source returns the source URI for the argument S3 bucket and key.
+func S3Source(bucket string, key string) string {
+	return fmt.Sprintf("s3://%s/%s", bucket, key)
+}
+
+// FileSource returns the source URI for the argument local file path.
+func FileSource(path string) string {
+	return fmt.Sprintf("file://%s", path)
+}
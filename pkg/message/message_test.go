@@ -0,0 +1,63 @@
+package message
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageTimestamp(t *testing.T) {
+	msgTime := time.Date(2020, 10, 28, 20, 30, 5, 0, time.UTC)
+	modTime := time.Date(2020, 10, 29, 10, 12, 44, 0, time.UTC)
+
+	assert.Equal(t, msgTime, Message{Time: msgTime, ModTime: modTime}.Timestamp())
+	assert.Equal(t, modTime, Message{ModTime: modTime}.Timestamp())
+	assert.True(t, Message{}.Timestamp().IsZero())
+}
+
+func TestSources(t *testing.T) {
+	assert.Equal(t, "kafka://localhost:9092/test-topic", KafkaSource("localhost:9092", "test-topic"))
+	assert.Equal(t, "s3://test-bucket/prefix/key", S3Source("test-bucket", "prefix/key"))
+	assert.Equal(t, "file://dir/file.txt", FileSource("dir/file.txt"))
+}
+
+func TestPooledValue(t *testing.T) {
+	contents := []byte("value1")
+
+	msg := Message{}
+	msg.SetPooledValue(contents)
+	copy(contents, "value2")
+	assert.Equal(t, []byte("value1"), msg.Value)
+
+	msg.Release()
+	assert.Nil(t, msg.Value)
+
+	// Empty values are still distinct from skipped ones
+	msg.SetPooledValue([]byte{})
+	assert.NotNil(t, msg.Value)
+	assert.Equal(t, 0, len(msg.Value))
+	msg.Release()
+
+	// Releasing a message without a pooled value is a no-op
+	msg = Message{Value: []byte("value3")}
+	msg.Release()
+	assert.Equal(t, []byte("value3"), msg.Value)
+}
+
+func TestClone(t *testing.T) {
+	msg := Message{Offset: 5}
+	msg.SetPooledValue([]byte("value1"))
+
+	clone := msg.Clone()
+	msg.Release()
+	assert.Equal(t, []byte("value1"), clone.Value)
+	assert.Equal(t, int64(5), clone.Offset)
+
+	// Releasing the clone doesn't affect the pool
+	clone.Release()
+	assert.Equal(t, []byte("value1"), clone.Value)
+
+	// Skipped values stay skipped
+	assert.Nil(t, Message{}.Clone().Value)
+}
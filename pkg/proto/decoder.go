@@ -67,6 +67,19 @@ func (d *Decoder) ToJSON(contents []byte) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// ToJSONUnvalidated is like ToJSON, but if the decoder doesn't have any proto types, then the
+// contents are returned as-is without checking that they're valid JSON. The second return value
+// is whether the contents were validated; if not, the caller should check them with json.Valid
+// before relying on them, unless parsing them has already shown that they're valid.
+func (d *Decoder) ToJSONUnvalidated(contents []byte) ([]byte, bool, error) {
+	if len(d.typeInstances) == 0 {
+		return contents, false, nil
+	}
+
+	decoded, err := d.ToJSON(contents)
+	return decoded, true, err
+}
+
 func (d *Decoder) protoMessageFromBytes(
 	contents []byte,
 ) (proto.Message, error) {
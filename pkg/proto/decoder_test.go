@@ -51,6 +51,31 @@ func TestDecoder(t *testing.T) {
 	}
 }
 
+func TestDecoderUnvalidated(t *testing.T) {
+	decoder, err := NewDecoder([]string{""})
+	require.NoError(t, err)
+
+	// Without proto types, the contents are passed through as-is
+	result, validated, err := decoder.ToJSONUnvalidated([]byte(`bad json`))
+	require.NoError(t, err)
+	assert.False(t, validated)
+	assert.Equal(t, []byte(`bad json`), result)
+
+	decoder, err = NewDecoder([]string{"TestMessage1"})
+	require.NoError(t, err)
+
+	result, validated, err = decoder.ToJSONUnvalidated(
+		protoToBytes(t, &pb.TestMessage1{Key1: "value1"}),
+	)
+	require.NoError(t, err)
+	assert.True(t, validated)
+	assert.Equal(t, []byte(`{"key1":"value1"}`), result)
+
+	_, validated, err = decoder.ToJSONUnvalidated([]byte(`bad json`))
+	assert.Error(t, err)
+	assert.True(t, validated)
+}
+
 func protoToBytes(t *testing.T, msg proto.Message) []byte {
 	contents, err := proto.Marshal(msg)
 	require.NoError(t, err)
@@ -0,0 +1,220 @@
+package stats
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+const (
+	// Ratio between the false positive rates of consecutive filters in a ScalableBloomFilter
+	bloomTighteningRatio = 0.5
+
+	// Ratio between the capacities of consecutive filters in a ScalableBloomFilter
+	bloomGrowthFactor = 2
+)
+
+// ScalableBloomFilter is a set of strings that uses a fixed amount of memory per item, at the cost
+// of occasionally reporting that an item is present when it isn't. It's a scalable Bloom filter
+// (Almeida et al., 2007), so it doesn't need to know the number of items in advance: when a filter
+// fills up, a larger one with a lower false positive rate is added, which keeps the overall false
+// positive rate under the target.
+//
+// Items are hashed with a fixed seed instead of a random one, so the same items always set the
+// same bits and a filter can be saved with State and restored in another process.
+//
+// It isn't safe for concurrent use.
+type ScalableBloomFilter struct {
+	seed         uint64
+	filters      []*bloomFilter
+	nextCapacity int
+	nextFPRate   float64
+	count        int
+}
+
+// bloomFilter is a single, fixed-size Bloom filter.
+type bloomFilter struct {
+	bits      []uint64
+	numBits   uint64
+	numHashes int
+	capacity  int
+	count     int
+}
+
+// ScalableBloomFilterState is a serializable snapshot of the full state of a
+// ScalableBloomFilter. It's used to checkpoint long-running digs.
+type ScalableBloomFilterState struct {
+	Seed         uint64             `json:"seed"`
+	Filters      []BloomFilterState `json:"filters"`
+	NextCapacity int                `json:"nextCapacity"`
+	NextFPRate   float64            `json:"nextFPRate"`
+	Count        int                `json:"count"`
+}
+
+// BloomFilterState is the state of a single filter in a ScalableBloomFilterState. The bits are
+// stored as little-endian bytes so that they're base64-encoded in JSON.
+type BloomFilterState struct {
+	Bits      []byte `json:"bits"`
+	NumBits   uint64 `json:"numBits"`
+	NumHashes int    `json:"numHashes"`
+	Capacity  int    `json:"capacity"`
+	Count     int    `json:"count"`
+}
+
+// NewScalableBloomFilter returns a ScalableBloomFilter whose first filter holds the argument
+// number of items and whose overall false positive rate is at most fpRate. Filters with the same
+// seed hash items the same way, so their false positives are repeatable.
+func NewScalableBloomFilter(initialCapacity int, fpRate float64, seed uint64) *ScalableBloomFilter {
+	if initialCapacity < 1 {
+		initialCapacity = 1
+	}
+
+	return &ScalableBloomFilter{
+		seed:         seed,
+		nextCapacity: initialCapacity,
+		nextFPRate:   fpRate * (1 - bloomTighteningRatio),
+	}
+}
+
+// RestoreScalableBloomFilter returns a ScalableBloomFilter with the argument state.
+func RestoreScalableBloomFilter(state ScalableBloomFilterState) (*ScalableBloomFilter, error) {
+	filter := &ScalableBloomFilter{
+		seed:         state.Seed,
+		nextCapacity: state.NextCapacity,
+		nextFPRate:   state.NextFPRate,
+		count:        state.Count,
+	}
+
+	for _, filterState := range state.Filters {
+		numWords := (filterState.NumBits + 63) / 64
+		if filterState.NumBits == 0 || uint64(len(filterState.Bits)) != numWords*8 {
+			return nil, errors.New("Bloom filter state has the wrong number of bits")
+		}
+
+		bits := make([]uint64, numWords)
+		for w := range bits {
+			bits[w] = binary.LittleEndian.Uint64(filterState.Bits[w*8:])
+		}
+
+		filter.filters = append(
+			filter.filters,
+			&bloomFilter{
+				bits:      bits,
+				numBits:   filterState.NumBits,
+				numHashes: filterState.NumHashes,
+				capacity:  filterState.Capacity,
+				count:     filterState.Count,
+			},
+		)
+	}
+
+	return filter, nil
+}
+
+// Add adds an item to the filter and returns whether it was (probably) already present.
+func (s *ScalableBloomFilter) Add(item string) bool {
+	hash1 := MixHash(HashString(FNVOffset64^s.seed, item))
+	hash2 := MixHash(hash1) | 1
+
+	for _, filter := range s.filters {
+		if filter.contains(hash1, hash2) {
+			return true
+		}
+	}
+
+	if len(s.filters) == 0 || s.filters[len(s.filters)-1].full() {
+		s.filters = append(s.filters, newBloomFilter(s.nextCapacity, s.nextFPRate))
+		s.nextCapacity *= bloomGrowthFactor
+		s.nextFPRate *= bloomTighteningRatio
+	}
+
+	s.filters[len(s.filters)-1].add(hash1, hash2)
+	s.count++
+	return false
+}
+
+// Count returns the number of distinct items that have been added to the filter, not counting
+// the ones that were false positives.
+func (s *ScalableBloomFilter) Count() int {
+	return s.count
+}
+
+// State returns a snapshot of the current state of this filter.
+func (s *ScalableBloomFilter) State() ScalableBloomFilterState {
+	state := ScalableBloomFilterState{
+		Seed:         s.seed,
+		Filters:      []BloomFilterState{},
+		NextCapacity: s.nextCapacity,
+		NextFPRate:   s.nextFPRate,
+		Count:        s.count,
+	}
+
+	for _, filter := range s.filters {
+		bits := make([]byte, 0, len(filter.bits)*8)
+		for _, word := range filter.bits {
+			bits = binary.LittleEndian.AppendUint64(bits, word)
+		}
+
+		state.Filters = append(
+			state.Filters,
+			BloomFilterState{
+				Bits:      bits,
+				NumBits:   filter.numBits,
+				NumHashes: filter.numHashes,
+				Capacity:  filter.capacity,
+				Count:     filter.count,
+			},
+		)
+	}
+
+	return state
+}
+
+// SizeBytes returns the approximate amount of memory used by the filter.
+func (s *ScalableBloomFilter) SizeBytes() int {
+	size := 0
+	for _, filter := range s.filters {
+		size += len(filter.bits) * 8
+	}
+	return size
+}
+
+func newBloomFilter(capacity int, fpRate float64) *bloomFilter {
+	// See https://en.wikipedia.org/wiki/Bloom_filter#Optimal_number_of_hash_functions
+	numBits := uint64(math.Ceil(-float64(capacity) * math.Log(fpRate) / (math.Ln2 * math.Ln2)))
+	if numBits < 64 {
+		numBits = 64
+	}
+	numHashes := int(math.Ceil(float64(numBits) / float64(capacity) * math.Ln2))
+
+	return &bloomFilter{
+		bits:      make([]uint64, (numBits+63)/64),
+		numBits:   numBits,
+		numHashes: numHashes,
+		capacity:  capacity,
+	}
+}
+
+// add sets the bits for the item with the argument hashes. The bit positions are derived from
+// the two hashes with double hashing (Kirsch and Mitzenmacher, 2006).
+func (b *bloomFilter) add(hash1 uint64, hash2 uint64) {
+	for i := 0; i < b.numHashes; i++ {
+		bit := (hash1 + uint64(i)*hash2) % b.numBits
+		b.bits[bit/64] |= 1 << (bit % 64)
+	}
+	b.count++
+}
+
+func (b *bloomFilter) contains(hash1 uint64, hash2 uint64) bool {
+	for i := 0; i < b.numHashes; i++ {
+		bit := (hash1 + uint64(i)*hash2) % b.numBits
+		if b.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *bloomFilter) full() bool {
+	return b.count >= b.capacity
+}
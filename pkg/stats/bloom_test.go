@@ -0,0 +1,61 @@
+package stats
+
+import (
+	"fmt"
+	"testing"
+
+	sjson "github.com/segmentio/encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScalableBloomFilter(t *testing.T) {
+	// The hashes are deterministic for a given seed, so the number of false positives is too
+	for _, seed := range []uint64{0, 1, 12345} {
+		filter := NewScalableBloomFilter(100, 0.01, seed)
+
+		falsePositives := 0
+		for i := 0; i < 10000; i++ {
+			if filter.Add(fmt.Sprintf("item%d", i)) {
+				falsePositives++
+			}
+		}
+
+		// Items that were added are always found
+		for i := 0; i < 10000; i++ {
+			assert.True(t, filter.Add(fmt.Sprintf("item%d", i)))
+		}
+
+		// The filter grew past its initial capacity while keeping the false positive rate
+		// low; the expected number is well under 1% of the items, so allow a wide margin
+		assert.Less(t, falsePositives, 250, "seed %d", seed)
+		assert.Greater(t, len(filter.filters), 1)
+		assert.Equal(t, 10000-falsePositives, filter.Count())
+		assert.Greater(t, filter.SizeBytes(), 0)
+	}
+}
+
+func TestScalableBloomFilterState(t *testing.T) {
+	filter := NewScalableBloomFilter(10, 0.01, 7)
+	for i := 0; i < 100; i++ {
+		filter.Add(fmt.Sprintf("item%d", i))
+	}
+
+	stateBytes, err := sjson.Marshal(filter.State())
+	require.NoError(t, err)
+	state := ScalableBloomFilterState{}
+	require.NoError(t, sjson.Unmarshal(stateBytes, &state))
+
+	restored, err := RestoreScalableBloomFilter(state)
+	require.NoError(t, err)
+	assert.Equal(t, filter, restored)
+
+	for i := 0; i < 100; i++ {
+		assert.True(t, restored.Add(fmt.Sprintf("item%d", i)))
+	}
+	assert.Equal(t, filter.Count(), restored.Count())
+
+	state.Filters[0].Bits = state.Filters[0].Bits[1:]
+	_, err = RestoreScalableBloomFilter(state)
+	assert.Error(t, err)
+}
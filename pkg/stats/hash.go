@@ -0,0 +1,30 @@
+package stats
+
+const (
+	// FNVOffset64 is the initial value of a 64-bit FNV-1a hash.
+	FNVOffset64 = 14695981039346656037
+
+	fnvPrime64 = 1099511628211
+)
+
+// HashString adds the argument string to a 64-bit FNV-1a hash. Unlike hash/maphash, the result
+// doesn't depend on a per-process seed, so it can be used for decisions that need to be
+// repeatable across runs.
+func HashString(hash uint64, value string) uint64 {
+	for i := 0; i < len(value); i++ {
+		hash ^= uint64(value[i])
+		hash *= fnvPrime64
+	}
+	return hash
+}
+
+// MixHash scrambles the bits of the argument hash (using the splitmix64 finalizer) so that
+// similar inputs, like consecutive offsets, are spread evenly across the hash space.
+func MixHash(hash uint64) uint64 {
+	hash ^= hash >> 30
+	hash *= 0xbf58476d1ce4e5b9
+	hash ^= hash >> 27
+	hash *= 0x94d049bb133111eb
+	hash ^= hash >> 31
+	return hash
+}
@@ -25,7 +25,13 @@ func NewBucket(key string, value float64) *Bucket {
 
 // Update updates the counts and stats for this bucket for the argument value.
 func (i *Bucket) Update(value float64) {
-	i.Count++
+	i.UpdateWeighted(value, 1)
+}
+
+// UpdateWeighted is like Update, but it increases the count by the argument weight instead of
+// by one.
+func (i *Bucket) UpdateWeighted(value float64, weight int) {
+	i.Count += weight
 	i.Sum += value
 	if value < i.Min {
 		i.Min = value
@@ -1,34 +1,54 @@
 package stats
 
 import (
+	"sort"
 	"sync"
 	"time"
 
-	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/data-digger/pkg/message"
 )
 
-// MessageCounter is a type that stores counts by partition (or file or S3 key for non-Kafka
-// sources). It's used by the digger stats progress view.
+// MessageCounter is a type that stores counts by source and partition (or file or S3 key for
+// non-Kafka sources). It's used by the digger stats progress view.
 type MessageCounter struct {
 	sync.Mutex
 
 	totalMessages      int64
 	postFilterMessages int64
-	partitionCounters  map[int]*PartitionCounter
+	oversizedMessages  int64
+	partitionCounters  map[PartitionKey]*PartitionCounter
 }
 
 // MessageCounterSummary stores a summary of the message counts seen so far.
 type MessageCounterSummary struct {
 	TotalMessages      int64
 	PostFilterMessages int64
+	OversizedMessages  int64
 	FirstTime          time.Time
 	LastTime           time.Time
-	PartitionCounters  map[int]PartitionCounter
+	PartitionCounters  map[PartitionKey]PartitionCounter
+}
+
+// MessageCounterState is a serializable snapshot of the full state of a MessageCounter. It's
+// used to checkpoint long-running digs.
+type MessageCounterState struct {
+	TotalMessages      int64              `json:"totalMessages"`
+	PostFilterMessages int64              `json:"postFilterMessages"`
+	OversizedMessages  int64              `json:"oversizedMessages"`
+	PartitionCounters  []PartitionCounter `json:"partitionCounters"`
+}
+
+// PartitionKey identifies a partition (or file or S3 key) within a source.
+type PartitionKey struct {
+	Source      string
+	PartitionID int
 }
 
 // PartitionCounter stores detailed stats about the messages seen so far in a specific
 // partition (or file or S3 key).
 type PartitionCounter struct {
+	Source             string
+	Topic              string
 	PartitionID        int
 	TotalMessages      int64
 	PostFilterMessages int64
@@ -41,12 +61,12 @@ type PartitionCounter struct {
 // NewMessageCounter returns a new MessageCounter instance.
 func NewMessageCounter() *MessageCounter {
 	return &MessageCounter{
-		partitionCounters: map[int]*PartitionCounter{},
+		partitionCounters: map[PartitionKey]*PartitionCounter{},
 	}
 }
 
 // Update updates the counter for the provided message.
-func (m *MessageCounter) Update(msg kafka.Message, postFilter bool) {
+func (m *MessageCounter) Update(msg message.Message, postFilter bool) {
 	m.Mutex.Lock()
 	defer m.Mutex.Unlock()
 
@@ -56,20 +76,28 @@ func (m *MessageCounter) Update(msg kafka.Message, postFilter bool) {
 		m.postFilterMessages++
 	}
 
-	counter, ok := m.partitionCounters[msg.Partition]
+	key := PartitionKey{
+		Source:      msg.Source,
+		PartitionID: msg.Partition,
+	}
+	msgTime := msg.Timestamp()
+
+	counter, ok := m.partitionCounters[key]
 	if !ok {
 		counter = &PartitionCounter{
+			Source:        msg.Source,
+			Topic:         msg.Attributes["topic"],
 			PartitionID:   msg.Partition,
 			TotalMessages: 1,
 			FirstOffset:   msg.Offset,
 			LastOffset:    msg.Offset,
-			FirstTime:     msg.Time,
-			LastTime:      msg.Time,
+			FirstTime:     msgTime,
+			LastTime:      msgTime,
 		}
 		if postFilter {
 			counter.PostFilterMessages = 1
 		}
-		m.partitionCounters[msg.Partition] = counter
+		m.partitionCounters[key] = counter
 		return
 	}
 
@@ -84,14 +112,104 @@ func (m *MessageCounter) Update(msg kafka.Message, postFilter bool) {
 	if msg.Offset > counter.LastOffset {
 		counter.LastOffset = msg.Offset
 	}
-	if counter.FirstTime.IsZero() || msg.Time.Before(counter.FirstTime) {
-		counter.FirstTime = msg.Time
+	if counter.FirstTime.IsZero() || msgTime.Before(counter.FirstTime) {
+		counter.FirstTime = msgTime
 	}
-	if counter.LastTime.IsZero() || msg.Time.After(counter.LastTime) {
-		counter.LastTime = msg.Time
+	if counter.LastTime.IsZero() || msgTime.After(counter.LastTime) {
+		counter.LastTime = msgTime
 	}
 }
 
+// State returns a snapshot of the current state of this counter.
+func (m *MessageCounter) State() MessageCounterState {
+	m.Mutex.Lock()
+	defer m.Mutex.Unlock()
+
+	state := MessageCounterState{
+		TotalMessages:      m.totalMessages,
+		PostFilterMessages: m.postFilterMessages,
+		OversizedMessages:  m.oversizedMessages,
+		PartitionCounters:  []PartitionCounter{},
+	}
+	for _, counter := range m.partitionCounters {
+		state.PartitionCounters = append(state.PartitionCounters, *counter)
+	}
+	sort.Slice(state.PartitionCounters, func(a, b int) bool {
+		return state.PartitionCounters[a].PartitionID < state.PartitionCounters[b].PartitionID ||
+			(state.PartitionCounters[a].PartitionID == state.PartitionCounters[b].PartitionID &&
+				state.PartitionCounters[a].Source < state.PartitionCounters[b].Source)
+	})
+
+	return state
+}
+
+// Restore replaces the state of this counter with the argument one.
+func (m *MessageCounter) Restore(state MessageCounterState) {
+	m.Mutex.Lock()
+	defer m.Mutex.Unlock()
+
+	m.totalMessages = 0
+	m.postFilterMessages = 0
+	m.oversizedMessages = 0
+	m.partitionCounters = map[PartitionKey]*PartitionCounter{}
+
+	m.merge(state)
+}
+
+// Merge adds the counts in the argument state to this counter. It's used to combine the counters
+// kept by separate processing workers.
+func (m *MessageCounter) Merge(state MessageCounterState) {
+	m.Mutex.Lock()
+	defer m.Mutex.Unlock()
+
+	m.merge(state)
+}
+
+func (m *MessageCounter) merge(state MessageCounterState) {
+	m.totalMessages += state.TotalMessages
+	m.postFilterMessages += state.PostFilterMessages
+	m.oversizedMessages += state.OversizedMessages
+
+	for i := range state.PartitionCounters {
+		other := state.PartitionCounters[i]
+		key := PartitionKey{
+			Source:      other.Source,
+			PartitionID: other.PartitionID,
+		}
+
+		counter, ok := m.partitionCounters[key]
+		if !ok {
+			m.partitionCounters[key] = &other
+			continue
+		}
+
+		counter.TotalMessages += other.TotalMessages
+		counter.PostFilterMessages += other.PostFilterMessages
+
+		if other.FirstOffset < counter.FirstOffset {
+			counter.FirstOffset = other.FirstOffset
+		}
+		if other.LastOffset > counter.LastOffset {
+			counter.LastOffset = other.LastOffset
+		}
+		if counter.FirstTime.IsZero() ||
+			(!other.FirstTime.IsZero() && other.FirstTime.Before(counter.FirstTime)) {
+			counter.FirstTime = other.FirstTime
+		}
+		if counter.LastTime.IsZero() || other.LastTime.After(counter.LastTime) {
+			counter.LastTime = other.LastTime
+		}
+	}
+}
+
+// UpdateOversized records a message that exceeded the source's max message size.
+func (m *MessageCounter) UpdateOversized() {
+	m.Mutex.Lock()
+	defer m.Mutex.Unlock()
+
+	m.oversizedMessages++
+}
+
 // Summary returns a MessageCounterSummary instance based on the stats recorded
 // thus far for this counter.
 func (m *MessageCounter) Summary() MessageCounterSummary {
@@ -101,11 +219,12 @@ func (m *MessageCounter) Summary() MessageCounterSummary {
 	summary := MessageCounterSummary{
 		TotalMessages:      m.totalMessages,
 		PostFilterMessages: m.postFilterMessages,
-		PartitionCounters:  map[int]PartitionCounter{},
+		OversizedMessages:  m.oversizedMessages,
+		PartitionCounters:  map[PartitionKey]PartitionCounter{},
 	}
 
-	for partition, partitionCounter := range m.partitionCounters {
-		summary.PartitionCounters[partition] = *partitionCounter
+	for key, partitionCounter := range m.partitionCounters {
+		summary.PartitionCounters[key] = *partitionCounter
 
 		if summary.FirstTime.IsZero() || partitionCounter.FirstTime.Before(summary.FirstTime) {
 			summary.FirstTime = partitionCounter.FirstTime
@@ -4,15 +4,17 @@ import (
 	"testing"
 	"time"
 
-	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/data-digger/pkg/message"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestMessageCounter(t *testing.T) {
 	counter := NewMessageCounter()
+	attributes := map[string]string{"topic": "test-topic"}
 
 	counter.Update(
-		kafka.Message{
+		message.Message{
+			Source:    "kafka://localhost:9092/test-topic",
 			Partition: 2,
 			Offset:    123,
 			Time:      time.Unix(900, 0),
@@ -20,32 +22,43 @@ func TestMessageCounter(t *testing.T) {
 		true,
 	)
 	counter.Update(
-		kafka.Message{
-			Partition: 3,
-			Offset:    1234,
-			Time:      time.Unix(1000, 0),
+		message.Message{
+			Source:     "kafka://localhost:9092/test-topic",
+			Partition:  3,
+			Offset:     1234,
+			Time:       time.Unix(1000, 0),
+			Attributes: attributes,
 		},
 		true,
 	)
 	counter.Update(
-		kafka.Message{
-			Partition: 3,
-			Offset:    1235,
-			Time:      time.Unix(1100, 0),
+		message.Message{
+			Source:     "kafka://localhost:9092/test-topic",
+			Partition:  3,
+			Offset:     1235,
+			Time:       time.Unix(1100, 0),
+			Attributes: attributes,
 		},
 		false,
 	)
+	counter.UpdateOversized()
 
 	summary := counter.Summary()
 	assert.Equal(t, int64(3), summary.TotalMessages)
 	assert.Equal(t, int64(2), summary.PostFilterMessages)
+	assert.Equal(t, int64(1), summary.OversizedMessages)
 	assert.Equal(t, time.Unix(900, 0), summary.FirstTime)
 	assert.Equal(t, time.Unix(1100, 0), summary.LastTime)
 
-	part3Counter := summary.PartitionCounters[3]
+	part3Counter := summary.PartitionCounters[PartitionKey{
+		Source:      "kafka://localhost:9092/test-topic",
+		PartitionID: 3,
+	}]
 	assert.Equal(
 		t,
 		PartitionCounter{
+			Source:             "kafka://localhost:9092/test-topic",
+			Topic:              "test-topic",
 			PartitionID:        3,
 			TotalMessages:      2,
 			PostFilterMessages: 1,
@@ -57,3 +70,72 @@ func TestMessageCounter(t *testing.T) {
 		part3Counter,
 	)
 }
+
+func TestMessageCounterState(t *testing.T) {
+	counter := NewMessageCounter()
+	msg := message.Message{
+		Source:    "s3://test-bucket/key1",
+		Partition: 1,
+		Offset:    0,
+		ModTime:   time.Unix(900, 0),
+	}
+	counter.Update(msg, true)
+	counter.UpdateOversized()
+
+	restored := NewMessageCounter()
+	restored.Restore(counter.State())
+
+	msg.Offset = 1
+	restored.Update(msg, false)
+
+	summary := restored.Summary()
+	assert.Equal(t, int64(2), summary.TotalMessages)
+	assert.Equal(t, int64(1), summary.PostFilterMessages)
+	assert.Equal(t, int64(1), summary.OversizedMessages)
+
+	partitionCounter := summary.PartitionCounters[PartitionKey{
+		Source:      "s3://test-bucket/key1",
+		PartitionID: 1,
+	}]
+	assert.Equal(t, int64(2), partitionCounter.TotalMessages)
+	assert.Equal(t, int64(1), partitionCounter.LastOffset)
+}
+
+func TestMessageCounterMerge(t *testing.T) {
+	shard1 := NewMessageCounter()
+	shard1.Update(
+		message.Message{Source: "topic1", Partition: 0, Offset: 5, Time: time.Unix(500, 0)},
+		true,
+	)
+	shard1.Update(
+		message.Message{Source: "topic1", Partition: 1, Offset: 1, Time: time.Unix(100, 0)},
+		false,
+	)
+
+	shard2 := NewMessageCounter()
+	shard2.Update(
+		message.Message{Source: "topic1", Partition: 0, Offset: 2, Time: time.Unix(200, 0)},
+		true,
+	)
+	shard2.UpdateOversized()
+
+	merged := NewMessageCounter()
+	merged.Merge(shard1.State())
+	merged.Merge(shard2.State())
+
+	summary := merged.Summary()
+	assert.Equal(t, int64(3), summary.TotalMessages)
+	assert.Equal(t, int64(2), summary.PostFilterMessages)
+	assert.Equal(t, int64(1), summary.OversizedMessages)
+	assert.Equal(t, time.Unix(100, 0), summary.FirstTime)
+	assert.Equal(t, time.Unix(500, 0), summary.LastTime)
+
+	partitionCounter := summary.PartitionCounters[PartitionKey{
+		Source:      "topic1",
+		PartitionID: 0,
+	}]
+	assert.Equal(t, int64(2), partitionCounter.TotalMessages)
+	assert.Equal(t, int64(2), partitionCounter.FirstOffset)
+	assert.Equal(t, int64(5), partitionCounter.LastOffset)
+	assert.Equal(t, time.Unix(200, 0), partitionCounter.FirstTime)
+}
@@ -0,0 +1,133 @@
+package stats
+
+import (
+	"math"
+	"math/bits"
+)
+
+// NumSizeBuckets is the number of buckets in a SizeHistogram. Bucket 0 holds empty values, and
+// bucket i > 0 holds sizes in [2^(i-1), 2^i).
+const NumSizeBuckets = 64
+
+// SizeHistogram is a histogram of sizes (e.g., message lengths in bytes) with power-of-two
+// buckets. It uses a fixed amount of memory, and its percentiles are approximated by
+// interpolating within the buckets. It's serializable so that it can be checkpointed.
+//
+// It isn't safe for concurrent use.
+type SizeHistogram struct {
+	Buckets [NumSizeBuckets]int64 `json:"buckets"`
+	Count   int64                 `json:"count"`
+	Sum     int64                 `json:"sum"`
+	Min     int64                 `json:"min"`
+	Max     int64                 `json:"max"`
+}
+
+// SizeBucket returns the index of the histogram bucket for the argument size.
+func SizeBucket(size int64) int {
+	if size < 0 {
+		size = 0
+	}
+	return bits.Len64(uint64(size))
+}
+
+// SizeBucketBounds returns the smallest size in the argument bucket and the smallest size in the
+// next one.
+func SizeBucketBounds(bucket int) (int64, int64) {
+	switch {
+	case bucket == 0:
+		return 0, 1
+	case bucket == NumSizeBuckets-1:
+		// The upper bound of the last bucket doesn't fit in an int64
+		return 1 << (bucket - 1), math.MaxInt64
+	default:
+		return 1 << (bucket - 1), 1 << bucket
+	}
+}
+
+// Add adds a single size to the histogram.
+func (h *SizeHistogram) Add(size int64) {
+	if size < 0 {
+		size = 0
+	}
+
+	if h.Count == 0 || size < h.Min {
+		h.Min = size
+	}
+	if size > h.Max {
+		h.Max = size
+	}
+
+	h.Buckets[SizeBucket(size)]++
+	h.Count++
+	h.Sum += size
+}
+
+// Merge adds the sizes in another histogram to this one.
+func (h *SizeHistogram) Merge(other SizeHistogram) {
+	if other.Count == 0 {
+		return
+	}
+
+	if h.Count == 0 || other.Min < h.Min {
+		h.Min = other.Min
+	}
+	if other.Max > h.Max {
+		h.Max = other.Max
+	}
+
+	for b, count := range other.Buckets {
+		h.Buckets[b] += count
+	}
+	h.Count += other.Count
+	h.Sum += other.Sum
+}
+
+// Avg returns the average size in the histogram.
+func (h *SizeHistogram) Avg() float64 {
+	if h.Count == 0 {
+		return 0
+	}
+	return float64(h.Sum) / float64(h.Count)
+}
+
+// Percentile returns the approximate size at the argument percentile (between 0 and 100). The
+// sizes are assumed to be evenly spread within each bucket, and the result is kept between the
+// min and max sizes that were added.
+func (h *SizeHistogram) Percentile(p float64) int64 {
+	if h.Count == 0 {
+		return 0
+	}
+	if p <= 0 {
+		return h.Min
+	}
+	if p >= 100 {
+		return h.Max
+	}
+
+	rank := p / 100.0 * float64(h.Count)
+	var seen int64
+
+	for b, count := range h.Buckets {
+		if count == 0 || float64(seen+count) < rank {
+			seen += count
+			continue
+		}
+
+		lower, upper := SizeBucketBounds(b)
+		if lower < h.Min {
+			lower = h.Min
+		}
+		if upper > h.Max {
+			upper = h.Max
+		}
+
+		fraction := (rank - float64(seen)) / float64(count)
+		value := lower + int64(fraction*float64(upper-lower))
+		if value > h.Max {
+			value = h.Max
+		}
+		return value
+	}
+
+	return h.Max
+}
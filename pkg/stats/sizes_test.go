@@ -0,0 +1,75 @@
+package stats
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSizeBuckets(t *testing.T) {
+	type bucketTestCase struct {
+		size   int64
+		bucket int
+	}
+
+	testCases := []bucketTestCase{
+		{size: -1, bucket: 0},
+		{size: 0, bucket: 0},
+		{size: 1, bucket: 1},
+		{size: 2, bucket: 2},
+		{size: 3, bucket: 2},
+		{size: 1023, bucket: 10},
+		{size: 1024, bucket: 11},
+	}
+
+	for _, testCase := range testCases {
+		bucket := SizeBucket(testCase.size)
+		assert.Equal(t, testCase.bucket, bucket, "size %d", testCase.size)
+
+		lower, upper := SizeBucketBounds(bucket)
+		if testCase.size >= 0 {
+			assert.LessOrEqual(t, lower, testCase.size)
+			assert.Greater(t, upper, testCase.size)
+		}
+	}
+}
+
+func TestSizeHistogram(t *testing.T) {
+	histogram := &SizeHistogram{}
+	assert.Equal(t, int64(0), histogram.Percentile(50))
+	assert.Equal(t, 0.0, histogram.Avg())
+
+	for size := int64(1); size <= 1000; size++ {
+		histogram.Add(size)
+	}
+
+	assert.Equal(t, int64(1000), histogram.Count)
+	assert.Equal(t, int64(500500), histogram.Sum)
+	assert.Equal(t, int64(1), histogram.Min)
+	assert.Equal(t, int64(1000), histogram.Max)
+	assert.Equal(t, 500.5, histogram.Avg())
+
+	assert.Equal(t, int64(1), histogram.Percentile(0))
+	assert.Equal(t, int64(1000), histogram.Percentile(100))
+
+	// The percentiles are approximate, but they should be within their buckets
+	for _, p := range []float64{10, 50, 90, 99} {
+		expected := int64(p * 10)
+		value := histogram.Percentile(p)
+		assert.Equal(t, SizeBucket(expected), SizeBucket(value), "p%v", p)
+		assert.InDelta(t, expected, value, float64(expected)/2, "p%v", p)
+	}
+
+	other := &SizeHistogram{}
+	other.Add(0)
+	other.Add(5000)
+	histogram.Merge(*other)
+	histogram.Merge(SizeHistogram{})
+
+	assert.Equal(t, int64(1002), histogram.Count)
+	assert.Equal(t, int64(505500), histogram.Sum)
+	assert.Equal(t, int64(0), histogram.Min)
+	assert.Equal(t, int64(5000), histogram.Max)
+	assert.Equal(t, int64(1), histogram.Buckets[0])
+	assert.Equal(t, int64(1), histogram.Buckets[SizeBucket(5000)])
+}
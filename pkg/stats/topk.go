@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"container/heap"
 	"fmt"
+	"math"
 	"sort"
 	"strings"
 	"sync"
@@ -50,6 +51,16 @@ type TopKCounterSummary struct {
 	NumCategories int
 }
 
+// TopKCounterState is a serializable snapshot of the full state of a TopKCounter. It's used to
+// checkpoint long-running digs.
+type TopKCounterState struct {
+	Buckets      []Bucket `json:"buckets"`
+	TotalAdded   int      `json:"totalAdded"`
+	TotalRemoved int      `json:"totalRemoved"`
+	TotalMissing int      `json:"totalMissing"`
+	TotalInvalid int      `json:"totalInvalid"`
+}
+
 // NewTopKCounter creates a new TopKCounter instance for the argument k value.
 func NewTopKCounter(k int) *TopKCounter {
 	counter := &TopKCounter{
@@ -65,20 +76,27 @@ func NewTopKCounter(k int) *TopKCounter {
 // Add updates the counter state for the argument key and value. If the key is not currently
 // in the heap, then a bucket is created for it.
 func (t *TopKCounter) Add(key string, value float64) error {
+	return t.AddWeighted(key, value, 1)
+}
+
+// AddWeighted is like Add, but it increases the count for the key by the argument weight
+// instead of by one, so that the keys are ranked by their total weights (e.g., message sizes).
+func (t *TopKCounter) AddWeighted(key string, value float64, weight int) error {
 	t.Mutex.Lock()
 	defer t.Mutex.Unlock()
 
-	t.totalAdded++
+	t.totalAdded += weight
 
 	if key == MissingValue {
-		t.totalMissing++
+		t.totalMissing += weight
 	} else if key == InvalidValue {
-		t.totalInvalid++
+		t.totalInvalid += weight
 	}
 
 	bucket, ok := t.bucketsMap[key]
 	if !ok {
 		newBucket := NewBucket(key, value)
+		newBucket.Count = weight
 		t.bucketsMap[key] = newBucket
 
 		heap.Push(
@@ -86,7 +104,7 @@ func (t *TopKCounter) Add(key string, value float64) error {
 			newBucket,
 		)
 	} else {
-		bucket.Update(value)
+		bucket.UpdateWeighted(value, weight)
 		heap.Fix(t.bucketsHeap, bucket.Index)
 	}
 
@@ -157,9 +175,98 @@ func (t *TopKCounter) Summary() TopKCounterSummary {
 	}
 }
 
+// State returns a snapshot of the current state of this counter.
+func (t *TopKCounter) State() TopKCounterState {
+	t.Mutex.Lock()
+	defer t.Mutex.Unlock()
+
+	state := TopKCounterState{
+		Buckets:      []Bucket{},
+		TotalAdded:   t.totalAdded,
+		TotalRemoved: t.totalRemoved,
+		TotalMissing: t.totalMissing,
+		TotalInvalid: t.totalInvalid,
+	}
+	for _, bucket := range *t.bucketsHeap {
+		state.Buckets = append(state.Buckets, *bucket)
+	}
+
+	return state
+}
+
+// Restore replaces the state of this counter with the argument one.
+func (t *TopKCounter) Restore(state TopKCounterState) {
+	t.Mutex.Lock()
+	defer t.Mutex.Unlock()
+
+	t.bucketsHeap = &BucketsHeap{}
+	t.bucketsMap = map[string]*Bucket{}
+	t.totalAdded = 0
+	t.totalRemoved = 0
+	t.totalMissing = 0
+	t.totalInvalid = 0
+
+	t.merge(state)
+}
+
+// Merge adds the buckets and totals in the argument state to this counter. It's used to combine
+// the counters kept by separate processing workers.
+func (t *TopKCounter) Merge(state TopKCounterState) {
+	t.Mutex.Lock()
+	defer t.Mutex.Unlock()
+
+	t.merge(state)
+}
+
+func (t *TopKCounter) merge(state TopKCounterState) {
+	for i := range state.Buckets {
+		other := state.Buckets[i]
+
+		bucket, ok := t.bucketsMap[other.Key]
+		if !ok {
+			t.bucketsMap[other.Key] = &other
+			heap.Push(t.bucketsHeap, &other)
+			continue
+		}
+
+		if other.Min < bucket.Min {
+			bucket.Min = other.Min
+		}
+		if other.Max > bucket.Max {
+			bucket.Max = other.Max
+		}
+		bucket.Count += other.Count
+		bucket.Sum += other.Sum
+		heap.Fix(t.bucketsHeap, bucket.Index)
+	}
+
+	t.totalAdded += state.TotalAdded
+	t.totalRemoved += state.TotalRemoved
+	t.totalMissing += state.TotalMissing
+	t.totalInvalid += state.TotalInvalid
+
+	if len(*t.bucketsHeap) > 200*t.k {
+		t.Clean(100 * t.k)
+	}
+}
+
 // PrettyTable returns a pretty table that summarizes the stats for the top k
-// values in this counter instance.
-func (t *TopKCounter) PrettyTable(n int, numeric bool, sortByName bool) string {
+// values in this counter instance. If sampleRate is below 1, then the counts are
+// from a sample of the messages, and a column with the counts extrapolated to all
+// messages is added next to them. The count columns are labeled with countLabel,
+// e.g. "Bytes" for counters that are weighted by size; it defaults to "Count".
+func (t *TopKCounter) PrettyTable(
+	n int,
+	numeric bool,
+	sortByName bool,
+	sampleRate float64,
+	countLabel string,
+) string {
+	if countLabel == "" {
+		countLabel = "Count"
+	}
+	sampled := sampleRate > 0 && sampleRate < 1
+
 	if numeric && n > 1 {
 		// Don't create column for the numeric dimension
 		n--
@@ -190,9 +297,14 @@ func (t *TopKCounter) PrettyTable(n int, numeric bool, sortByName bool) string {
 		)
 	}
 
+	if sampled {
+		header = append(header, "Sampled "+countLabel, "Estimated "+countLabel)
+	} else {
+		header = append(header, countLabel)
+	}
+
 	header = append(
 		header,
-		"Count",
 		"Percent",
 		"Cumulative",
 	)
@@ -258,9 +370,13 @@ func (t *TopKCounter) PrettyTable(n int, numeric bool, sortByName bool) string {
 			}
 		}
 
+		row = append(row, fmt.Sprintf("%d", bucket.Count))
+		if sampled {
+			row = append(row, fmt.Sprintf("%d", EstimateCount(int64(bucket.Count), sampleRate)))
+		}
+
 		row = append(
 			row,
-			fmt.Sprintf("%d", bucket.Count),
 			fmt.Sprintf("%0.2f%%", percent),
 			fmt.Sprintf("%0.2f%%", cumlPercent),
 		)
@@ -271,3 +387,12 @@ func (t *TopKCounter) PrettyTable(n int, numeric bool, sortByName bool) string {
 	table.Render()
 	return string(bytes.TrimRight(buf.Bytes(), "\n"))
 }
+
+// EstimateCount extrapolates a count from a sample of messages, taken at the argument
+// rate, to all of the messages.
+func EstimateCount(count int64, sampleRate float64) int64 {
+	if sampleRate <= 0 || sampleRate >= 1 {
+		return count
+	}
+	return int64(math.Round(float64(count) / sampleRate))
+}
@@ -113,3 +113,112 @@ func TestTopKCounter(t *testing.T) {
 		bucketsByName,
 	)
 }
+
+func TestTopKCounterState(t *testing.T) {
+	counter := NewTopKCounter(4)
+	counter.Add("a", 1.0)
+	counter.Add("a", 3.0)
+	counter.Add("b", 2.0)
+	counter.Add(MissingValue, 1.0)
+
+	restored := NewTopKCounter(4)
+	restored.Restore(counter.State())
+	restored.Add("b", 1.0)
+	restored.Add("b", 1.0)
+
+	assert.Equal(
+		t,
+		TopKCounterSummary{
+			TotalAdded:    6,
+			TotalMissing:  1,
+			NumCategories: 3,
+		},
+		restored.Summary(),
+	)
+
+	buckets := restored.Buckets(4, false)
+	assert.Equal(t, "b", buckets[0].Key)
+	assert.Equal(t, 3, buckets[0].Count)
+	assert.Equal(t, "a", buckets[1].Key)
+	assert.Equal(t, 4.0, buckets[1].Sum)
+}
+
+func TestTopKCounterMerge(t *testing.T) {
+	shard1 := NewTopKCounter(4)
+	shard1.Add("a", 1.0)
+	shard1.Add("b", 5.0)
+	shard1.Add(InvalidValue, 1.0)
+
+	shard2 := NewTopKCounter(4)
+	shard2.Add("a", 3.0)
+	shard2.Add("a", -1.0)
+	shard2.Add("c", 2.0)
+
+	merged := NewTopKCounter(4)
+	merged.Merge(shard1.State())
+	merged.Merge(shard2.State())
+
+	assert.Equal(
+		t,
+		TopKCounterSummary{
+			TotalAdded:    6,
+			TotalInvalid:  1,
+			NumCategories: 4,
+		},
+		merged.Summary(),
+	)
+
+	buckets := merged.Buckets(4, false)
+	assert.Equal(t, "a", buckets[0].Key)
+	assert.Equal(t, 3, buckets[0].Count)
+	assert.Equal(t, -1.0, buckets[0].Min)
+	assert.Equal(t, 3.0, buckets[0].Max)
+	assert.Equal(t, 3.0, buckets[0].Sum)
+}
+
+func TestTopKCounterPrettyTableSampled(t *testing.T) {
+	counter := NewTopKCounter(4)
+	for i := 0; i < 3; i++ {
+		counter.Add("a", 1.0)
+	}
+	counter.Add("b", 1.0)
+
+	table := counter.PrettyTable(1, false, false, 1.0, "")
+	assert.Contains(t, table, "COUNT")
+	assert.NotContains(t, table, "ESTIMATED COUNT")
+
+	table = counter.PrettyTable(1, false, false, 0.01, "")
+	assert.Contains(t, table, "SAMPLED COUNT")
+	assert.Contains(t, table, "ESTIMATED COUNT")
+	assert.Contains(t, table, "300")
+	assert.Contains(t, table, "100")
+}
+
+func TestEstimateCount(t *testing.T) {
+	assert.Equal(t, int64(300), EstimateCount(3, 0.01))
+	assert.Equal(t, int64(7), EstimateCount(2, 0.3))
+	assert.Equal(t, int64(3), EstimateCount(3, 1.0))
+	assert.Equal(t, int64(3), EstimateCount(3, 0))
+}
+
+func TestTopKCounterWeighted(t *testing.T) {
+	counter := NewTopKCounter(4)
+	counter.AddWeighted("small", 10.0, 10)
+	counter.AddWeighted("small", 20.0, 20)
+	counter.AddWeighted("small", 10.0, 10)
+	counter.AddWeighted("large", 500.0, 500)
+
+	// Keys are ranked by their total weights
+	buckets := counter.Buckets(4, false)
+	assert.Equal(t, "large", buckets[0].Key)
+	assert.Equal(t, 500, buckets[0].Count)
+	assert.Equal(t, "small", buckets[1].Key)
+	assert.Equal(t, 40, buckets[1].Count)
+	assert.Equal(t, 10.0, buckets[1].Min)
+	assert.Equal(t, 20.0, buckets[1].Max)
+	assert.Equal(t, 540, counter.Summary().TotalAdded)
+
+	table := counter.PrettyTable(1, false, false, 1.0, "Bytes")
+	assert.Contains(t, table, "BYTES")
+	assert.Contains(t, table, "92.59%")
+}
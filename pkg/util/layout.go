@@ -0,0 +1,267 @@
+package util
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Time units that can appear in a key layout, from coarsest to finest
+const (
+	unitNone = iota
+	unitYear
+	unitMonth
+	unitDay
+	unitHour
+	unitMinute
+)
+
+type layoutToken struct {
+	name string
+	unit int
+}
+
+var layoutTokens = []layoutToken{
+	{name: "YYYY", unit: unitYear},
+	{name: "MM", unit: unitMonth},
+	{name: "DD", unit: unitDay},
+	{name: "HH", unit: unitHour},
+	{name: "mm", unit: unitMinute},
+}
+
+// layoutPart is either a literal string or, if unit is set, a time token.
+type layoutPart struct {
+	literal string
+	unit    int
+}
+
+var layoutGroupRegexp = regexp.MustCompile(`\{[^}]*\}`)
+
+// KeyLayout is a template for time-partitioned object keys, e.g. dt={YYYY-MM-DD}/hour={HH}.
+// Each group in braces contains time tokens (YYYY, MM, DD, HH, or mm) separated by literal
+// characters; everything outside of braces is matched literally.
+type KeyLayout struct {
+	layout string
+	parts  []layoutPart
+	regexp *regexp.Regexp
+	units  []int
+	unit   int
+}
+
+// ParseKeyLayout parses a key layout template.
+func ParseKeyLayout(layout string) (*KeyLayout, error) {
+	keyLayout := &KeyLayout{layout: layout}
+
+	exprBuilder := &strings.Builder{}
+	seen := map[int]bool{}
+
+	lastEnd := 0
+	for _, match := range layoutGroupRegexp.FindAllStringIndex(layout, -1) {
+		keyLayout.addLiteral(layout[lastEnd:match[0]], exprBuilder)
+
+		group := layout[match[0]+1 : match[1]-1]
+		for len(group) > 0 {
+			token, ok := matchLayoutToken(group)
+			if !ok {
+				// Literal separator within the group
+				keyLayout.addLiteral(group[0:1], exprBuilder)
+				group = group[1:]
+				continue
+			}
+
+			if seen[token.unit] {
+				return nil, fmt.Errorf("Layout contains %s more than once: %s", token.name, layout)
+			}
+			seen[token.unit] = true
+
+			keyLayout.parts = append(keyLayout.parts, layoutPart{unit: token.unit})
+			exprBuilder.WriteString(fmt.Sprintf(`(\d{%d})`, len(token.name)))
+			keyLayout.units = append(keyLayout.units, token.unit)
+			group = group[len(token.name):]
+		}
+
+		lastEnd = match[1]
+	}
+
+	keyLayout.addLiteral(layout[lastEnd:], exprBuilder)
+
+	if len(keyLayout.units) == 0 {
+		return nil, fmt.Errorf("Layout doesn't contain any time tokens: %s", layout)
+	}
+
+	// Units need to be contiguous from the year down so that each key maps to a single range
+	for unit := unitYear; unit <= unitMinute; unit++ {
+		if seen[unit] {
+			keyLayout.unit = unit
+		} else {
+			break
+		}
+	}
+	if keyLayout.unit == unitNone || len(seen) != keyLayout.unit {
+		return nil, fmt.Errorf(
+			"Layout must contain contiguous tokens starting from YYYY: %s",
+			layout,
+		)
+	}
+
+	var err error
+	keyLayout.regexp, err = regexp.Compile(exprBuilder.String())
+	if err != nil {
+		return nil, err
+	}
+
+	return keyLayout, nil
+}
+
+func matchLayoutToken(group string) (layoutToken, bool) {
+	for _, token := range layoutTokens {
+		if strings.HasPrefix(group, token.name) {
+			return token, true
+		}
+	}
+	return layoutToken{}, false
+}
+
+func (l *KeyLayout) addLiteral(literal string, exprBuilder *strings.Builder) {
+	if literal == "" {
+		return
+	}
+	l.parts = append(l.parts, layoutPart{literal: literal})
+	exprBuilder.WriteString(regexp.QuoteMeta(literal))
+}
+
+// String returns the original layout template.
+func (l *KeyLayout) String() string {
+	return l.layout
+}
+
+// Time returns the start of the time range that the argument key falls into, based on the
+// first match of the layout in the key. If the layout doesn't match, false is returned.
+func (l *KeyLayout) Time(key string) (time.Time, bool) {
+	groups := l.regexp.FindStringSubmatch(key)
+	if groups == nil {
+		return time.Time{}, false
+	}
+
+	values := map[int]int{
+		unitMonth: 1,
+		unitDay:   1,
+	}
+	for i, unit := range l.units {
+		value, err := strconv.Atoi(groups[i+1])
+		if err != nil {
+			return time.Time{}, false
+		}
+		values[unit] = value
+	}
+
+	t := time.Date(
+		values[unitYear],
+		time.Month(values[unitMonth]),
+		values[unitDay],
+		values[unitHour],
+		values[unitMinute],
+		0,
+		0,
+		time.UTC,
+	)
+
+	// Reject invalid dates like month 13 that time.Date normalizes
+	if l.Format(t) != groups[0] {
+		return time.Time{}, false
+	}
+
+	return t, true
+}
+
+// Truncate returns the start of the time range that contains the argument time.
+func (l *KeyLayout) Truncate(t time.Time) time.Time {
+	t = t.UTC()
+
+	switch l.unit {
+	case unitYear:
+		return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+	case unitMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	case unitDay:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	case unitHour:
+		return t.Truncate(time.Hour)
+	default:
+		return t.Truncate(time.Minute)
+	}
+}
+
+// Next returns the start of the time range after the one that starts at the argument time.
+func (l *KeyLayout) Next(t time.Time) time.Time {
+	switch l.unit {
+	case unitYear:
+		return t.AddDate(1, 0, 0)
+	case unitMonth:
+		return t.AddDate(0, 1, 0)
+	case unitDay:
+		return t.AddDate(0, 0, 1)
+	case unitHour:
+		return t.Add(time.Hour)
+	default:
+		return t.Add(time.Minute)
+	}
+}
+
+// Format returns the layout filled in with the argument time.
+func (l *KeyLayout) Format(t time.Time) string {
+	t = t.UTC()
+	builder := &strings.Builder{}
+
+	for _, part := range l.parts {
+		switch part.unit {
+		case unitYear:
+			fmt.Fprintf(builder, "%04d", t.Year())
+		case unitMonth:
+			fmt.Fprintf(builder, "%02d", int(t.Month()))
+		case unitDay:
+			fmt.Fprintf(builder, "%02d", t.Day())
+		case unitHour:
+			fmt.Fprintf(builder, "%02d", t.Hour())
+		case unitMinute:
+			fmt.Fprintf(builder, "%02d", t.Minute())
+		default:
+			builder.WriteString(part.literal)
+		}
+	}
+
+	return builder.String()
+}
+
+// Overlaps returns whether the time range that starts at the argument time overlaps the
+// argument since and until times. Zero values for since or until are treated as unbounded.
+func (l *KeyLayout) Overlaps(start time.Time, since time.Time, until time.Time) bool {
+	if !since.IsZero() && !l.Next(start).After(since) {
+		return false
+	}
+	if !until.IsZero() && start.After(until) {
+		return false
+	}
+	return true
+}
+
+// Prefixes returns the formatted layouts for all of the time ranges that overlap the argument
+// since and until times. If there are more than maxPrefixes of them, false is returned.
+func (l *KeyLayout) Prefixes(
+	since time.Time,
+	until time.Time,
+	maxPrefixes int,
+) ([]string, bool) {
+	prefixes := []string{}
+
+	for t := l.Truncate(since); !t.After(until); t = l.Next(t) {
+		if len(prefixes) >= maxPrefixes {
+			return nil, false
+		}
+		prefixes = append(prefixes, l.Format(t))
+	}
+
+	return prefixes, true
+}
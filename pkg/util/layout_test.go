@@ -0,0 +1,125 @@
+package util
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseKeyLayout(t *testing.T) {
+	_, err := ParseKeyLayout("dt={YYYY-MM-DD}/hour={HH}")
+	require.NoError(t, err)
+	_, err = ParseKeyLayout("{YYYY}/{MM}")
+	require.NoError(t, err)
+
+	_, err = ParseKeyLayout("logs/")
+	assert.Error(t, err)
+	_, err = ParseKeyLayout("dt={YYYY-DD}")
+	assert.Error(t, err)
+	_, err = ParseKeyLayout("{YYYY}/{YYYY}")
+	assert.Error(t, err)
+}
+
+func TestKeyLayoutTime(t *testing.T) {
+	type testCase struct {
+		layout       string
+		key          string
+		expectedTime time.Time
+		expectedOK   bool
+	}
+
+	testCases := []testCase{
+		{
+			layout:       "dt={YYYY-MM-DD}/hour={HH}",
+			key:          "logs/dt=2024-05-01/hour=13/part-0000.gz",
+			expectedTime: time.Date(2024, 5, 1, 13, 0, 0, 0, time.UTC),
+			expectedOK:   true,
+		},
+		{
+			layout:       "{YYYY}/{MM}/{DD}",
+			key:          "logs/2024/05/01/part-0000.gz",
+			expectedTime: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+			expectedOK:   true,
+		},
+		{
+			layout:       "month={YYYY-MM}",
+			key:          "month=2024-12/part-0000.gz",
+			expectedTime: time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC),
+			expectedOK:   true,
+		},
+		{
+			layout:     "dt={YYYY-MM-DD}/hour={HH}",
+			key:        "logs/dt=2024-05-01/part-0000.gz",
+			expectedOK: false,
+		},
+		{
+			layout:     "dt={YYYY-MM-DD}",
+			key:        "logs/dt=2024-13-01/part-0000.gz",
+			expectedOK: false,
+		},
+	}
+
+	for _, testCase := range testCases {
+		layout, err := ParseKeyLayout(testCase.layout)
+		require.NoError(t, err)
+
+		keyTime, ok := layout.Time(testCase.key)
+		assert.Equal(t, testCase.expectedOK, ok, testCase.key)
+		assert.Equal(t, testCase.expectedTime, keyTime, testCase.key)
+	}
+}
+
+func TestKeyLayoutPrefixes(t *testing.T) {
+	layout, err := ParseKeyLayout("dt={YYYY-MM-DD}/hour={HH}")
+	require.NoError(t, err)
+
+	prefixes, ok := layout.Prefixes(
+		time.Date(2024, 5, 1, 22, 30, 0, 0, time.UTC),
+		time.Date(2024, 5, 2, 1, 0, 0, 0, time.UTC),
+		10,
+	)
+	assert.True(t, ok)
+	assert.Equal(
+		t,
+		[]string{
+			"dt=2024-05-01/hour=22",
+			"dt=2024-05-01/hour=23",
+			"dt=2024-05-02/hour=00",
+			"dt=2024-05-02/hour=01",
+		},
+		prefixes,
+	)
+
+	_, ok = layout.Prefixes(
+		time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC),
+		10,
+	)
+	assert.False(t, ok)
+
+	dayLayout, err := ParseKeyLayout("{YYYY}/{MM}/{DD}/")
+	require.NoError(t, err)
+
+	prefixes, ok = dayLayout.Prefixes(
+		time.Date(2024, 2, 28, 12, 0, 0, 0, time.UTC),
+		time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		10,
+	)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"2024/02/28/", "2024/02/29/", "2024/03/01/"}, prefixes)
+}
+
+func TestKeyLayoutOverlaps(t *testing.T) {
+	layout, err := ParseKeyLayout("dt={YYYY-MM-DD}/hour={HH}")
+	require.NoError(t, err)
+
+	start := time.Date(2024, 5, 1, 13, 0, 0, 0, time.UTC)
+
+	assert.True(t, layout.Overlaps(start, time.Time{}, time.Time{}))
+	assert.True(t, layout.Overlaps(start, start.Add(30*time.Minute), time.Time{}))
+	assert.False(t, layout.Overlaps(start, start.Add(time.Hour), time.Time{}))
+	assert.True(t, layout.Overlaps(start, time.Time{}, start))
+	assert.False(t, layout.Overlaps(start, time.Time{}, start.Add(-time.Minute)))
+}
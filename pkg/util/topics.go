@@ -0,0 +1,120 @@
+package util
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Characters that indicate that a topic is a regexp instead of a literal name. Dots are left
+// out since they're common in topic names.
+const topicRegexpChars = `*+?()[]{}|^$\`
+
+// TopicMatcher matches Kafka topic names against a list of names and regexps.
+type TopicMatcher struct {
+	regexps  []*regexp.Regexp
+	literals []string
+}
+
+// ParseTopics parses a comma-separated list of topic names and regexps. Entries that contain
+// regexp special characters (other than dots) are treated as regexps that need to match the
+// full topic name; the others need to match exactly. Commas inside of braces, brackets, or
+// parentheses are part of the regexp, e.g. "events-{1,3}" is a single entry.
+func ParseTopics(topicsStr string) (*TopicMatcher, error) {
+	matcher := &TopicMatcher{}
+	hasRegexp := false
+
+	topicStrs, err := splitTopics(topicsStr)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, topicStr := range topicStrs {
+		topicStr = strings.TrimSpace(topicStr)
+		if topicStr == "" {
+			continue
+		}
+
+		var expr string
+		if strings.ContainsAny(topicStr, topicRegexpChars) {
+			expr = fmt.Sprintf("^(?:%s)$", topicStr)
+			hasRegexp = true
+		} else {
+			expr = fmt.Sprintf("^%s$", regexp.QuoteMeta(topicStr))
+			matcher.literals = append(matcher.literals, topicStr)
+		}
+
+		topicRegexp, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid topic regexp %s: %+v", topicStr, err)
+		}
+		matcher.regexps = append(matcher.regexps, topicRegexp)
+	}
+
+	if len(matcher.regexps) == 0 {
+		return nil, fmt.Errorf("At least one topic is required")
+	}
+	if hasRegexp {
+		matcher.literals = nil
+	}
+
+	return matcher, nil
+}
+
+// splitTopics splits a list of topics on the commas that aren't escaped or inside of a regexp
+// group, class, or repetition.
+func splitTopics(topicsStr string) ([]string, error) {
+	topicStrs := []string{}
+	closers := []rune{}
+	escaped := false
+	start := 0
+
+	for i, c := range topicsStr {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case len(closers) > 0 && closers[len(closers)-1] == ']':
+			// Only the end of a character class is special inside of it
+			if c == ']' {
+				closers = closers[:len(closers)-1]
+			}
+		case c == '(':
+			closers = append(closers, ')')
+		case c == '[':
+			closers = append(closers, ']')
+		case c == '{':
+			closers = append(closers, '}')
+		case c == ')' || c == '}':
+			if len(closers) == 0 || closers[len(closers)-1] != c {
+				return nil, fmt.Errorf("Unbalanced %c in topics: %s", c, topicsStr)
+			}
+			closers = closers[:len(closers)-1]
+		case c == ',' && len(closers) == 0:
+			topicStrs = append(topicStrs, topicsStr[start:i])
+			start = i + 1
+		}
+	}
+	if len(closers) > 0 {
+		return nil, fmt.Errorf("Unbalanced brackets in topics: %s", topicsStr)
+	}
+
+	return append(topicStrs, topicsStr[start:]), nil
+}
+
+// Literals returns the topic names if none of the entries are regexps, so that the partitions
+// of just these topics can be fetched. It returns nil if there are any regexps.
+func (t *TopicMatcher) Literals() []string {
+	return t.literals
+}
+
+// Match returns whether the argument topic matches any of the names or regexps.
+func (t *TopicMatcher) Match(topic string) bool {
+	for _, topicRegexp := range t.regexps {
+		if topicRegexp.MatchString(topic) {
+			return true
+		}
+	}
+	return false
+}
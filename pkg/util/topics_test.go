@@ -0,0 +1,48 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTopics(t *testing.T) {
+	matcher, err := ParseTopics("events.us,events-eu-.*, logs-[0-9]+")
+	require.NoError(t, err)
+
+	assert.True(t, matcher.Match("events.us"))
+	assert.False(t, matcher.Match("events-us"))
+	assert.False(t, matcher.Match("events.us.2"))
+	assert.True(t, matcher.Match("events-eu-1"))
+	assert.True(t, matcher.Match("events-eu-"))
+	assert.False(t, matcher.Match("old-events-eu-1"))
+	assert.True(t, matcher.Match("logs-12"))
+	assert.False(t, matcher.Match("logs-x"))
+
+	assert.Nil(t, matcher.Literals())
+
+	matcher, err = ParseTopics("events.us, events.eu")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"events.us", "events.eu"}, matcher.Literals())
+
+	// Commas inside of regexp repetitions, classes, and groups don't split the entries
+	matcher, err = ParseTopics(`events-\d{1,3},logs-[,x],(a|b,c),d\,e`)
+	require.NoError(t, err)
+	assert.Nil(t, matcher.Literals())
+	assert.True(t, matcher.Match("events-12"))
+	assert.False(t, matcher.Match("events-1234"))
+	assert.True(t, matcher.Match("logs-,"))
+	assert.True(t, matcher.Match("b,c"))
+	assert.True(t, matcher.Match("d,e"))
+	assert.False(t, matcher.Match("d"))
+
+	_, err = ParseTopics("")
+	assert.Error(t, err)
+	_, err = ParseTopics("events-(")
+	assert.Error(t, err)
+	_, err = ParseTopics("events-{1,3")
+	assert.Error(t, err)
+	_, err = ParseTopics("events-1}")
+	assert.Error(t, err)
+}